@@ -19,7 +19,9 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -29,10 +31,13 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 	"github.com/percona/pmm-client/pmm"
+	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/tests/fakeapi"
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
@@ -40,6 +45,99 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+// concurrencyCounter tracks the maximum number of calls running at once.
+type concurrencyCounter struct {
+	m       sync.Mutex
+	running int
+	max     int
+}
+
+func (c *concurrencyCounter) call() {
+	c.m.Lock()
+	c.running++
+	if c.running > c.max {
+		c.max = c.running
+	}
+	c.m.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.m.Lock()
+	c.running--
+	c.m.Unlock()
+}
+
+func TestAddFrom(t *testing.T) {
+	manifest := &pmm.Manifest{
+		Services: []pmm.ManifestService{
+			{Type: "mysql:metrics", Name: "db01"},
+			{Type: "mysql:metrics", Name: "db02"},
+			{Type: "mysql:metrics", Name: "db03"},
+			{Type: "mysql:metrics", Name: "db04"},
+			{Type: "mysql:metrics", Name: "db05"},
+			{Type: "mysql:metrics", Name: "db06"},
+		},
+	}
+	counter := &concurrencyCounter{}
+	var addedM sync.Mutex
+	added := map[string]bool{}
+	newAdd := func(svc pmm.ManifestService) (addFunc, error) {
+		if svc.Name == "db03" {
+			return nil, errors.New("invalid flags")
+		}
+		return func(ctx context.Context) (*plugin.Info, error) {
+			counter.call()
+			addedM.Lock()
+			added[svc.Name] = true
+			addedM.Unlock()
+			switch svc.Name {
+			case "db02":
+				return nil, pmm.ErrDuplicate
+			case "db04":
+				return nil, errors.New("connection refused")
+			}
+			return &plugin.Info{}, nil
+		}, nil
+	}
+
+	assert.Equal(t, 2, addFrom(manifest, 2, newAdd))
+	assert.Equal(t, map[string]bool{"db01": true, "db02": true, "db04": true, "db05": true, "db06": true}, added)
+	assert.True(t, counter.max <= 2, "%d", counter.max)
+}
+
+func TestSerializeAdd(t *testing.T) {
+	manifest := &pmm.Manifest{
+		Services: []pmm.ManifestService{
+			{Type: "mysql:metrics", Name: "db01"},
+			{Type: "mysql:queries", Name: "db01"},
+			{Type: "mysql:metrics", Name: "db02"},
+			{Type: "mysql:queries", Name: "db02"},
+		},
+	}
+
+	// Services of the same server are added one by one even if concurrency allows more.
+	counter := &concurrencyCounter{}
+	newAdd := func(svc pmm.ManifestService) (addFunc, error) {
+		return serializeAdd("mysql/localhost/3306/", func(ctx context.Context) (*plugin.Info, error) {
+			counter.call()
+			return &plugin.Info{}, nil
+		}), nil
+	}
+	assert.Equal(t, 0, addFrom(manifest, 4, newAdd))
+	assert.Equal(t, 1, counter.max)
+
+	// Services of different servers are not serialized.
+	counter = &concurrencyCounter{}
+	newAdd = func(svc pmm.ManifestService) (addFunc, error) {
+		return serializeAdd("mysql/"+svc.Name, func(ctx context.Context) (*plugin.Info, error) {
+			counter.call()
+			return &plugin.Info{}, nil
+		}), nil
+	}
+	assert.Equal(t, 0, addFrom(manifest, 4, newAdd))
+	assert.Equal(t, 2, counter.max)
+}
+
 type pmmAdminData struct {
 	bin     string
 	rootDir string
@@ -170,23 +268,34 @@ func testHelp(t *testing.T, data pmmAdminData) {
   pmm-admin \[command\]
 
 Available Commands:
-  config         Configure PMM Client.
-  add            Add service to monitoring.
-  annotate       Annotate application events.
-  remove         Remove service from monitoring.
-  list           List monitoring services for this system.
-  info           Display PMM Client information \(works offline\).
-  check-network  Check network connectivity between client and server.
-  ping           Check if PMM server is alive.
-  start          Start monitoring service.
-  stop           Stop monitoring service.
-  restart        Restart monitoring service.
-  show-passwords Show PMM Client password information \(works offline\).
-  purge          Purge metrics data on PMM server.
-  repair         Repair installation.
-  uninstall      Removes all monitoring services with the best effort.
-  summary        Fetch system data for diagnostics.
-  help           Help about any command
+  config                   Configure PMM Client.
+  add                      Add service to monitoring.
+  annotate                 Annotate application events.
+  remove                   Remove service from monitoring.
+  update                   Update options of monitoring service.
+  label                    Manage labels of metrics service.
+  apply                    Apply services manifest to this system.
+  discover                 Discover services to monitor.
+  list                     List monitoring services for this system.
+  info                     Display PMM Client information \(works offline\).
+  check-network            Check network connectivity between client and server.
+  push                     Manage push mode.
+  cert                     Manage SSL certificate of exporters.
+  ping                     Check if PMM server is alive.
+  start                    Start monitoring service.
+  stop                     Stop monitoring service.
+  restart                  Restart monitoring service.
+  run                      Run monitoring services in foreground.
+  logs                     Show logs of monitoring service.
+  show-passwords           Show PMM Client password information \(works offline\).
+  encrypt-config           Encrypt passwords stored in the config file.
+  rotate-exporter-password Generate new password of exporters HTTP basic authentication.
+  rotate-password          Rotate password of PMM user created in database.
+  purge                    Purge metrics data on PMM server.
+  repair                   Repair installation.
+  uninstall                Removes all monitoring services with the best effort.
+  summary                  Fetch system data for diagnostics.
+  help                     Help about any command
 
 Flags:
   -c, --config-file string   PMM config file \(default ".*"\)
@@ -231,7 +340,7 @@ func testHelpAddPostgreSQL(t *testing.T, data pmmAdminData) {
 		assert.Nil(t, err)
 	}()
 
-	expected := `This command adds the given PostgreSQL instance to system and metrics monitoring.
+	expected := `This command adds the given PostgreSQL instance to system, metrics and queries monitoring.
 
 When adding a PostgreSQL instance, this tool tries to auto-detect the DSN and credentials.
 If you want to create a new user to be used for metrics collecting, provide --create-user option. pmm-admin will create
@@ -246,10 +355,13 @@ Examples:
   pmm-admin add postgresql --password abc123
   pmm-admin add postgresql --password abc123 --create-user
   pmm-admin add postgresql --password abc123 --port 3307 instance3307
+  pmm-admin add postgresql --password abc123 --create-extension
 
 Flags:
+      --create-extension              create pg_stat_statements extension if it is missing
       --create-user                   create a new PostgreSQL user
       --create-user-password string   optional password for a new PostgreSQL user
+      --disable-queryexamples         disable collection of query examples
       --disable-ssl                   disable ssl mode on exporter
       --force                         force to create/update PostgreSQL user
   -h, --help                          help for postgresql
@@ -260,11 +372,18 @@ Flags:
       --user string                   PostgreSQL username
 
 Global Flags:
-  -c, --config-file string   PMM config file \(default ".*"\)
-      --service-port int     service port
-      --skip-root            skip UID check \(experimental\)
-      --timeout duration     timeout \(default 5s\)
-      --verbose              verbose output
+  -c, --config-file string        PMM config file \(default ".*"\)
+      --cpu-quota int             CPU quota of metrics service in percent of one CPU, e.g. 50
+      --health-rollback           remove metrics service if it doesn't become healthy
+      --health-timeout duration   wait for metrics service to become healthy, 0 disables the check \(default 10s\)
+      --label stringSlice         label of metrics service in the form key=value, can be repeated
+      --memory-limit string       memory limit of metrics service, e.g. 512M or 2G
+      --nice int                  scheduling priority of metrics service, from -20 to 19
+      --run-as-user string        run metrics service as this user instead of root
+      --service-port int          service port
+      --skip-root                 skip UID check \(experimental\)
+      --timeout duration          timeout \(default 5s\)
+      --verbose                   verbose output
 `
 	t.Run("command", func(t *testing.T) {
 		cmd := exec.Command(
@@ -1138,6 +1257,7 @@ func testAddLinuxMetricsWithAdditionalArgsOk(t *testing.T, data pmmAdminData) {
 		fapi.AppendConsulV1CatalogNode(clientName, node)
 		fapi.AppendConsulV1CatalogService()
 		fapi.AppendConsulV1CatalogRegister()
+		fapi.AppendConsulV1KV()
 		_, host, port := fapi.Start()
 		defer fapi.Close()
 
@@ -1240,6 +1360,19 @@ func testAddPostgreSQL(t *testing.T, data pmmAdminData) {
 		fapi.AppendConsulV1CatalogService()
 		fapi.AppendConsulV1CatalogRegister()
 		fapi.AppendConsulV1KV()
+		in := &proto.Instance{
+			Subsystem: "postgresql",
+			UUID:      "13",
+		}
+		agentInstance := &proto.Instance{
+			Subsystem: "agent",
+			UUID:      "42",
+		}
+		fapi.AppendQanAPIInstancesId(agentInstance.UUID, agentInstance)
+		fapi.AppendQanAPIAgents(agentInstance.UUID)
+		fapi.AppendQanAPIInstances([]*proto.Instance{
+			in,
+		})
 		_, host, port := fapi.Start()
 		defer fapi.Close()
 
@@ -1264,7 +1397,8 @@ func testAddPostgreSQL(t *testing.T, data pmmAdminData) {
 	output, err := cmd.CombinedOutput()
 	assert.Nil(t, err)
 	expected := `\[linux:metrics\] OK, now monitoring this system.
-\[postgresql:metrics\] OK, now monitoring PostgreSQL metrics using DSN postgresql:\*\*\*@/postgres
+\[postgresql:metrics\] OK, now monitoring PostgreSQL metrics using DSN root@/postgres
+\[postgresql:queries\] (OK, now monitoring PostgreSQL queries from pg_stat_statements using DSN root@/postgres|Warning, PostgreSQL queries are not monitored: .*)
 `
 	assertRegexpLines(t, expected, string(output))
 }
@@ -1313,7 +1447,7 @@ func testAddPostgreSQLMetrics(t *testing.T, data pmmAdminData) {
 
 	output, err := cmd.CombinedOutput()
 	assert.Nil(t, err)
-	expected := `OK, now monitoring PostgreSQL metrics using DSN postgresql:\*\*\*@/postgres
+	expected := `OK, now monitoring PostgreSQL metrics using DSN root@/postgres
 `
 	assertRegexpLines(t, expected, string(output))
 }
@@ -1395,6 +1529,19 @@ func testAddPostgreSQLWithCreateUser(t *testing.T, data pmmAdminData) {
 		fapi.AppendConsulV1CatalogService()
 		fapi.AppendConsulV1CatalogRegister()
 		fapi.AppendConsulV1KV()
+		in := &proto.Instance{
+			Subsystem: "postgresql",
+			UUID:      "13",
+		}
+		agentInstance := &proto.Instance{
+			Subsystem: "agent",
+			UUID:      "42",
+		}
+		fapi.AppendQanAPIInstancesId(agentInstance.UUID, agentInstance)
+		fapi.AppendQanAPIAgents(agentInstance.UUID)
+		fapi.AppendQanAPIInstances([]*proto.Instance{
+			in,
+		})
 		_, host, port := fapi.Start()
 		defer fapi.Close()
 
@@ -1421,7 +1568,8 @@ func testAddPostgreSQLWithCreateUser(t *testing.T, data pmmAdminData) {
 	output, err := cmd.CombinedOutput()
 	assert.Nil(t, err)
 	expected := `\[linux:metrics\] OK, now monitoring this system.
-\[postgresql:metrics\] OK, now monitoring PostgreSQL metrics using DSN postgresql:\*\*\*@/postgres
+\[postgresql:metrics\] OK, now monitoring PostgreSQL metrics using DSN pmm:\*\*\*@/postgres
+\[postgresql:queries\] (OK, now monitoring PostgreSQL queries from pg_stat_statements using DSN pmm:\*\*\*@/postgres|Warning, PostgreSQL queries are not monitored: .*)
 `
 	assertRegexpLines(t, expected, string(output))
 }
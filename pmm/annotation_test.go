@@ -19,6 +19,7 @@ package pmm
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/url"
 	"testing"
@@ -40,12 +41,12 @@ func TestAdmin_AddAnnotation(t *testing.T) {
 
 	// create pmm-admin instance
 	admin := &Admin{}
-	insecureFlag := true
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
 	timeout := 1 * time.Second
 	debug := false
-	admin.qanAPI = NewAPI(insecureFlag, timeout, debug)
+	admin.qanAPI = NewAPI(tlsConfig, timeout, debug)
 	hostPort := fmt.Sprintf("%s:%s", host, port)
-	admin.managedAPI = managed.NewClient(hostPort, "http", &url.Userinfo{}, false, true)
+	admin.managedAPI = managed.NewClient(hostPort, "http", &url.Userinfo{}, nil, true)
 
 	// point pmm-admin to fake http api
 	admin.serverURL = hostPort
@@ -0,0 +1,209 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Actions of the plan steps.
+const (
+	PlanAdd    = "add"
+	PlanRemove = "remove"
+	PlanUpdate = "update"
+)
+
+// Manifest describes the desired monitoring services of this node.
+type Manifest struct {
+	Services []ManifestService `yaml:"services"`
+}
+
+// ManifestService describes a single desired monitoring service.
+type ManifestService struct {
+	Type  string            `yaml:"type"`
+	Name  string            `yaml:"name,omitempty"`
+	DSN   string            `yaml:"dsn,omitempty"`
+	Flags map[string]string `yaml:"flags,omitempty"`
+	Args  []string          `yaml:"args,omitempty"`
+}
+
+// PlanStep is a single action required to converge monitoring services with the manifest.
+type PlanStep struct {
+	Action  string
+	Service ManifestService
+}
+
+// OptionsFunc returns flags the service would be stored with when added from the manifest.
+type OptionsFunc func(svc ManifestService) (map[string]string, error)
+
+// String returns human readable representation of the step.
+func (s PlanStep) String() string {
+	return fmt.Sprintf("%-6s %s %s", s.Action, s.Service.Type, s.Service.Name)
+}
+
+// ReadManifest reads manifest file and validates it.
+func ReadManifest(file string) (*Manifest, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := yaml.UnmarshalStrict(bytes, m); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest %s: %s", file, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// validate checks service types and names, and that no service is listed twice.
+func (m *Manifest) validate() error {
+	seen := map[string]bool{}
+	for i, svc := range m.Services {
+		if err := isValidSvcType(svc.Type); err != nil {
+			return fmt.Errorf("service #%d: %s", i+1, err)
+		}
+		if svc.Name != "" {
+			if match, _ := regexp.MatchString(NameRegex, svc.Name); !match {
+				return fmt.Errorf("service #%d: name must be 2 to 60 characters long, contain only letters, numbers and symbols _ - . :", i+1)
+			}
+		}
+		key := svc.Type + "/" + svc.Name
+		if seen[key] {
+			return fmt.Errorf("service #%d: %s %s is listed more than once", i+1, svc.Type, svc.Name)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Plan compares the manifest with the services registered for this node on Consul
+// and returns the steps to converge them. Services already under monitoring are updated
+// if options returns flags different from the stored ones, or exporter arguments differ.
+func (a *Admin) Plan(m *Manifest, options OptionsFunc) ([]PlanStep, error) {
+	desired := make([]ManifestService, len(m.Services))
+	for i, svc := range m.Services {
+		// Name defaults to the client name, the same way as for `pmm-admin add`.
+		if svc.Name == "" {
+			svc.Name = a.Config.ClientName
+		}
+		desired[i] = svc
+	}
+
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		return nil, err
+	}
+	var current []ManifestService
+	if node != nil {
+		for _, svc := range node.Services {
+			// Leave services not managed by pmm-admin alone, e.g. consul itself.
+			if isValidSvcType(svc.Service) != nil {
+				continue
+			}
+			for _, tag := range svc.Tags {
+				if strings.HasPrefix(tag, "alias_") {
+					current = append(current, ManifestService{Type: svc.Service, Name: tag[6:]})
+				}
+			}
+		}
+	}
+
+	changed := func(svc ManifestService) (bool, error) {
+		want, err := options(svc)
+		if err != nil {
+			return false, err
+		}
+		b := *a
+		b.ServiceName = svc.Name
+		stored, args, err := b.StoredOptions(svc.Type)
+		if err == ErrNoOptions {
+			// Manifest has all options, so service added by a previous version is updated to them.
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return !sameOptions(want, stored) || !sameArgs(svc.Args, args), nil
+	}
+	return planSteps(desired, current, changed)
+}
+
+// planSteps returns steps to converge current services with desired ones.
+// Services are removed first to free names and ports, then added or updated in the manifest order.
+func planSteps(desired, current []ManifestService, changed func(svc ManifestService) (bool, error)) ([]PlanStep, error) {
+	var steps []PlanStep
+	wanted := map[string]bool{}
+	for _, svc := range desired {
+		wanted[svc.Type+"/"+svc.Name] = true
+	}
+	existing := map[string]bool{}
+	for _, svc := range current {
+		key := svc.Type + "/" + svc.Name
+		existing[key] = true
+		if !wanted[key] {
+			steps = append(steps, PlanStep{Action: PlanRemove, Service: svc})
+		}
+	}
+	for _, svc := range desired {
+		if !existing[svc.Type+"/"+svc.Name] {
+			steps = append(steps, PlanStep{Action: PlanAdd, Service: svc})
+			continue
+		}
+		update, err := changed(svc)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %s", svc.Type, svc.Name, err)
+		}
+		if update {
+			steps = append(steps, PlanStep{Action: PlanUpdate, Service: svc})
+		}
+	}
+	return steps, nil
+}
+
+// sameOptions returns true if both sets of flags have the same values.
+func sameOptions(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if v, ok := b[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// sameArgs returns true if both lists of exporter arguments are equal.
+func sameArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/percona/pmm-client/pmm/plugin/exporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadManifest(t *testing.T) {
+	m, err := ReadManifest("testdata/manifest.yml")
+	assert.Nil(t, err)
+	expected := &Manifest{
+		Services: []ManifestService{
+			{Type: "linux:metrics"},
+			{
+				Type: "mysql:metrics",
+				Name: "db01",
+				Flags: map[string]string{
+					"user":               "root",
+					"password":           "abc123",
+					"disable-tablestats": "true",
+				},
+				Args: []string{"--collect.perf_schema.eventsstatements"},
+			},
+			{Type: "mongodb:metrics", DSN: "mongodb://127.0.0.1:27017"},
+		},
+	}
+	assert.Equal(t, expected, m)
+
+	invalid := map[string]string{
+		"type":      "services:\n- type: oracle:metrics\n",
+		"duplicate": "services:\n- type: linux:metrics\n- type: linux:metrics\n",
+		"name":      "services:\n- type: linux:metrics\n  name: a\n",
+		"field":     "services:\n- type: linux:metrics\n  port: 42000\n",
+	}
+	for name, content := range invalid {
+		f, err := ioutil.TempFile("", "manifest")
+		assert.Nil(t, err)
+		defer os.Remove(f.Name())
+		_, err = f.WriteString(content)
+		assert.Nil(t, err)
+		f.Close()
+
+		_, err = ReadManifest(f.Name())
+		assert.Error(t, err, name)
+	}
+}
+
+func TestPlanSteps(t *testing.T) {
+	desired := []ManifestService{
+		{Type: "linux:metrics", Name: "node1"},
+		{Type: "mysql:metrics", Name: "db01", Flags: map[string]string{"user": "root"}},
+		{Type: "mysql:queries", Name: "db01"},
+	}
+	current := []ManifestService{
+		{Type: "linux:metrics", Name: "node1"},
+		{Type: "mysql:metrics", Name: "db02"},
+		{Type: "mysql:queries", Name: "db01"},
+	}
+	unchanged := func(svc ManifestService) (bool, error) { return false, nil }
+	expected := []PlanStep{
+		{Action: PlanRemove, Service: ManifestService{Type: "mysql:metrics", Name: "db02"}},
+		{Action: PlanAdd, Service: ManifestService{Type: "mysql:metrics", Name: "db01", Flags: map[string]string{"user": "root"}}},
+	}
+	steps, err := planSteps(desired, current, unchanged)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, steps)
+
+	// Nothing to do when services converged.
+	steps, err = planSteps(desired, desired, unchanged)
+	assert.Nil(t, err)
+	assert.Empty(t, steps)
+
+	// Existing services with changed options are updated.
+	changed := func(svc ManifestService) (bool, error) { return svc.Type == "mysql:queries", nil }
+	expected = []PlanStep{
+		{Action: PlanRemove, Service: ManifestService{Type: "mysql:metrics", Name: "db02"}},
+		{Action: PlanAdd, Service: ManifestService{Type: "mysql:metrics", Name: "db01", Flags: map[string]string{"user": "root"}}},
+		{Action: PlanUpdate, Service: ManifestService{Type: "mysql:queries", Name: "db01"}},
+	}
+	steps, err = planSteps(desired, current, changed)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, steps)
+
+	failed := func(svc ManifestService) (bool, error) { return false, ErrNoService }
+	_, err = planSteps(desired, current, failed)
+	assert.Error(t, err)
+}
+
+func TestPlan(t *testing.T) {
+	a, _, _, teardown := setupRollbackTest(t)
+	defer teardown()
+	executable, err := os.Executable()
+	assert.Nil(t, err)
+	manifest := &exporter.Manifest{
+		Name:        "proxysql",
+		Binary:      executable,
+		DefaultPort: 42004,
+		DSN:         &exporter.DSN{Env: "DATA_SOURCE_NAME"},
+	}
+
+	a.Options = map[string]string{"dsn": "admin:admin@tcp(localhost:6032)/", "disable-ssl": "true"}
+	_, err = a.AddMetrics(context.Background(), exporter.New(manifest, "admin:admin@tcp(localhost:6032)/", ""), false, true)
+	assert.Nil(t, err)
+
+	// Flags of the manifest service are stored as is, DSN as dsn flag.
+	options := func(svc ManifestService) (map[string]string, error) {
+		flags := map[string]string{"dsn": svc.DSN}
+		for name, value := range svc.Flags {
+			flags[name] = value
+		}
+		return flags, nil
+	}
+	svc := ManifestService{Type: "proxysql:metrics", Name: "db01", DSN: "admin:admin@tcp(localhost:6032)/", Flags: map[string]string{"disable-ssl": "true"}}
+	steps, err := a.Plan(&Manifest{Services: []ManifestService{svc}}, options)
+	assert.Nil(t, err)
+	assert.Empty(t, steps)
+
+	svc.DSN = "admin:admin@tcp(localhost:6033)/"
+	steps, err = a.Plan(&Manifest{Services: []ManifestService{svc}}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []PlanStep{{Action: PlanUpdate, Service: svc}}, steps)
+
+	svc.DSN = "admin:admin@tcp(localhost:6032)/"
+	svc.Args = []string{"-log.level=debug"}
+	steps, err = a.Plan(&Manifest{Services: []ManifestService{svc}}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []PlanStep{{Action: PlanUpdate, Service: svc}}, steps)
+
+	// Service added by a previous version is updated to the manifest.
+	svc.Args = nil
+	_, err = a.consulAPI.KV().Delete("node1/proxysql:metrics-42004/options", nil)
+	assert.Nil(t, err)
+	steps, err = a.Plan(&Manifest{Services: []ManifestService{svc}}, options)
+	assert.Nil(t, err)
+	assert.Equal(t, []PlanStep{{Action: PlanUpdate, Service: svc}}, steps)
+
+	other := ManifestService{Type: "proxysql:metrics", Name: "db02"}
+	steps, err = a.Plan(&Manifest{Services: []ManifestService{other}}, options)
+	assert.Nil(t, err)
+	expected := []PlanStep{
+		{Action: PlanRemove, Service: ManifestService{Type: "proxysql:metrics", Name: "db01"}},
+		{Action: PlanAdd, Service: other},
+	}
+	assert.Equal(t, expected, steps)
+}
+
+func TestSameOptions(t *testing.T) {
+	assert.True(t, sameOptions(nil, map[string]string{}))
+	assert.True(t, sameOptions(map[string]string{"user": "root"}, map[string]string{"user": "root"}))
+	assert.False(t, sameOptions(map[string]string{"user": "root"}, map[string]string{"user": "pmm"}))
+	assert.False(t, sameOptions(map[string]string{"user": "root"}, map[string]string{"password": "root"}))
+	assert.False(t, sameOptions(map[string]string{"user": "root"}, nil))
+
+	assert.True(t, sameArgs(nil, []string{}))
+	assert.True(t, sameArgs([]string{"-a", "-b"}, []string{"-a", "-b"}))
+	assert.False(t, sameArgs([]string{"-a", "-b"}, []string{"-b", "-a"}))
+	assert.False(t, sameArgs([]string{"-a"}, nil))
+}
@@ -0,0 +1,302 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+const (
+	// DefaultCertLifetime is a lifetime of generated exporter certificate.
+	DefaultCertLifetime = 365 * 24 * time.Hour
+	// CertExpiryWarning is how long before expiration pmm-admin starts to warn about it.
+	CertExpiryWarning = 30 * 24 * time.Hour
+)
+
+// CertInfo describes exporter SSL certificate.
+type CertInfo struct {
+	File        string
+	Subject     string
+	Issuer      string
+	NotBefore   time.Time
+	NotAfter    time.Time
+	DNSNames    []string
+	IPAddresses []string
+	SelfSigned  bool
+}
+
+// Expired returns true if certificate is not valid at the given time anymore.
+func (c *CertInfo) Expired(now time.Time) bool {
+	return now.After(c.NotAfter)
+}
+
+// Warning returns a warning if certificate expired or expires soon, empty string otherwise.
+func (c *CertInfo) Warning(now time.Time) string {
+	switch {
+	case c.Expired(now):
+		return fmt.Sprintf("SSL certificate %s expired on %s, run 'pmm-admin cert rotate' to replace it.",
+			c.File, c.NotAfter.Format(time.RFC1123))
+	case c.NotAfter.Sub(now) < CertExpiryWarning:
+		return fmt.Sprintf("SSL certificate %s expires in %d day(s) on %s, run 'pmm-admin cert rotate' to replace it.",
+			c.File, int(c.NotAfter.Sub(now).Hours()/24), c.NotAfter.Format(time.RFC1123))
+	}
+	return ""
+}
+
+// String returns human readable certificate status.
+func (c *CertInfo) String() string {
+	sans := append(append([]string{}, c.DNSNames...), c.IPAddresses...)
+	issuer := c.Issuer
+	if c.SelfSigned {
+		issuer = "self-signed"
+	}
+	return fmt.Sprintf("%-11s | %s\n%-11s | %s\n%-11s | %s\n%-11s | %s\n%-11s | %s\n%-11s | %s\n",
+		"File", c.File,
+		"Subject", c.Subject,
+		"Issuer", issuer,
+		"Not before", c.NotBefore.Format(time.RFC1123),
+		"Not after", c.NotAfter.Format(time.RFC1123),
+		"SANs", strings.Join(sans, ", "),
+	)
+}
+
+// readCertificate reads the first certificate from PEM file.
+func readCertificate(file string) (*CertInfo, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s doesn't contain PEM encoded certificate", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse certificate %s: %s", file, err)
+	}
+
+	info := &CertInfo{
+		File:       file,
+		Subject:    cert.Subject.String(),
+		Issuer:     cert.Issuer.String(),
+		NotBefore:  cert.NotBefore,
+		NotAfter:   cert.NotAfter,
+		DNSNames:   cert.DNSNames,
+		SelfSigned: cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil,
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	return info, nil
+}
+
+// CertStatus returns information about exporter SSL certificate.
+func CertStatus() (*CertInfo, error) {
+	return readCertificate(SSLCertFile)
+}
+
+// certWarning returns a warning if exporter SSL certificate expired or expires soon.
+func certWarning() string {
+	info, err := CertStatus()
+	if err != nil {
+		return ""
+	}
+	return info.Warning(time.Now())
+}
+
+// isHTTPSService returns true if exporter serves metrics over HTTPS.
+func isHTTPSService(svc *consul.AgentService) bool {
+	for _, tag := range svc.Tags {
+		if tag == "scheme_https" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHTTPSServices returns true if any exporter of the node serves metrics over HTTPS.
+func hasHTTPSServices(node *consul.CatalogNode) bool {
+	for _, svc := range node.Services {
+		if isHTTPSService(svc) {
+			return true
+		}
+	}
+	return false
+}
+
+// certHosts returns SANs for exporter certificate: client address and bind address if they differ.
+func (a *Admin) certHosts() []string {
+	hosts := []string{a.Config.ClientAddress}
+	if a.Config.BindAddress != "" && a.Config.BindAddress != a.Config.ClientAddress {
+		hosts = append(hosts, a.Config.BindAddress)
+	}
+	return hosts
+}
+
+// checkSSLCertificate check if SSL cert and key files exist and are valid and generate them if not.
+func (a *Admin) checkSSLCertificate() error {
+	if FileExists(SSLCertFile) && FileExists(SSLKeyFile) {
+		if info, err := readCertificate(SSLCertFile); err == nil && !info.Expired(time.Now()) {
+			return nil
+		}
+	}
+
+	// Generate SSL cert and key.
+	return generateSSLCertificate(a.certHosts(), DefaultCertLifetime, SSLCertFile, SSLKeyFile)
+}
+
+// RotateCertificate generates a new self-signed exporter certificate valid for the given time
+// and restarts HTTPS exporters. It returns the number of restarted exporters.
+func (a *Admin) RotateCertificate(lifetime time.Duration) (int, error) {
+	if lifetime <= 0 {
+		return 0, fmt.Errorf("certificate lifetime should be positive")
+	}
+	if err := generateSSLCertificate(a.certHosts(), lifetime, SSLCertFile, SSLKeyFile); err != nil {
+		return 0, err
+	}
+	return a.restartHTTPSExporters()
+}
+
+// ImportCertificate replaces exporter certificate with the given cert and key pair, e.g. signed by own CA,
+// and restarts HTTPS exporters. It returns the number of restarted exporters.
+func (a *Admin) ImportCertificate(certFile, keyFile string) (int, error) {
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return 0, fmt.Errorf("invalid certificate and key pair: %s", err)
+	}
+	info, err := readCertificate(certFile)
+	if err != nil {
+		return 0, err
+	}
+	if info.Expired(time.Now()) {
+		return 0, fmt.Errorf("certificate %s expired on %s", certFile, info.NotAfter.Format(time.RFC1123))
+	}
+
+	for src, dst := range map[string]string{certFile: SSLCertFile, keyFile: SSLKeyFile} {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return 0, err
+		}
+		if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+			return 0, fmt.Errorf("failed to write %s: %s", dst, err)
+		}
+	}
+	return a.restartHTTPSExporters()
+}
+
+// restartHTTPSExporters restarts running exporters serving metrics over HTTPS, so they pick up a new certificate.
+func (a *Admin) restartHTTPSExporters() (int, error) {
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil || node == nil {
+		return 0, err
+	}
+
+	var errs Errors
+	restarted := 0
+	for _, svc := range node.Services {
+		if !isHTTPSService(svc) {
+			continue
+		}
+
+		svcName := fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port)
+		// Stopped exporters will pick up the certificate on start.
+		if !getServiceStatus(svcName) {
+			continue
+		}
+		if err := stopService(svcName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := startService(svcName); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		restarted++
+	}
+
+	if len(errs) > 0 {
+		return restarted, errs
+	}
+	return restarted, nil
+}
+
+// generateSSLCertificate generate SSL certificate for the given hosts and key and write them into the files.
+func generateSSLCertificate(hosts []string, lifetime time.Duration, certFile, keyFile string) error {
+	// Generate key.
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %s", err)
+	}
+
+	// Generate cert.
+	// Start validity a bit in the past to tolerate clock skew between client and server.
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := notBefore.Add(lifetime)
+	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	cert := x509.Certificate{
+		Subject:               pkix.Name{Organization: []string{"PMM Client"}},
+		SerialNumber:          serialNumber,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			cert.IPAddresses = append(cert.IPAddresses, ip)
+		} else if host != "" {
+			cert.DNSNames = append(cert.DNSNames, host)
+		}
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, &cert, &cert, &privKey.PublicKey, privKey)
+	if err != nil {
+		return fmt.Errorf("failed to generate certificate: %s", err)
+	}
+
+	// Write files.
+	out, err := os.OpenFile(certFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", certFile, err)
+	}
+	pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
+	out.Close()
+
+	out, err = os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %s", keyFile, err)
+	}
+	pem.Encode(out, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privKey)})
+	out.Close()
+
+	return nil
+}
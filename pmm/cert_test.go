@@ -0,0 +1,78 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateSSLCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-cert")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	err = generateSSLCertificate([]string{"db01.example.com", "10.0.0.1"}, 90*24*time.Hour, certFile, keyFile)
+	assert.Nil(t, err)
+
+	for _, file := range []string{certFile, keyFile} {
+		fi, err := os.Stat(file)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	info, err := readCertificate(certFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"db01.example.com"}, info.DNSNames)
+	assert.Equal(t, []string{"10.0.0.1"}, info.IPAddresses)
+	assert.True(t, info.SelfSigned)
+	assert.False(t, info.Expired(time.Now()))
+	assert.WithinDuration(t, time.Now().Add(90*24*time.Hour), info.NotAfter, 2*time.Hour)
+}
+
+func TestReadCertificateInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "pmm-cert")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("not a certificate")
+	f.Close()
+
+	_, err = readCertificate(f.Name())
+	assert.NotNil(t, err)
+}
+
+func TestCertInfoWarning(t *testing.T) {
+	now := time.Now()
+	info := &CertInfo{File: "server.crt", NotAfter: now.Add(90 * 24 * time.Hour)}
+	assert.Equal(t, "", info.Warning(now))
+
+	info.NotAfter = now.Add(10*24*time.Hour + time.Hour)
+	assert.True(t, strings.Contains(info.Warning(now), "expires in 10 day(s)"), info.Warning(now))
+
+	info.NotAfter = now.Add(-time.Hour)
+	assert.True(t, info.Expired(now))
+	assert.True(t, strings.Contains(info.Warning(now), "expired on"), info.Warning(now))
+}
@@ -148,6 +148,12 @@ func (a *Admin) CheckNetwork() error {
 		return nil
 	}
 
+	if a.Config.Push {
+		fmt.Println()
+		a.checkPush()
+		return nil
+	}
+
 	if !promStatus {
 		fmt.Print("Prometheus is down. Please check if PMM server container runs properly.\n\n")
 		return nil
@@ -188,7 +194,7 @@ func (a *Admin) CheckNetwork() error {
 		protectedVal := "-"
 		if localStatus {
 			sslVal = colorStatus("YES", "NO", a.isSSLProtected(svc.Service, svc.Port))
-			if a.Config.ServerUser != "" {
+			if user, _ := a.Config.exporterCredentials(); user != "" {
 				protectedVal = colorStatus("YES", "NO", a.isPasswordProtected(svc.Service, svc.Port))
 			}
 		}
@@ -250,6 +256,15 @@ func (a *Admin) CheckNetwork() error {
 
 	}
 
+	// Exporter dies silently if other process took its port, e.g. after restart.
+	if foreign, err := a.foreignListeners(svcTable); err == nil && len(foreign) > 0 {
+		fmt.Println("\nPorts of the following services are used by other processes:")
+		for _, f := range foreign {
+			fmt.Printf("  %s %s port %d: %s (pid %d)\n", f.Type, f.Name, f.Port, f.Executable, f.PID)
+		}
+		fmt.Println("Stop those processes or re-add the services with other --service-port.")
+	}
+
 	if errStatus {
 		scheme := "http"
 		if a.Config.ServerInsecureSSL || a.Config.ServerSSL {
@@ -258,7 +273,7 @@ func (a *Admin) CheckNetwork() error {
 		url := fmt.Sprintf("%s://%s/prometheus/targets", scheme, a.Config.ServerAddress)
 		fmt.Printf(`
 When an endpoint is down it may indicate that the corresponding service is stopped (run 'pmm-admin list' to verify).
-If it's running, check out the logs /var/log/pmm-*.log
+If it's running, check out the logs with 'pmm-admin logs TYPE [name]' or /var/log/pmm-*.log
 
 When all endpoints are down but 'pmm-admin list' shows they are up and no errors in the logs,
 check the firewall settings whether this system allows incoming connections from server to address:port in question.
@@ -270,17 +285,17 @@ Also you can check the endpoint status by the URL: %s
 IMPORTANT: client and bind addresses are not the same which means you need to configure NAT/port forwarding to map them.`)
 		}
 	}
+	if hasHTTPSServices(node) {
+		if warning := certWarning(); warning != "" {
+			fmt.Printf("\n%s\n", warning)
+		}
+	}
 	fmt.Println()
 	return nil
 }
 
 // testNetwork measure round trip duration of server connection.
 func (a *Admin) testNetwork() {
-	insecureFlag := false
-	if a.Config.ServerInsecureSSL {
-		insecureFlag = true
-	}
-
 	conn := &networkTransport{
 		dialer: &net.Dialer{
 			Timeout:   a.apiTimeout,
@@ -290,7 +305,7 @@ func (a *Admin) testNetwork() {
 	conn.rtp = &http.Transport{
 		Proxy:           http.ProxyFromEnvironment,
 		Dial:            conn.dial,
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureFlag},
+		TLSClientConfig: a.tlsConfig,
 	}
 	client := &http.Client{Transport: conn}
 
@@ -342,16 +357,13 @@ func checkPromTargetStatus(data, alias, job string) bool {
 
 // isPasswordProtected check if endpoint is password protected.
 func (a *Admin) isPasswordProtected(svcType string, port int) bool {
-	urlPath := "metrics"
-	if svcType == "mysql:metrics" {
-		urlPath = "metrics-hr"
-	}
+	urlPath := metricsPath(svcType)
 	scheme := "http"
 	api := a.qanAPI
 	if a.isSSLProtected(svcType, port) {
 		scheme = "https"
 		// Enforce InsecureSkipVerify true to bypass err and check http code.
-		api = NewAPI(true, apiTimeout, a.Verbose)
+		api = NewAPI(&tls.Config{InsecureSkipVerify: true}, apiTimeout, a.Verbose)
 	}
 	url := api.URL(fmt.Sprintf("%s://%s:%d", scheme, a.Config.BindAddress, port), urlPath)
 	if resp, _, err := api.Get(url); err == nil && resp.StatusCode == http.StatusUnauthorized {
@@ -18,6 +18,8 @@
 package pmm
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,8 +30,10 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 
 	consul "github.com/hashicorp/consul/api"
+	"github.com/percona/pmm-client/pmm/utils"
 	"github.com/percona/pmm/proto"
 	protocfg "github.com/percona/pmm/proto/config"
 	"gopkg.in/yaml.v2"
@@ -37,15 +41,80 @@ import (
 
 // Config pmm.yml config file.
 type Config struct {
-	ServerAddress     string `yaml:"server_address"`
-	ClientAddress     string `yaml:"client_address"`
-	BindAddress       string `yaml:"bind_address"`
-	ClientName        string `yaml:"client_name"`
-	MySQLPassword     string `yaml:"mysql_password,omitempty"`
-	ServerUser        string `yaml:"server_user,omitempty"`
-	ServerPassword    string `yaml:"server_password,omitempty"`
-	ServerSSL         bool   `yaml:"server_ssl,omitempty"`
-	ServerInsecureSSL bool   `yaml:"server_insecure_ssl,omitempty"`
+	ServerAddress      string `yaml:"server_address"`
+	ClientAddress      string `yaml:"client_address"`
+	BindAddress        string `yaml:"bind_address"`
+	ClientName         string `yaml:"client_name"`
+	MySQLPassword      string `yaml:"mysql_password,omitempty"`
+	PostgreSQLPassword string `yaml:"postgresql_password,omitempty"`
+	ServerUser         string `yaml:"server_user,omitempty"`
+	ServerPassword     string `yaml:"server_password,omitempty"`
+	ServerSSL          bool   `yaml:"server_ssl,omitempty"`
+	ServerInsecureSSL  bool   `yaml:"server_insecure_ssl,omitempty"`
+	ServerCAFile       string `yaml:"server_ca_file,omitempty"`
+	ServerCertFile     string `yaml:"server_cert_file,omitempty"`
+	ServerKeyFile      string `yaml:"server_key_file,omitempty"`
+	ServerTLSName      string `yaml:"server_tls_name,omitempty"`
+	// ExporterUser and ExporterPassword protect exporters instead of server credentials,
+	// they are generated by rotate-exporter-password.
+	ExporterUser     string `yaml:"exporter_user,omitempty"`
+	ExporterPassword string `yaml:"exporter_password,omitempty"`
+	// Labels are added to every metrics service of this node.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Push is true if metrics are pushed to the server by push agent instead of being pulled.
+	Push bool `yaml:"push,omitempty"`
+	// ServiceManager overrides service manager of the platform, e.g. supervisor.
+	ServiceManager string `yaml:"service_manager,omitempty"`
+	// LogMaxSize is a size in MB log files of services are rotated at.
+	LogMaxSize int `yaml:"log_max_size,omitempty"`
+	// LogRetention is a number of rotated log files to keep.
+	LogRetention int `yaml:"log_retention,omitempty"`
+	// PortRange limits ports chosen for metrics services, e.g. 42000-42999.
+	PortRange string `yaml:"port_range,omitempty"`
+	// SecretKeyFile is a key file secrets are encrypted with, see encrypt-config command.
+	SecretKeyFile string `yaml:"secret_key_file,omitempty"`
+	// PasswordCommand is run with the secret name as an argument to fetch secrets missing in config.
+	PasswordCommand string `yaml:"password_command,omitempty"`
+
+	// commandSecrets are secrets fetched with PasswordCommand, they are not written to config file.
+	commandSecrets map[string]string
+	// plainSecrets are secrets stored in config file in plain text when it was loaded.
+	plainSecrets map[string]string
+}
+
+// TLSConfig returns TLS config for connections to PMM server, nil if SSL is not enabled.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if !c.ServerSSL && !c.ServerInsecureSSL {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		InsecureSkipVerify: c.ServerInsecureSSL,
+		ServerName:         c.ServerTLSName,
+	}
+	if c.ServerCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ServerCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA bundle: %s", err)
+		}
+		// Trust the system roots too, so public certificates keep working.
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM encoded certificates found in CA bundle %s", c.ServerCAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.ServerCertFile != "" || c.ServerKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ServerCertFile, c.ServerKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
 }
 
 // LoadConfig read PMM client config file.
@@ -61,12 +130,17 @@ func (a *Admin) LoadConfig() error {
 	if err := yaml.Unmarshal(bytes, a.Config); err != nil {
 		return err
 	}
+	if err := a.Config.decryptSecrets(); err != nil {
+		return fmt.Errorf("%s: %s", ConfigFile, err)
+	}
+	utils.AddSecret(a.Config.ServerPassword, a.Config.MySQLPassword, a.Config.PostgreSQLPassword, a.Config.ExporterPassword)
 
 	// If not set previously, assume it equals to client address.
 	if a.Config.BindAddress == "" {
 		a.Config.BindAddress = a.Config.ClientAddress
 	}
-	return nil
+	setLogRotation(a.Config)
+	return useServiceManager(a.Config.ServiceManager)
 }
 
 // SetConfig configure PMM client, check connectivity and write the config.
@@ -83,6 +157,10 @@ func (a *Admin) SetConfig(cf Config, flagForce bool) error {
 		a.Config.ServerInsecureSSL = false
 		a.Config.ServerUser = ""
 		a.Config.ServerPassword = ""
+		a.Config.ServerCAFile = ""
+		a.Config.ServerCertFile = ""
+		a.Config.ServerKeyFile = ""
+		a.Config.ServerTLSName = ""
 	}
 	if a.Config.ServerAddress == "" {
 		return errors.New("Server address is not set. Use --server flag to set it.")
@@ -100,6 +178,65 @@ func (a *Admin) SetConfig(cf Config, flagForce bool) error {
 		a.Config.ServerSSL = false
 		a.Config.ServerInsecureSSL = true
 	}
+	if (cf.ServerCertFile == "") != (cf.ServerKeyFile == "") {
+		return errors.New("Flags --server-cert-file and --server-key-file should be used together.")
+	}
+	if cf.ServerCAFile != "" {
+		a.Config.ServerCAFile = cf.ServerCAFile
+	}
+	if cf.ServerCertFile != "" {
+		a.Config.ServerCertFile = cf.ServerCertFile
+		a.Config.ServerKeyFile = cf.ServerKeyFile
+	}
+	if cf.ServerTLSName != "" {
+		a.Config.ServerTLSName = cf.ServerTLSName
+	}
+	// TLS options imply SSL unless insecure SSL is enabled.
+	if cf.ServerCAFile != "" || cf.ServerCertFile != "" || cf.ServerTLSName != "" {
+		if !a.Config.ServerInsecureSSL {
+			a.Config.ServerSSL = true
+		}
+	}
+
+	// Service manager.
+	if cf.ServiceManager != "" {
+		serviceManager := cf.ServiceManager
+		if serviceManager == ServiceManagerAuto {
+			serviceManager = ""
+		}
+		if serviceManager != a.Config.ServiceManager {
+			if len(GetLocalServices()) > 0 {
+				return errors.New("Changing of service manager is allowed only if there are no services installed.")
+			}
+			if err := useServiceManager(serviceManager); err != nil {
+				return err
+			}
+			a.Config.ServiceManager = serviceManager
+		}
+	}
+
+	// Log rotation.
+	if cf.LogMaxSize < 0 || cf.LogRetention < 0 {
+		return errors.New("Flags --log-max-size and --log-retention should be positive.")
+	}
+	if cf.LogMaxSize > 0 {
+		a.Config.LogMaxSize = cf.LogMaxSize
+	}
+	if cf.LogRetention > 0 {
+		a.Config.LogRetention = cf.LogRetention
+	}
+
+	// Port range.
+	switch cf.PortRange {
+	case "":
+	case "auto":
+		a.Config.PortRange = ""
+	default:
+		if _, _, err := parsePortRange(cf.PortRange); err != nil {
+			return err
+		}
+		a.Config.PortRange = cf.PortRange
+	}
 
 	// Set APIs and check if server is alive.
 	if err := a.SetAPI(); err != nil {
@@ -260,16 +397,15 @@ What ports to map you can find from "pmm-admin check-network" output once you ad
 	// If agent config exists, update the options like address, SSL, password etc.
 	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", AgentBaseDir)
 	if FileExists(agentConfigFile) {
+		if err := a.Config.checkQANTLS(); err != nil {
+			return err
+		}
 		if err := a.syncAgentConfig(agentConfigFile); err != nil {
 			return fmt.Errorf("Unable to update agent config %s: %s", agentConfigFile, err)
 		}
-		// Restart QAN agent for MySQL.
-		if _, err := a.StartStopMonitoring("restart", "mysql:queries"); err != nil && err != ErrNoService {
-			return fmt.Errorf("Unable to restart queries service for MySQL: %s", err)
-		}
-		// Restart QAN agent for MongoDB.
-		if _, err := a.StartStopMonitoring("restart", "mongodb:queries"); err != nil && err != ErrNoService {
-			return fmt.Errorf("Unable to restart queries service for MongoDB: %s", err)
+		// Reinstall QAN agent for every queries service, so it trusts the current CA bundle.
+		if err := a.reinstallQAN(); err != nil {
+			return err
 		}
 	}
 
@@ -277,12 +413,25 @@ What ports to map you can find from "pmm-admin check-network" output once you ad
 	if err := a.writeConfig(); err != nil {
 		return fmt.Errorf("Unable to write config file %s: %s", ConfigFile, err)
 	}
+	setLogRotation(a.Config)
+	if err := writeLogRotateConfig(); err != nil {
+		return fmt.Errorf("Unable to write log rotation config %s: %s", LogRotateFile, err)
+	}
 
-	// Restart all services when resetting server address (wiping password) or changing password.
+	// Update exporter credentials when resetting server address (wiping password) or changing password.
 	if cf.ServerAddress != "" || cf.ServerPassword != "" {
-		_, _, err := a.StartStopAllMonitoring("restart")
-		if err != nil {
-			return fmt.Errorf("Error restarting one of the services: %s", err)
+		if _, err := a.ensureExporterAuth(); err != nil {
+			return fmt.Errorf("Unable to update exporter credentials: %s", err)
+		}
+		// Exporters using server credentials are restarted, otherwise only push agent needs the new password.
+		if a.Config.ExporterPassword == "" {
+			if _, _, err := a.StartStopAllMonitoring("restart"); err != nil {
+				return fmt.Errorf("Error restarting one of the services: %s", err)
+			}
+		} else if a.Config.Push && getServiceStatus(PushServiceName) {
+			if err := restartService(PushServiceName); err != nil {
+				return fmt.Errorf("Error restarting %s service: %s", PushServiceName, err)
+			}
 		}
 	}
 
@@ -291,10 +440,52 @@ What ports to map you can find from "pmm-admin check-network" output once you ad
 
 // writeConfig write config to the file.
 func (a *Admin) writeConfig() error {
-	bytes, _ := yaml.Marshal(a.Config)
+	utils.AddSecret(a.Config.ServerPassword, a.Config.MySQLPassword, a.Config.PostgreSQLPassword, a.Config.ExporterPassword)
+	c, err := a.Config.encryptedCopy()
+	if err != nil {
+		return err
+	}
+	bytes, _ := yaml.Marshal(c)
 	return ioutil.WriteFile(ConfigFile, bytes, 0600)
 }
 
+// configM guards PMM user password in config, plugins may be initialized concurrently.
+var configM sync.Mutex
+
+// pmmUserPassword returns password of PMM user created by plugins of the engine.
+// PostgreSQL user created by previous versions shares the password with MySQL one.
+func (a *Admin) pmmUserPassword(engine string) string {
+	configM.Lock()
+	defer configM.Unlock()
+
+	if engine == "postgresql" && a.Config.PostgreSQLPassword != "" {
+		return a.Config.PostgreSQLPassword
+	}
+	return a.Config.MySQLPassword
+}
+
+// storePMMUserPassword saves password of PMM user created by plugin of the engine to config,
+// empty password is ignored.
+func (a *Admin) storePMMUserPassword(engine, password string) error {
+	if password == "" {
+		return nil
+	}
+
+	configM.Lock()
+	defer configM.Unlock()
+
+	if engine == "postgresql" {
+		a.Config.PostgreSQLPassword = password
+	} else {
+		// Keep the shared password for PostgreSQL user created by previous versions.
+		if a.Config.PostgreSQLPassword == "" && a.Config.MySQLPassword != password {
+			a.Config.PostgreSQLPassword = a.Config.MySQLPassword
+		}
+		a.Config.MySQLPassword = password
+	}
+	return a.writeConfig()
+}
+
 // syncAgentConfig sync agent config.
 func (a *Admin) syncAgentConfig(agentConfigFile string) error {
 	jsonData, err := ioutil.ReadFile(agentConfigFile)
@@ -309,7 +500,9 @@ func (a *Admin) syncAgentConfig(agentConfigFile string) error {
 	agentConf.ServerSSL = a.Config.ServerSSL
 	agentConf.ServerInsecureSSL = a.Config.ServerInsecureSSL
 	agentConf.ServerUser = a.Config.ServerUser
+	// QAN agent can't decrypt secrets, so it gets the password in plain text.
 	agentConf.ServerPassword = a.Config.ServerPassword
+	// QAN agent doesn't support TLS options, CA bundle is passed in its environment, see qanEnvironment.
 
 	bytes, _ := json.Marshal(agentConf)
 	return ioutil.WriteFile(agentConfigFile, bytes, 0600)
@@ -18,7 +18,12 @@
 package pmm
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -36,3 +41,73 @@ func TestIsAddressLocal(t *testing.T) {
 		assert.Equal(t, expected, isAddressLocal(ip), "ip = %s", ip)
 	}
 }
+
+func TestConfigTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-tls")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	assert.Nil(t, generateSSLCertificate([]string{"pmm.example.com"}, time.Hour, certFile, keyFile))
+
+	tlsConfig, err := (&Config{}).TLSConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+
+	tlsConfig, err = (&Config{ServerInsecureSSL: true}).TLSConfig()
+	assert.Nil(t, err)
+	assert.True(t, tlsConfig.InsecureSkipVerify)
+
+	c := &Config{
+		ServerSSL:      true,
+		ServerCAFile:   certFile,
+		ServerCertFile: certFile,
+		ServerKeyFile:  keyFile,
+		ServerTLSName:  "pmm.example.com",
+	}
+	tlsConfig, err = c.TLSConfig()
+	assert.Nil(t, err)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	assert.NotNil(t, tlsConfig.RootCAs)
+	assert.Len(t, tlsConfig.Certificates, 1)
+	assert.Equal(t, "pmm.example.com", tlsConfig.ServerName)
+
+	c.ServerCAFile = keyFile
+	_, err = c.TLSConfig()
+	assert.NotNil(t, err)
+
+	c.ServerCAFile = ""
+	c.ServerKeyFile = ""
+	_, err = c.TLSConfig()
+	assert.NotNil(t, err)
+}
+
+func TestSyncAgentConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "agent.conf")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(`{"UUID":"abc","ApiHostname":"old:80","ApiPath":"qan-api","ServerInsecureSSL":true}`)
+	f.Close()
+
+	a := &Admin{Config: &Config{
+		ServerAddress:  "pmm.example.com:443",
+		ServerSSL:      true,
+		ServerCAFile:   "/etc/pki/ca.pem",
+		ServerCertFile: "/etc/pki/client.crt",
+		ServerKeyFile:  "/etc/pki/client.key",
+	}}
+	// QAN agent doesn't support TLS options, they are not written.
+	assert.Nil(t, a.syncAgentConfig(f.Name()))
+
+	data, err := ioutil.ReadFile(f.Name())
+	assert.Nil(t, err)
+	var actual map[string]interface{}
+	assert.Nil(t, json.Unmarshal(data, &actual))
+	expected := map[string]interface{}{
+		"UUID":        "abc",
+		"ApiHostname": "pmm.example.com:443",
+		"ApiPath":     "qan-api",
+		"ServerSSL":   true,
+	}
+	assert.Equal(t, expected, actual)
+}
@@ -46,8 +46,32 @@ var (
 	SSLCertFile = fmt.Sprintf("%s/server.crt", PMMBaseDir)
 	SSLKeyFile  = fmt.Sprintf("%s/server.key", PMMBaseDir)
 
+	// SecretKeyFile is a default key file used to encrypt secrets in config, see encrypt-config command.
+	SecretKeyFile = fmt.Sprintf("%s/pmm.key", PMMBaseDir)
+	// AuthFile keeps HTTP credentials exporters are protected with.
+	AuthFile = fmt.Sprintf("%s/auth.yml", PMMBaseDir)
+
+	// ExportersDir contains manifests of additional exporters, see package plugin/exporter.
+	ExportersDir = fmt.Sprintf("%s/exporters.d", PMMBaseDir)
+
+	// PushDir keeps buffered samples and status of push agent.
+	PushDir = fmt.Sprintf("%s/push", PMMBaseDir)
+
+	// LogsDir contains log files of services, pmm-<type>-<port>.log.
+	LogsDir = RootDir + "/var/log"
+
+	// LogRotateFile is logrotate config for log files of services.
+	LogRotateFile = RootDir + "/etc/logrotate.d/pmm-client"
+
+	// CredentialsDir keeps database credentials of metrics services, readable by root only.
+	CredentialsDir = fmt.Sprintf("%s/credentials", PMMBaseDir)
+
+	// SupervisorDir keeps unit files of services managed by supervisor, see package supervisor.
+	SupervisorDir = fmt.Sprintf("%s/supervisor", PMMBaseDir)
+
 	ErrDuplicate  = errors.New("there is already one instance with this name under monitoring.")
 	ErrNoService  = errors.New("no service found.")
+	ErrNoOptions  = errors.New("service was added by a previous version and its options are unknown, remove and add it again.")
 	errNoInstance = errors.New("no instance found on QAN API.")
 )
 
@@ -0,0 +1,312 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	service "github.com/percona/kardianos-service"
+	"github.com/percona/pmm-client/pmm/plugin"
+)
+
+// applyCredentials writes database credentials of exporter to a file readable by root only,
+// so service definition, which is readable by everyone, only references it.
+// Exporter reads the file itself if plugin supports it, otherwise it is started via `pmm-admin exec`
+// with the environment from the file. It returns the written files.
+func applyCredentials(svcConfig *service.Config, m plugin.Metrics) ([]string, error) {
+	if cf, ok := m.(plugin.CredentialsFiler); ok {
+		file := filepath.Join(CredentialsDir, svcConfig.Name+".cnf")
+		if content, args, env, ok := cf.CredentialsFile(file); ok {
+			if err := writeCredentialsFile(file, content); err != nil {
+				return nil, err
+			}
+			svcConfig.Arguments = append(svcConfig.Arguments, args...)
+			svcConfig.Environment = append(svcConfig.Environment, env...)
+			return []string{file}, nil
+		}
+	}
+
+	env := m.Environment()
+	if len(env) == 0 {
+		return nil, nil
+	}
+	file := envFile(svcConfig.Name)
+	if err := writeCredentialsFile(file, []byte(strings.Join(env, "\n")+"\n")); err != nil {
+		return nil, err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if err := wrapCommand(svcConfig, executable, "exec", "--env-file="+file, "--"); err != nil {
+		return nil, err
+	}
+	return []string{file}, nil
+}
+
+// envFile returns file with environment of service read by `pmm-admin exec`.
+func envFile(name string) string {
+	return filepath.Join(CredentialsDir, name+".env")
+}
+
+// writeCredentialsFile writes file readable by root only.
+func writeCredentialsFile(file string, content []byte) error {
+	if err := os.MkdirAll(CredentialsDir, 0700); err != nil {
+		return err
+	}
+	// Remove file first, so the mode is applied even if it exists.
+	os.Remove(file)
+	return ioutil.WriteFile(file, content, 0600)
+}
+
+// removeCredentials removes credentials files of service, if any.
+func removeCredentials(name string) {
+	os.Remove(filepath.Join(CredentialsDir, name+".cnf"))
+	os.Remove(envFile(name))
+}
+
+// readEnvFile reads environment variables in the form KEY=value, one per line.
+func readEnvFile(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	for i, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		// Don't print the line, it likely contains password.
+		if !strings.Contains(line, "=") {
+			return nil, fmt.Errorf("%s: line %d is not in the form KEY=value", file, i+1)
+		}
+		env = append(env, line)
+	}
+	return env, nil
+}
+
+// ExecWithEnvFile runs command with environment variables from file, forwarding signals to it.
+// It returns exit code of the command.
+func ExecWithEnvFile(file string, args []string) (int, error) {
+	if len(args) == 0 {
+		return 1, fmt.Errorf("no command specified")
+	}
+	env, err := readEnvFile(file)
+	if err != nil {
+		return 1, err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	if err := cmd.Start(); err != nil {
+		return 1, err
+	}
+	go func() {
+		for s := range signals {
+			cmd.Process.Signal(s)
+		}
+	}()
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+// envLineRegex matches environment of service in systemd, upstart and SysV init definitions.
+var envLineRegex = regexp.MustCompile(`(?m)^(Environment=|env |export )(.+)\n`)
+
+// execLineRegex matches the start of service command in systemd, upstart and SysV init definitions.
+var execLineRegex = regexp.MustCompile(`(?m)^(ExecStart=/bin/sh -c '|exec |cmd=')`)
+
+// moveServiceEnvironment moves environment from service definition written by the platform service manager
+// to the file and makes service run via `pmm-admin exec` reading it. It returns false if there is no environment.
+func moveServiceEnvironment(definition []byte, file, executable string) ([]byte, []string, bool) {
+	var env []string
+	for _, m := range envLineRegex.FindAllSubmatch(definition, -1) {
+		env = append(env, unquoteEnv(string(m[1]), string(m[2])))
+	}
+	if len(env) == 0 || !execLineRegex.Match(definition) {
+		return definition, nil, false
+	}
+	definition = envLineRegex.ReplaceAll(definition, nil)
+	wrapper := fmt.Sprintf("${1}%s exec --env-file=%s -- ", executable, file)
+	definition = execLineRegex.ReplaceAll(definition, []byte(wrapper))
+	return definition, env, true
+}
+
+// unquoteEnv reverts quoting of environment variable by service templates.
+func unquoteEnv(prefix, value string) string {
+	if prefix == "Environment=" {
+		value = strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)
+		value = strings.Replace(value, `\"`, `"`, -1)
+		return strings.Replace(value, `%%`, `%`, -1)
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, `'`), `'`)
+	return strings.Replace(value, `'"'"'`, `'`, -1)
+}
+
+// migrateCredentials moves environment of metrics services installed by previous versions of pmm-admin
+// from service definitions to credentials files. It returns the number of migrated services.
+func migrateCredentials() (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	dir, extension := GetServiceDirAndExtension()
+	var migrated []string
+	for _, name := range GetLocalServices() {
+		if !strings.Contains(name, "-metrics-") {
+			continue
+		}
+		file := filepath.Join(dir, name+extension)
+		var ok bool
+		if Platform() == ServiceManagerSupervisor {
+			ok, err = migrateUnitCredentials(file, envFile(name), executable)
+		} else {
+			ok, err = migrateServiceCredentials(file, envFile(name), executable)
+		}
+		if err != nil {
+			return len(migrated), fmt.Errorf("%s: %s", name, err)
+		}
+		if ok {
+			migrated = append(migrated, name)
+		}
+	}
+	if len(migrated) == 0 {
+		return 0, nil
+	}
+
+	if Platform() == "linux-systemd" {
+		if out, err := exec.Command("systemctl", "daemon-reload").CombinedOutput(); err != nil {
+			return 0, fmt.Errorf("systemctl daemon-reload: %s %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	for _, name := range migrated {
+		// Service running as other user via systemd drop-in starts `pmm-admin exec` as that user.
+		if username := dropInUser(name); username != "" {
+			if err := grantReadAccess(username, envFile(name)); err != nil {
+				return 0, err
+			}
+		}
+		// Supervisor restarts changed services itself.
+		if Platform() == ServiceManagerSupervisor || !getServiceStatus(name) {
+			continue
+		}
+		if err := stopService(name); err != nil {
+			return 0, err
+		}
+		if err := startService(name); err != nil {
+			return 0, err
+		}
+	}
+	return len(migrated), nil
+}
+
+// dropInUser returns user service runs as according to systemd drop-in with resources, empty if none.
+func dropInUser(name string) string {
+	if Platform() != "linux-systemd" {
+		return ""
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dropInDir(name), resourcesDropIn))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "User=") {
+			return strings.TrimPrefix(line, "User=")
+		}
+	}
+	return ""
+}
+
+// migrateServiceCredentials moves environment from service definition file to env file.
+func migrateServiceCredentials(file, envFile, executable string) (bool, error) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return false, err
+	}
+	definition, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	definition, env, ok := moveServiceEnvironment(definition, envFile, executable)
+	if !ok {
+		return false, nil
+	}
+	if err := writeCredentialsFile(envFile, []byte(strings.Join(env, "\n")+"\n")); err != nil {
+		return false, err
+	}
+	return true, ioutil.WriteFile(file, definition, fi.Mode())
+}
+
+// migrateUnitCredentials moves environment from supervisor unit file to env file.
+func migrateUnitCredentials(file, envFile, executable string) (bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	var u map[string]interface{}
+	if err := json.Unmarshal(data, &u); err != nil {
+		return false, err
+	}
+	var env []string
+	if list, ok := u["Environment"].([]interface{}); ok {
+		for _, v := range list {
+			env = append(env, fmt.Sprint(v))
+		}
+	}
+	if len(env) == 0 {
+		return false, nil
+	}
+	if err := writeCredentialsFile(envFile, []byte(strings.Join(env, "\n")+"\n")); err != nil {
+		return false, err
+	}
+	args := []interface{}{"exec", "--env-file=" + envFile, "--", u["Executable"]}
+	if list, ok := u["Arguments"].([]interface{}); ok {
+		args = append(args, list...)
+	}
+	delete(u, "Environment")
+	u["Executable"] = executable
+	u["Arguments"] = args
+	data, _ = json.Marshal(u)
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return false, err
+	}
+	return true, os.Rename(tmp, file)
+}
@@ -0,0 +1,440 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/percona/go-mysql/dsn"
+)
+
+// Instance is a database instance running on this system found by DiscoverInstances.
+type Instance struct {
+	// Type of `pmm-admin add` command: mysql, mongodb, postgresql or proxysql.
+	Type   string
+	PID    int
+	Binary string
+	// Addrs are listening TCP addresses sorted by port.
+	Addrs []string
+	// Sockets are listening unix sockets.
+	Sockets []string
+	Config  string
+	// Name is a suggested service name, empty for the client name.
+	Name string
+}
+
+// daemons maps process names to instance types.
+var daemons = map[string]string{
+	"mysqld":     "mysql",
+	"mariadbd":   "mysql",
+	"mongod":     "mongodb",
+	"mongos":     "mongodb",
+	"postgres":   "postgresql",
+	"postmaster": "postgresql",
+	"proxysql":   "proxysql",
+}
+
+// configFiles are default config files of the instance types, used when not given on command line.
+var configFiles = map[string][]string{
+	"mysql":    {"/etc/my.cnf", "/etc/mysql/my.cnf"},
+	"mongodb":  {"/etc/mongod.conf"},
+	"proxysql": {"/etc/proxysql.cnf"},
+}
+
+// DiscoverInstances scans processes of this system for known database daemons with their listening
+// TCP ports, unix sockets and config files. Instances of the same type are given distinct names.
+func (a *Admin) DiscoverInstances(ctx context.Context) ([]Instance, error) {
+	instances, err := discoverInstances("/")
+	if err != nil {
+		return nil, err
+	}
+
+	// Sockets of processes are not readable without root, use MySQL client defaults the same way as `pmm-admin add mysql`.
+	for n, i := range instances {
+		if i.Type != "mysql" || len(i.Addrs) > 0 || len(i.Sockets) > 0 {
+			continue
+		}
+		if mysqlDSN, err := (dsn.DSN{DefaultsFile: i.Config}).AutoDetect(ctx); err == nil && mysqlDSN.Socket != "" {
+			instances[n].Sockets = []string{mysqlDSN.Socket}
+		}
+	}
+
+	count := map[string]int{}
+	for _, i := range instances {
+		count[i.Type]++
+	}
+	for n, i := range instances {
+		if count[i.Type] > 1 {
+			instances[n].Name = fmt.Sprintf("%s-%s", a.Config.ClientName, i.id())
+		}
+	}
+	return instances, nil
+}
+
+// discoverInstances scans processes in /proc under the given root directory.
+func discoverInstances(root string) ([]Instance, error) {
+	proc := filepath.Join(root, "proc")
+	listeners, err := readListeners(proc)
+	if err != nil {
+		return nil, err
+	}
+	sockets, err := readUnixListeners(filepath.Join(proc, "net/unix"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dirs, err := ioutil.ReadDir(proc)
+	if err != nil {
+		return nil, err
+	}
+	var instances []Instance
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		instanceType := daemons[processName(proc, pid)]
+		if instanceType == "" {
+			continue
+		}
+		// Skip workers forked by the daemon, e.g. PostgreSQL backends.
+		if daemons[processName(proc, parentPID(proc, pid))] == instanceType {
+			continue
+		}
+
+		i := Instance{
+			Type: instanceType,
+			PID:  pid,
+		}
+		i.Binary, _ = os.Readlink(filepath.Join(proc, dir.Name(), "exe"))
+		if i.Binary == "" {
+			i.Binary = processName(proc, pid)
+		}
+
+		seen := map[string]bool{}
+		for _, inode := range socketInodes(proc, pid) {
+			if addr, ok := listeners[inode]; ok && !seen[addr] {
+				seen[addr] = true
+				i.Addrs = append(i.Addrs, addr)
+			}
+			if path, ok := sockets[inode]; ok && !seen[path] {
+				seen[path] = true
+				i.Sockets = append(i.Sockets, path)
+			}
+		}
+		sort.Slice(i.Addrs, func(a, b int) bool { return addrPort(i.Addrs[a]) < addrPort(i.Addrs[b]) })
+		sort.Strings(i.Sockets)
+
+		i.Config = configFile(root, instanceType, processArgs(proc, pid))
+		instances = append(instances, i)
+	}
+	return instances, nil
+}
+
+// Port returns the main port of the instance: the lowest listening one, e.g. 3306 for mysqld listening on 3306 and 33060,
+// or the port of PostgreSQL socket. It returns an empty string if the instance doesn't listen.
+func (i Instance) Port() string {
+	if len(i.Addrs) > 0 {
+		_, port, _ := net.SplitHostPort(i.Addrs[0])
+		return port
+	}
+	for _, socket := range i.Sockets {
+		if strings.HasPrefix(filepath.Base(socket), ".s.PGSQL.") {
+			return strings.TrimPrefix(filepath.Base(socket), ".s.PGSQL.")
+		}
+	}
+	return ""
+}
+
+// id returns identifier of the instance distinct from the other instances of the same type.
+func (i Instance) id() string {
+	if port := i.Port(); port != "" {
+		return port
+	}
+	return strconv.Itoa(i.PID)
+}
+
+// AddFlags returns flags of `pmm-admin add` command to connect to the instance.
+func (i Instance) AddFlags() map[string]string {
+	flags := map[string]string{}
+	var addr string
+	if len(i.Addrs) > 0 {
+		addr = i.Addrs[0]
+	}
+	switch i.Type {
+	case "mysql":
+		if len(i.Sockets) > 0 {
+			flags["socket"] = i.Sockets[0]
+		} else if addr != "" {
+			flags["host"], flags["port"], _ = net.SplitHostPort(addr)
+		}
+	case "mongodb":
+		if addr != "" {
+			flags["uri"] = addr
+		}
+	case "postgresql":
+		if addr != "" {
+			flags["host"], flags["port"], _ = net.SplitHostPort(addr)
+		} else if port := i.Port(); port != "" {
+			flags["port"] = port
+		}
+	case "proxysql":
+		if addr != "" {
+			flags["dsn"] = fmt.Sprintf("stats:stats@tcp(%s)/", addr)
+		}
+	}
+	return flags
+}
+
+// AddCommand returns suggested `pmm-admin add` command line for the instance.
+func (i Instance) AddCommand() string {
+	flags := i.AddFlags()
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{"pmm-admin", "add", i.Type}
+	for _, name := range names {
+		args = append(args, "--"+name, shellQuote(flags[name]))
+	}
+	if i.Name != "" {
+		args = append(args, i.Name)
+	}
+	return strings.Join(args, " ")
+}
+
+// Services returns services added by `pmm-admin add` command for the instance, except linux:metrics.
+func (i Instance) Services() []ManifestService {
+	var kinds []string
+	switch i.Type {
+	case "proxysql":
+		kinds = []string{"metrics"}
+	default:
+		kinds = []string{"metrics", "queries"}
+	}
+	var services []ManifestService
+	for _, kind := range kinds {
+		services = append(services, ManifestService{
+			Type:  i.Type + ":" + kind,
+			Name:  i.Name,
+			Flags: i.AddFlags(),
+		})
+	}
+	return services
+}
+
+var shellSafe = regexp.MustCompile(`^[-\w./:@=,]+$`)
+
+// shellQuote quotes value for shell if needed.
+func shellQuote(value string) string {
+	if shellSafe.MatchString(value) {
+		return value
+	}
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
+
+// processName returns name of the process, empty if the process doesn't exist.
+func processName(proc string, pid int) string {
+	b, err := ioutil.ReadFile(filepath.Join(proc, strconv.Itoa(pid), "comm"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// parentPID returns PID of the parent process, 0 if unknown.
+func parentPID(proc string, pid int) int {
+	b, err := ioutil.ReadFile(filepath.Join(proc, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+	// Process name in parentheses may contain spaces, fields after it are: state ppid ...
+	stat := string(b)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+// processArgs returns command line arguments of the process.
+func processArgs(proc string, pid int) []string {
+	b, err := ioutil.ReadFile(filepath.Join(proc, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(string(b), "\x00"), "\x00")
+}
+
+// configFile returns config file of the instance given on the command line or the default one if it exists.
+func configFile(root, instanceType string, args []string) string {
+	value := func(names ...string) string {
+		for n, arg := range args {
+			for _, name := range names {
+				if strings.HasPrefix(arg, name+"=") {
+					return strings.TrimPrefix(arg, name+"=")
+				}
+				if arg == name && n+1 < len(args) {
+					return args[n+1]
+				}
+			}
+		}
+		return ""
+	}
+
+	switch instanceType {
+	case "mysql":
+		if file := value("--defaults-file"); file != "" {
+			return file
+		}
+	case "mongodb":
+		if file := value("--config", "-f"); file != "" {
+			return file
+		}
+	case "proxysql":
+		if file := value("--config", "-c"); file != "" {
+			return file
+		}
+	case "postgresql":
+		if file := value("config_file"); file != "" {
+			return file
+		}
+		if dir := value("-D"); dir != "" {
+			return filepath.Join(dir, "postgresql.conf")
+		}
+	}
+	for _, file := range configFiles[instanceType] {
+		if _, err := os.Stat(filepath.Join(root, file)); err == nil {
+			return file
+		}
+	}
+	return ""
+}
+
+// readListeners returns listening TCP sockets of IPv4 and IPv6 from /proc/net files as a map of inode to host:port.
+func readListeners(proc string) (map[string]string, error) {
+	listeners := map[string]string{}
+	for _, file := range []string{"net/tcp", "net/tcp6"} {
+		if err := readTCPListeners(filepath.Join(proc, file), listeners); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
+
+// socketInodes returns inodes of sockets the process has open.
+func socketInodes(proc string, pid int) []string {
+	fds, _ := filepath.Glob(filepath.Join(proc, strconv.Itoa(pid), "fd", "*"))
+	var inodes []string
+	for _, fd := range fds {
+		link, _ := os.Readlink(fd)
+		if strings.HasPrefix(link, "socket:[") {
+			inodes = append(inodes, strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]"))
+		}
+	}
+	return inodes
+}
+
+// readTCPListeners adds listening sockets from /proc/net/tcp or /proc/net/tcp6 file to the map of inode to address.
+// Wildcard addresses are replaced with 127.0.0.1.
+func readTCPListeners(file string, listeners map[string]string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(b), "\n")
+	for _, line := range lines[1:] {
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		fields := strings.Fields(line)
+		if len(fields) < 10 || fields[3] != "0A" {
+			continue
+		}
+		parts := strings.Split(fields[1], ":")
+		if len(parts) != 2 {
+			continue
+		}
+		ip, err := parseProcIP(parts[0])
+		if err != nil {
+			continue
+		}
+		port, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			continue
+		}
+		if ip.IsUnspecified() {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+		listeners[fields[9]] = net.JoinHostPort(ip.String(), strconv.FormatUint(port, 10))
+	}
+	return nil
+}
+
+// parseProcIP parses IP address from /proc/net/tcp format: hex encoded 32-bit words in host (little endian) byte order.
+func parseProcIP(s string) (net.IP, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != net.IPv4len && len(b) != net.IPv6len {
+		return nil, fmt.Errorf("invalid address %s", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	return net.IP(b), nil
+}
+
+// readUnixListeners returns listening unix sockets from /proc/net/unix file as a map of inode to path.
+func readUnixListeners(file string) (map[string]string, error) {
+	sockets := map[string]string{}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return sockets, err
+	}
+	lines := strings.Split(string(b), "\n")
+	for _, line := range lines[1:] {
+		// Num RefCount Protocol Flags Type St Inode Path
+		fields := strings.Fields(line)
+		// __SO_ACCEPTCON flag is set for listening sockets, abstract sockets start with @.
+		if len(fields) < 8 || fields[3] != "00010000" || !strings.HasPrefix(fields[7], "/") {
+			continue
+		}
+		sockets[fields[6]] = fields[7]
+	}
+	return sockets, nil
+}
+
+// addrPort returns port of host:port address.
+func addrPort(addr string) int {
+	_, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return p
+}
@@ -0,0 +1,130 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProcess creates /proc/<pid> entries of the process under the root directory.
+func fakeProcess(t *testing.T, root string, pid, ppid int, name, exe string, args []string, sockets ...string) {
+	dir := filepath.Join(root, "proc", strconv.Itoa(pid))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "fd"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "comm"), []byte(name+"\n"), 0644))
+	stat := strconv.Itoa(pid) + " (" + name + ") S " + strconv.Itoa(ppid) + " 1 1 0 -1\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644))
+	cmdline := strings.Join(append([]string{exe}, args...), "\x00") + "\x00"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0644))
+	assert.NoError(t, os.Symlink(exe, filepath.Join(dir, "exe")))
+	assert.NoError(t, os.Symlink("/dev/null", filepath.Join(dir, "fd", "0")))
+	for n, inode := range sockets {
+		assert.NoError(t, os.Symlink("socket:["+inode+"]", filepath.Join(dir, "fd", strconv.Itoa(n+3))))
+	}
+}
+
+func TestDiscoverInstances(t *testing.T) {
+	root, err := ioutil.TempDir("", "pmm-client-test-discover-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "proc", "net"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "etc", "my.cnf"), nil, 0644))
+
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:6989 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 2001 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 3001 1 0000000000000000 100 0 0 10 0
+   3: 0100007F:0CEA 0100007F:D2A4 01 00000000:00000000 00:00000000 00000000   999        0 1003 1 0000000000000000 20 4 30 10 -1
+`
+	tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:8124 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1002 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000001000000:1538 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 3002 1 0000000000000000 100 0 0 10 0
+`
+	unix := `Num       RefCount Protocol Flags    Type St Inode Path
+0000000000000000: 00000002 00000000 00010000 0001 01 1004 /var/run/mysqld/mysqld.sock
+0000000000000000: 00000002 00000000 00010000 0001 01 3003 /var/run/postgresql/.s.PGSQL.5432
+0000000000000000: 00000003 00000000 00000000 0001 03 1005 /var/run/mysqld/mysqld.sock
+0000000000000000: 00000002 00000000 00010000 0001 01 9001 @/tmp/.X11-unix/X0
+`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "proc", "net", "tcp"), []byte(tcp), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "proc", "net", "tcp6"), []byte(tcp6), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "proc", "net", "unix"), []byte(unix), 0644))
+
+	fakeProcess(t, root, 1, 0, "systemd", "/lib/systemd/systemd", nil)
+	fakeProcess(t, root, 100, 1, "mysqld", "/usr/sbin/mysqld", []string{"--datadir=/var/lib/mysql"}, "1001", "1002", "1003", "1004", "1005")
+	fakeProcess(t, root, 200, 1, "mongod", "/usr/bin/mongod", []string{"--config", "/etc/mongod-rs0.conf"}, "2001")
+	fakeProcess(t, root, 300, 1, "postgres", "/usr/lib/postgresql/10/bin/postgres", []string{"-D", "/var/lib/postgresql/10/main"}, "3001", "3002", "3003")
+	fakeProcess(t, root, 301, 300, "postgres", "/usr/lib/postgresql/10/bin/postgres", nil)
+	fakeProcess(t, root, 400, 1, "bash", "/bin/bash", nil)
+
+	instances, err := discoverInstances(root)
+	assert.NoError(t, err)
+	expected := []Instance{
+		{
+			Type:    "mysql",
+			PID:     100,
+			Binary:  "/usr/sbin/mysqld",
+			Addrs:   []string{"127.0.0.1:3306", "127.0.0.1:33060"},
+			Sockets: []string{"/var/run/mysqld/mysqld.sock"},
+			Config:  "/etc/my.cnf",
+		},
+		{
+			Type:   "mongodb",
+			PID:    200,
+			Binary: "/usr/bin/mongod",
+			Addrs:  []string{"127.0.0.1:27017"},
+			Config: "/etc/mongod-rs0.conf",
+		},
+		{
+			Type:    "postgresql",
+			PID:     300,
+			Binary:  "/usr/lib/postgresql/10/bin/postgres",
+			Addrs:   []string{"127.0.0.1:5432", "[::1]:5432"},
+			Sockets: []string{"/var/run/postgresql/.s.PGSQL.5432"},
+			Config:  "/var/lib/postgresql/10/main/postgresql.conf",
+		},
+	}
+	assert.Equal(t, expected, instances)
+
+	assert.Equal(t, "pmm-admin add mysql --socket /var/run/mysqld/mysqld.sock", instances[0].AddCommand())
+	assert.Equal(t, "pmm-admin add mongodb --uri 127.0.0.1:27017", instances[1].AddCommand())
+	instances[2].Name = "db01-5432"
+	assert.Equal(t, "pmm-admin add postgresql --host 127.0.0.1 --port 5432 db01-5432", instances[2].AddCommand())
+	assert.Equal(t, []ManifestService{
+		{Type: "postgresql:metrics", Name: "db01-5432", Flags: map[string]string{"host": "127.0.0.1", "port": "5432"}},
+		{Type: "postgresql:queries", Name: "db01-5432", Flags: map[string]string{"host": "127.0.0.1", "port": "5432"}},
+	}, instances[2].Services())
+}
+
+func TestInstanceAddFlags(t *testing.T) {
+	i := Instance{Type: "proxysql", Addrs: []string{"127.0.0.1:6032", "127.0.0.1:6033"}}
+	assert.Equal(t, "pmm-admin add proxysql --dsn 'stats:stats@tcp(127.0.0.1:6032)/'", i.AddCommand())
+	assert.Len(t, i.Services(), 1)
+
+	i = Instance{Type: "postgresql", Sockets: []string{"/tmp/.s.PGSQL.5433"}}
+	assert.Equal(t, "5433", i.Port())
+	assert.Equal(t, map[string]string{"port": "5433"}, i.AddFlags())
+}
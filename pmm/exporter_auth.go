@@ -0,0 +1,191 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultExporterUser is HTTP user of exporters.
+const DefaultExporterUser = "pmm"
+
+// exporterCredentials returns HTTP credentials exporters are protected with.
+// Unless own exporter credentials are generated with rotate-exporter-password, exporters use the server ones.
+func (c *Config) exporterCredentials() (string, string) {
+	if c.ExporterPassword != "" {
+		return c.ExporterUser, c.ExporterPassword
+	}
+	return c.ServerUser, c.ServerPassword
+}
+
+// writeAuthFile writes exporter credentials to AuthFile passed to exporters.
+// Keys are the same as in config file, exporters read them from there before.
+func (c *Config) writeAuthFile() error {
+	user, password := c.exporterCredentials()
+	auth := struct {
+		ServerUser     string `yaml:"server_user,omitempty"`
+		ServerPassword string `yaml:"server_password,omitempty"`
+	}{user, password}
+	data, _ := yaml.Marshal(auth)
+	return ioutil.WriteFile(AuthFile, data, 0600)
+}
+
+// exporterAuthM guards AuthFile, metrics services may be added concurrently.
+var exporterAuthM sync.Mutex
+
+// ensureExporterAuth writes exporter credentials to AuthFile.
+// Exporters still reading server credentials from config are switched to AuthFile and restarted,
+// their names are returned.
+func (a *Admin) ensureExporterAuth() ([]string, error) {
+	exporterAuthM.Lock()
+	defer exporterAuthM.Unlock()
+
+	if err := a.Config.writeAuthFile(); err != nil {
+		return nil, err
+	}
+	return switchExportersToAuthFile()
+}
+
+// generateExporterPassword returns random password for exporters HTTP basic authentication.
+func generateExporterPassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// legacyExporterFiles returns service files of exporters reading credentials from config file.
+func legacyExporterFiles() ([]string, error) {
+	re := authFileArgRegex(ConfigFile)
+	dir, extension := GetServiceDirAndExtension()
+	var files []string
+	for _, name := range GetLocalServices() {
+		file := filepath.Join(dir, name+extension)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if re.Match(data) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// switchExportersToAuthFile points exporters reading credentials from config file to AuthFile
+// and restarts them. It returns the names of switched services.
+func switchExportersToAuthFile() ([]string, error) {
+	files, err := legacyExporterFiles()
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	// Exporters running as other users were granted access to config file.
+	copyACL(ConfigFile, AuthFile)
+	updated, err := replaceAuthFileArg(files, ConfigFile, AuthFile)
+	if err != nil {
+		return nil, err
+	}
+	if Platform() == "linux-systemd" {
+		if out, err := exec.Command("systemctl", "daemon-reload").CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("systemctl daemon-reload: %s %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	_, extension := GetServiceDirAndExtension()
+	var names []string
+	for _, file := range updated {
+		names = append(names, strings.TrimSuffix(filepath.Base(file), extension))
+	}
+	return names, restartRunning(names)
+}
+
+// restartRunning restarts running services in parallel, so exporters are unavailable for as short as possible.
+func restartRunning(names []string) error {
+	for i, err := range restartRunningEach(names) {
+		if err != nil {
+			return fmt.Errorf("Unable to restart %s service: %s", names[i], err)
+		}
+	}
+	return nil
+}
+
+// restartRunningEach is like restartRunning but returns restart error of each service.
+func restartRunningEach(names []string) []error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			if getServiceStatus(name) {
+				errs[i] = restartService(name)
+			}
+		}(i, name)
+	}
+	wg.Wait()
+	return errs
+}
+
+// RotateExporterPassword generates new exporter password and restarts exporters to pick it up.
+// It returns the number of updated services. The password isn't sent anywhere, scrape credentials
+// must be updated on PMM Server, otherwise it gets 401 Unauthorized.
+func (a *Admin) RotateExporterPassword() (int, error) {
+	password, err := generateExporterPassword()
+	if err != nil {
+		return 0, err
+	}
+	if a.Config.ExporterUser == "" {
+		a.Config.ExporterUser = DefaultExporterUser
+	}
+	a.Config.ExporterPassword = password
+	if err := a.writeConfig(); err != nil {
+		return 0, err
+	}
+	switched, err := a.ensureExporterAuth()
+	if err != nil {
+		return 0, err
+	}
+
+	skip := map[string]bool{}
+	for _, name := range switched {
+		skip[name] = true
+	}
+	var names []string
+	for _, name := range GetLocalServices() {
+		if strings.Contains(name, "-metrics-") && !skip[name] {
+			names = append(names, name)
+		}
+	}
+	// Push agent scrapes exporters with the password read on start.
+	if a.Config.Push {
+		names = append(names, PushServiceName)
+	}
+	if err := restartRunning(names); err != nil {
+		return 0, err
+	}
+	return len(switched) + len(names), nil
+}
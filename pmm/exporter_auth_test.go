@@ -0,0 +1,83 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestExporterCredentials(t *testing.T) {
+	c := &Config{ServerUser: "admin", ServerPassword: "server"}
+	user, password := c.exporterCredentials()
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "server", password)
+
+	c.ExporterUser = DefaultExporterUser
+	c.ExporterPassword = "exporter"
+	user, password = c.exporterCredentials()
+	assert.Equal(t, DefaultExporterUser, user)
+	assert.Equal(t, "exporter", password)
+}
+
+func TestWriteAuthFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-exporter-auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer func(old string) { AuthFile = old }(AuthFile)
+	AuthFile = filepath.Join(dir, "auth.yml")
+
+	c := &Config{ServerUser: "admin", ServerPassword: "server", ExporterUser: DefaultExporterUser, ExporterPassword: "exporter"}
+	assert.Nil(t, c.writeAuthFile())
+
+	fi, err := os.Stat(AuthFile)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode())
+
+	// Exporters read credentials with the same keys as from config file.
+	data, err := ioutil.ReadFile(AuthFile)
+	assert.Nil(t, err)
+	auth := map[string]string{}
+	assert.Nil(t, yaml.Unmarshal(data, &auth))
+	assert.Equal(t, map[string]string{"server_user": DefaultExporterUser, "server_password": "exporter"}, auth)
+
+	// Without own credentials exporters use the server ones.
+	c = &Config{ServerUser: "admin", ServerPassword: "server"}
+	assert.Nil(t, c.writeAuthFile())
+	data, err = ioutil.ReadFile(AuthFile)
+	assert.Nil(t, err)
+	auth = map[string]string{}
+	assert.Nil(t, yaml.Unmarshal(data, &auth))
+	assert.Equal(t, map[string]string{"server_user": "admin", "server_password": "server"}, auth)
+}
+
+func TestGenerateExporterPassword(t *testing.T) {
+	p1, err := generateExporterPassword()
+	assert.Nil(t, err)
+	p2, err := generateExporterPassword()
+	assert.Nil(t, err)
+	assert.Len(t, p1, 32)
+	assert.NotEqual(t, p1, p2)
+	// Password is written to YAML and used in basic auth as is.
+	assert.Regexp(t, `^[A-Za-z0-9_-]+$`, p1)
+}
@@ -0,0 +1,154 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/percona/pmm-client/pmm/push"
+)
+
+const (
+	// healthPollInterval is how often exporter endpoint is checked while waiting for it.
+	healthPollInterval = 500 * time.Millisecond
+	// healthLogLines is a number of exporter log lines shown when it is not healthy.
+	healthLogLines = 10
+)
+
+// healthCheck waits for installed exporter of the service type to become healthy, upMetric may be empty.
+type healthCheck func(svcType, upMetric, svcName string, port int, disableSSL bool) error
+
+// exporterHealthCheck returns health check of installed exporters, nil if it is disabled.
+func (a *Admin) exporterHealthCheck() healthCheck {
+	switch {
+	case a.HealthTimeout <= 0:
+		return nil
+	case a.healthCheck != nil:
+		return a.healthCheck
+	case Version == "gotest":
+		// Dummy services of pmm-admin tests don't run exporters, see service.go.
+		return nil
+	}
+	return a.checkExporterHealth
+}
+
+// metricsPath returns path of exporter endpoint used for checks.
+func metricsPath(svcType string) string {
+	if svcType == "mysql:metrics" {
+		return "metrics-hr"
+	}
+	return "metrics"
+}
+
+// pluginUpMetric returns the up metric of plugin exporter, empty if plugin doesn't report it.
+func pluginUpMetric(m plugin.Metrics) string {
+	if r, ok := m.(plugin.UpReporter); ok {
+		return r.UpMetric()
+	}
+	return ""
+}
+
+// checkExporterHealth waits up to a.HealthTimeout for exporter endpoint to answer and upMetric, if set, to be 1.
+// If it doesn't happen, the returned error includes the last lines of exporter log.
+// The wait doesn't depend on command timeout which is usually shorter.
+func (a *Admin) checkExporterHealth(svcType, upMetric, svcName string, port int, disableSSL bool) error {
+	scheme := "https"
+	if disableSSL {
+		scheme = "http"
+	}
+	url := fmt.Sprintf("%s://%s:%d/%s", scheme, a.Config.BindAddress, port, metricsPath(svcType))
+	client := &http.Client{
+		Timeout: healthPollInterval * 4,
+		// Exporters use self-signed certificate generated by pmm-admin.
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.HealthTimeout)
+	defer cancel()
+	var err error
+	for {
+		if err = a.checkEndpoint(ctx, client, url, upMetric); err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s exporter is not healthy after %s: %s%s", svcType, a.HealthTimeout, err, exporterLogTail(svcName))
+		case <-time.After(healthPollInterval):
+		}
+	}
+}
+
+// checkEndpoint returns nil if exporter endpoint answers and the up metric, if set, is 1.
+func (a *Admin) checkEndpoint(ctx context.Context, client *http.Client, url, upMetric string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if user, password := a.Config.exporterCredentials(); password != "" {
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	if upMetric == "" {
+		ioutil.ReadAll(resp.Body)
+		return nil
+	}
+
+	series, err := push.Parse(resp.Body)
+	if err != nil {
+		return err
+	}
+	for _, s := range series {
+		for _, l := range s.Labels {
+			if l.Name == "__name__" && l.Value == upMetric {
+				if s.Value != 1 {
+					return fmt.Errorf("%s is %v", upMetric, s.Value)
+				}
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s is missing", upMetric)
+}
+
+// exporterLogTail returns the last lines of exporter log formatted to be appended to error message.
+func exporterLogTail(svcName string) string {
+	if serviceManager == ServiceManagerSupervisor {
+		return ".\nSee output of 'pmm-admin run' for exporter log."
+	}
+	file := filepath.Join(LogsDir, svcName+".log")
+	lines, err := tailFile(file, healthLogLines)
+	if err != nil || len(lines) == 0 {
+		return "."
+	}
+	return fmt.Sprintf(".\nLast lines of %s:\n  %s", file, strings.Join(lines, "\n  "))
+}
@@ -0,0 +1,97 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMetricsHealth(t *testing.T) {
+	up := "0"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if r.URL.Path != "/metrics" || user != "pmm" || password != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "# TYPE redis_up gauge\nredis_up %s\n", up)
+	}))
+	defer ts.Close()
+	host, p, err := net.SplitHostPort(ts.Listener.Addr().String())
+	assert.Nil(t, err)
+	port, err := strconv.Atoi(p)
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "health")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	logsDir := LogsDir
+	LogsDir = dir
+	defer func() { LogsDir = logsDir }()
+	log := "time=\"2019-01-02T15:04:05Z\" level=info msg=\"Starting\"\n" +
+		"time=\"2019-01-02T15:04:06Z\" level=error msg=\"Error pinging redis: connection refused\"\n"
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "pmm-redis-metrics-42100.log"), []byte(log), 0644))
+
+	a := &Admin{
+		Config:        &Config{BindAddress: host, ServerUser: "pmm", ServerPassword: "secret"},
+		HealthTimeout: time.Second,
+	}
+
+	err = a.checkExporterHealth("redis:metrics", "redis_up", "pmm-redis-metrics-42100", port, true)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "redis:metrics exporter is not healthy after 1s: redis_up is 0.\n")
+	assert.Contains(t, err.Error(), "\n  time=\"2019-01-02T15:04:06Z\" level=error msg=\"Error pinging redis: connection refused\"")
+
+	up = "1"
+	assert.Nil(t, a.checkExporterHealth("redis:metrics", "redis_up", "pmm-redis-metrics-42100", port, true))
+
+	// Endpoint answering is enough for exporters without up metric.
+	up = "0"
+	assert.Nil(t, a.checkExporterHealth("redis:metrics", "", "pmm-redis-metrics-42100", port, true))
+
+	a.Config.ServerPassword = "wrong"
+	err = a.checkExporterHealth("redis:metrics", "", "pmm-redis-metrics-42100", port, true)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "404 Not Found")
+}
+
+func TestTailFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "tail")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("one\ntwo\nthree\n")
+	f.Close()
+
+	lines, err := tailFile(f.Name(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"two", "three"}, lines)
+	lines, err = tailFile(f.Name(), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"one", "two", "three"}, lines)
+}
@@ -0,0 +1,112 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReadInventory reads inventory of services to add and validates it.
+// Files with .csv extension are read as CSV, others as YAML manifest.
+//
+// CSV file starts with a header. Columns type, name, dsn and args (separated by spaces)
+// are the same as fields of ManifestService, other columns are names of flags,
+// empty cells are skipped. Example:
+//
+//	type,name,dsn,user,password
+//	mysql:metrics,db01,,root,abc123
+//	mongodb:metrics,rs01,mongodb://127.0.0.1:27017,,
+func ReadInventory(file string) (*Manifest, error) {
+	if strings.ToLower(filepath.Ext(file)) != ".csv" {
+		return ReadManifest(file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, err := readInventoryCSV(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse inventory %s: %s", file, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readInventoryCSV reads services from CSV with a header.
+func readInventoryCSV(r io.Reader) (*Manifest, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("header is missing")
+	}
+
+	header := records[0]
+	hasType := false
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		if header[i] == "type" {
+			hasType = true
+		}
+	}
+	if !hasType {
+		return nil, fmt.Errorf("header has no type column")
+	}
+
+	m := &Manifest{}
+	for _, record := range records[1:] {
+		svc := ManifestService{}
+		for i, value := range record {
+			value = strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			switch header[i] {
+			case "type":
+				svc.Type = value
+			case "name":
+				svc.Name = value
+			case "dsn":
+				svc.DSN = value
+			case "args":
+				svc.Args = strings.Fields(value)
+			default:
+				if svc.Flags == nil {
+					svc.Flags = map[string]string{}
+				}
+				svc.Flags[header[i]] = value
+			}
+		}
+		m.Services = append(m.Services, svc)
+	}
+	return m, nil
+}
@@ -0,0 +1,65 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadInventory(t *testing.T) {
+	m, err := ReadInventory("testdata/inventory.csv")
+	assert.Nil(t, err)
+	expected := &Manifest{
+		Services: []ManifestService{
+			{Type: "linux:metrics"},
+			{
+				Type:  "mysql:metrics",
+				Name:  "db01",
+				Flags: map[string]string{"user": "root", "password": "abc123"},
+				Args:  []string{"--collect.perf_schema.eventsstatements"},
+			},
+			{
+				Type:  "mysql:queries",
+				Name:  "db01",
+				Flags: map[string]string{"user": "root", "password": "abc123"},
+			},
+			{Type: "mongodb:metrics", Name: "rs01", DSN: "mongodb://127.0.0.1:27017"},
+		},
+	}
+	assert.Equal(t, expected, m)
+
+	// YAML inventory is the same as manifest.
+	m, err = ReadInventory("testdata/manifest.yml")
+	assert.Nil(t, err)
+	assert.Len(t, m.Services, 3)
+}
+
+func TestReadInventoryCSV(t *testing.T) {
+	invalid := map[string]string{
+		"empty":   "",
+		"no type": "name,dsn\ndb01,\n",
+		"columns": "type,name\nmysql:metrics,db01,root\n",
+	}
+	for name, content := range invalid {
+		_, err := readInventoryCSV(strings.NewReader(content))
+		assert.Error(t, err, name)
+	}
+}
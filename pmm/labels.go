@@ -0,0 +1,161 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// labelTagPrefix is a prefix of Consul tags carrying user labels, e.g. label_environment=prod.
+// PMM Server relabels them onto scraped series.
+const labelTagPrefix = "label_"
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by PMM Server from other tags, they can't be overridden by user labels.
+var reservedLabels = map[string]bool{
+	"instance": true,
+	"job":      true,
+	"alias":    true,
+	"cluster":  true,
+	"scheme":   true,
+}
+
+// ParseLabels parses list of labels in the form key=value.
+func ParseLabels(list []string) (map[string]string, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	labels := map[string]string{}
+	for _, l := range list {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("label %q should be in the form key=value", l)
+		}
+		if err := validateLabel(parts[0], parts[1]); err != nil {
+			return nil, err
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+// validateLabel checks that label is a valid Prometheus label and can be stored in Consul tag.
+func validateLabel(name, value string) error {
+	if !labelNameRegex.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("label name %q must contain only letters, numbers and symbol _, and can't start with a number or __", name)
+	}
+	if reservedLabels[name] {
+		return fmt.Errorf("label name %q is reserved", name)
+	}
+	if value == "" || strings.Contains(value, ",") {
+		return fmt.Errorf("label %s value must be non-empty and can't contain symbol ,", name)
+	}
+	return nil
+}
+
+// FormatLabels returns labels as sorted list of key=value separated by comma.
+func FormatLabels(labels map[string]string) string {
+	list := make([]string, 0, len(labels))
+	for k, v := range labels {
+		list = append(list, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+// labelTags returns Consul tags for labels sorted by name.
+func labelTags(labels map[string]string) []string {
+	tags := make([]string, 0, len(labels))
+	for k, v := range labels {
+		tags = append(tags, fmt.Sprintf("%s%s=%s", labelTagPrefix, k, v))
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// splitLabelTags returns labels stored in Consul tags and the rest of tags.
+func splitLabelTags(tags []string) (map[string]string, []string) {
+	labels := map[string]string{}
+	var other []string
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, labelTagPrefix) {
+			parts := strings.SplitN(tag[len(labelTagPrefix):], "=", 2)
+			if len(parts) == 2 {
+				labels[parts[0]] = parts[1]
+				continue
+			}
+		}
+		other = append(other, tag)
+	}
+	return labels, other
+}
+
+// serviceLabels returns labels of the service being added: node labels from config
+// overridden by labels given to pmm-admin.
+func (a *Admin) serviceLabels() (map[string]string, error) {
+	labels := map[string]string{}
+	for k, v := range a.Config.Labels {
+		if err := validateLabel(k, v); err != nil {
+			return nil, fmt.Errorf("%s: %s", ConfigFile, err)
+		}
+		labels[k] = v
+	}
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// SetLabels sets and removes labels of existing metrics service and returns the resulting labels.
+func (a *Admin) SetLabels(serviceType string, set map[string]string, remove []string) (map[string]string, error) {
+	if !strings.HasSuffix(serviceType, ":metrics") {
+		return nil, fmt.Errorf("labels are supported by metrics services only")
+	}
+	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	if consulSvc == nil {
+		return nil, ErrNoService
+	}
+
+	labels, tags := splitLabelTags(consulSvc.Tags)
+	for k, v := range set {
+		labels[k] = v
+	}
+	for _, k := range remove {
+		delete(labels, k)
+	}
+
+	consulSvc.Tags = append(tags, labelTags(labels)...)
+	reg := consul.CatalogRegistration{
+		Node:    a.Config.ClientName,
+		Address: a.Config.ClientAddress,
+		Service: consulSvc,
+	}
+	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
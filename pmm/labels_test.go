@@ -0,0 +1,64 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels([]string{"environment=prod", "team=payments", "url=a=b"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"environment": "prod", "team": "payments", "url": "a=b"}, labels)
+
+	labels, err = ParseLabels(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, labels)
+
+	for _, l := range []string{"environment", "=prod", "1env=prod", "__name__=x", "env-1=x", "cluster=x", "env=", "env=a,b"} {
+		_, err := ParseLabels([]string{l})
+		assert.Error(t, err, l)
+	}
+}
+
+func TestLabelTags(t *testing.T) {
+	tags := labelTags(map[string]string{"team": "payments", "environment": "prod"})
+	assert.Equal(t, []string{"label_environment=prod", "label_team=payments"}, tags)
+
+	labels, other := splitLabelTags(append([]string{"alias_db01", "scheme_https"}, tags...))
+	assert.Equal(t, map[string]string{"environment": "prod", "team": "payments"}, labels)
+	assert.Equal(t, []string{"alias_db01", "scheme_https"}, other)
+
+	assert.Equal(t, "environment=prod, team=payments", FormatLabels(labels))
+}
+
+func TestServiceLabels(t *testing.T) {
+	a := Admin{
+		Config: &Config{Labels: map[string]string{"environment": "prod", "region": "eu"}},
+		Labels: map[string]string{"region": "us"},
+	}
+	labels, err := a.serviceLabels()
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"environment": "prod", "region": "us"}, labels)
+
+	a.Config.Labels["job"] = "x"
+	_, err = a.serviceLabels()
+	assert.Error(t, err)
+}
@@ -27,7 +27,6 @@ import (
 
 	"github.com/docker/cli/templates"
 	consul "github.com/hashicorp/consul/api"
-	"github.com/percona/kardianos-service"
 	pc "github.com/percona/pmm/proto/config"
 )
 
@@ -41,6 +40,7 @@ type ServiceStatus struct {
 	Options  string
 	SSL      string
 	Password string
+	Labels   map[string]string `json:",omitempty"`
 }
 
 // Sort rows of formatted table output (list, check-networks commands).
@@ -75,6 +75,7 @@ type List struct {
 	Services         []ServiceStatus
 	ExternalErr      string
 	ExternalServices []ExternalMetrics
+	CertWarning      string
 }
 
 // Table formats *List.Services as table and returns result as string.
@@ -181,14 +182,16 @@ const (
 {{.Err}}{{end}}{{if .Services}}
 {{.Table}}{{end}}{{if .ExternalErr}}
 {{.ExternalErr}}{{end}}{{if .ExternalServices}}
-{{.ExternalTable}}{{end}}`
+{{.ExternalTable}}{{end}}{{if .CertWarning}}
+{{.CertWarning}}
+{{end}}`
 )
 
 // List prints to stdout all services from Consul.
 func (a *Admin) List() error {
 	l := &List{
 		Version:    Version,
-		Platform:   service.Platform(),
+		Platform:   Platform(),
 		ServerInfo: a.serverInfo(),
 	}
 
@@ -218,6 +221,10 @@ func (a *Admin) List() error {
 	sort.Sort(sortOutput(svcTable))
 	l.Services = svcTable
 
+	if hasHTTPSServices(node) {
+		l.CertWarning = certWarning()
+	}
+
 	return nil
 }
 
@@ -240,6 +247,7 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 		opts := []string{}
 		name := "-"
 		dsn := "-"
+		labels, tags := splitLabelTags(svc.Tags)
 		// Get values for service from Consul KV.
 		prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, svc.ID)
 		if data, _, err := a.consulAPI.KV().List(prefix, nil); err == nil {
@@ -248,13 +256,14 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 				switch key {
 				case "dsn":
 					dsn = string(kvp.Value)
+				case optionsKV:
 				default:
 					opts = append(opts, fmt.Sprintf("%s=%s", key, kvp.Value))
 				}
 			}
 		}
 		// Parse Consul service tags.
-		for _, tag := range svc.Tags {
+		for _, tag := range tags {
 			if strings.HasPrefix(tag, "alias_") {
 				name = tag[6:]
 				continue
@@ -265,6 +274,11 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 			tag := strings.Replace(tag, "_", "=", 1)
 			opts = append(opts, tag)
 		}
+		if len(labels) > 0 {
+			opts = append(opts, FormatLabels(labels))
+		} else {
+			labels = nil
+		}
 
 		row := ServiceStatus{
 			Type:    svc.Service,
@@ -273,6 +287,7 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 			Running: status,
 			DSN:     dsn,
 			Options: strings.Join(opts, ", "),
+			Labels:  labels,
 		}
 		svcTable = append(svcTable, row)
 	}
@@ -300,7 +315,7 @@ func (a *Admin) getSVCTable(node *consul.CatalogNode) []ServiceStatus {
 					switch key {
 					case "dsn":
 						dsn = string(kvp.Value)
-					case "qan_mysql_uuid", "qan_mongodb_uuid":
+					case fmt.Sprintf("qan_%s_uuid", strings.Split(queryService.Service, ":")[0]):
 						f := fmt.Sprintf("%s/config/qan-%s.conf", AgentBaseDir, kvp.Value)
 						config, err := getProtoQAN(f)
 						if err != nil {
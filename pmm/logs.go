@@ -0,0 +1,305 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of log rotation, used when not set in config.
+const (
+	DefaultLogMaxSize   = 10 // MB
+	DefaultLogRetention = 5
+)
+
+var (
+	// Log rotation settings from config, applied when services are installed.
+	logMaxSize, logRetention int
+
+	// logPollInterval is how often followed log file is checked for new lines.
+	logPollInterval = 500 * time.Millisecond
+
+	// logTimeRegex matches timestamps written by exporters and qan-agent, e.g.
+	// 2019/01/02 15:04:05, time="2019-01-02T15:04:05Z", ts=2019-01-02T15:04:05.000+01:00.
+	logTimeRegex = regexp.MustCompile(`(\d{4})[-/](\d{2})[-/](\d{2})[T ](\d{2}:\d{2}:\d{2}(?:\.\d+)?)(Z|[+-]\d{2}:?\d{2})?`)
+)
+
+// setLogRotation sets log rotation settings from config.
+func setLogRotation(c *Config) {
+	logMaxSize, logRetention = c.LogMaxSize, c.LogRetention
+	if logMaxSize == 0 {
+		logMaxSize = DefaultLogMaxSize
+	}
+	if logRetention == 0 {
+		logRetention = DefaultLogRetention
+	}
+}
+
+// logRotateConfig returns logrotate config for log files of services.
+// Services append to log files, so they are truncated in place.
+func logRotateConfig(maxSize, retention int) string {
+	return fmt.Sprintf(`# Managed by pmm-admin, use 'pmm-admin config --log-max-size --log-retention' to change.
+%s/pmm-*.log {
+    size %dM
+    rotate %d
+    missingok
+    notifempty
+    compress
+    delaycompress
+    copytruncate
+}
+`, LogsDir, maxSize, retention)
+}
+
+// writeLogRotateConfig writes logrotate config for log files of services.
+// It does nothing if logrotate is not installed.
+func writeLogRotateConfig() error {
+	if !FileExists(filepath.Dir(LogRotateFile)) {
+		return nil
+	}
+	if logMaxSize == 0 {
+		setLogRotation(&Config{})
+	}
+	return ioutil.WriteFile(LogRotateFile, []byte(logRotateConfig(logMaxSize, logRetention)), 0644)
+}
+
+// ShowLogs writes log of the service with the given type and name a.ServiceName to w.
+// If since is set, only lines written since then are shown, including the last rotated log.
+// If follow is true, it waits for new lines until ctx is canceled.
+func (a *Admin) ShowLogs(ctx context.Context, svcType string, w io.Writer, follow bool, since time.Duration) error {
+	file, err := a.logFile(svcType)
+	if err != nil {
+		return err
+	}
+	filter := &logFilter{w: w, include: true}
+	if since > 0 {
+		filter.since = time.Now().Add(-since)
+		filter.include = false
+		if err := readLog(ctx, file+".1", filter, false); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err = readLog(ctx, file, filter, follow)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("log file %s not found", file)
+	}
+	return err
+}
+
+// logFile returns log file of the service with the given type and name a.ServiceName.
+func (a *Admin) logFile(svcType string) (string, error) {
+	if err := isValidSvcType(svcType); err != nil {
+		return "", err
+	}
+	if serviceManager == ServiceManagerSupervisor {
+		return "", errors.New("services run by supervisor write logs to stdout of 'pmm-admin run'")
+	}
+
+	var svcName string
+	if err := a.SetAPI(); err == nil {
+		consulSvc, err := a.getConsulService(svcType, a.ServiceName)
+		if err != nil {
+			return "", err
+		}
+		if consulSvc == nil {
+			return "", ErrNoService
+		}
+		svcName = fmt.Sprintf("pmm-%s-%d", strings.Replace(svcType, ":", "-", 1), consulSvc.Port)
+	} else {
+		// Server is not reachable, but the only local service of this type is the one.
+		local := localServicesOfType(svcType)
+		switch len(local) {
+		case 0:
+			return "", ErrNoService
+		case 1:
+			svcName = local[0]
+		default:
+			return "", fmt.Errorf("%s\nCannot choose between %s.", err, strings.Join(local, ", "))
+		}
+	}
+	return filepath.Join(LogsDir, svcName+".log"), nil
+}
+
+// localServicesOfType returns local services of the given type, pmm-<type>-<port>.
+func localServicesOfType(svcType string) []string {
+	prefix := fmt.Sprintf("pmm-%s-", strings.Replace(svcType, ":", "-", 1))
+	var services []string
+	for _, s := range GetLocalServices() {
+		if !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		if _, err := strconv.Atoi(s[len(prefix):]); err == nil {
+			services = append(services, s)
+		}
+	}
+	return services
+}
+
+// tailFile returns up to n last lines of file.
+func tailFile(file string, n int) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Long enough for n lines of exporter log.
+	const maxTail = 64 * 1024
+	if fi, err := f.Stat(); err == nil && fi.Size() > maxTail {
+		if _, err := f.Seek(-maxTail, io.SeekEnd); err != nil {
+			return nil, err
+		}
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimRight(string(b), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// readLog writes lines of file to filter. If follow is true, it waits for new lines until ctx is canceled,
+// reopening file when it is truncated or replaced by log rotation.
+func readLog(ctx context.Context, file string, filter *logFilter, follow bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+	}()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	var partial string
+	for {
+		line, err := r.ReadString('\n')
+		offset += int64(len(line))
+		if err == nil {
+			filter.line(partial + line)
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		partial += line
+		if !follow {
+			if partial != "" {
+				filter.line(partial + "\n")
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logPollInterval):
+		}
+
+		opened, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		current, err := os.Stat(file)
+		if err != nil {
+			// Log file is being rotated, wait for the new one.
+			continue
+		}
+		if os.SameFile(opened, current) && current.Size() >= offset {
+			continue
+		}
+		if os.SameFile(opened, current) {
+			// Truncated in place.
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		} else {
+			// Replaced.
+			newF, err := os.Open(file)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f = newF
+		}
+		r.Reset(f)
+		offset = 0
+		partial = ""
+	}
+}
+
+// logFilter writes lines to w, skipping lines written before since, if set.
+// Lines without timestamp, e.g. stack traces, follow the previous line.
+type logFilter struct {
+	w       io.Writer
+	since   time.Time
+	include bool
+}
+
+func (f *logFilter) line(line string) {
+	if !f.since.IsZero() {
+		if t, ok := parseLogTime(line); ok {
+			f.include = !t.Before(f.since)
+		}
+	}
+	if f.include {
+		io.WriteString(f.w, line)
+	}
+}
+
+// parseLogTime returns the first timestamp found in line, local time is assumed if it has no zone.
+func parseLogTime(line string) (time.Time, bool) {
+	m := logTimeRegex.FindStringSubmatch(line)
+	if m == nil {
+		return time.Time{}, false
+	}
+	value := fmt.Sprintf("%s-%s-%sT%s", m[1], m[2], m[3], m[4])
+	var t time.Time
+	var err error
+	switch zone := m[5]; {
+	case zone == "":
+		t, err = time.ParseInLocation("2006-01-02T15:04:05.999999999", value, time.Local)
+	case zone == "Z":
+		t, err = time.Parse(time.RFC3339Nano, value+zone)
+	default:
+		if !strings.Contains(zone, ":") {
+			zone = zone[:3] + ":" + zone[3:]
+		}
+		t, err = time.Parse(time.RFC3339Nano, value+zone)
+	}
+	return t, err == nil
+}
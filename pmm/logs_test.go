@@ -0,0 +1,127 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogTime(t *testing.T) {
+	for line, expected := range map[string]time.Time{
+		`time="2019-01-02T15:04:05Z" level=info msg="Starting"`:       time.Date(2019, 1, 2, 15, 4, 5, 0, time.UTC),
+		`level=info ts=2019-01-02T15:04:05.5+01:00 caller=main.go:42`: time.Date(2019, 1, 2, 14, 4, 5, 500000000, time.UTC),
+		`2019-01-02T15:04:05+0100 msg`:                                time.Date(2019, 1, 2, 14, 4, 5, 0, time.UTC),
+		`2019/01/02 15:04:05 main.go:42: Starting`:                    time.Date(2019, 1, 2, 15, 4, 5, 0, time.Local),
+		`2019/01/02 15:04:05.123456 main.go:42: Starting`:             time.Date(2019, 1, 2, 15, 4, 5, 123456000, time.Local),
+	} {
+		actual, ok := parseLogTime(line)
+		assert.True(t, ok, line)
+		assert.True(t, expected.Equal(actual), "%s: %s", line, actual)
+	}
+
+	_, ok := parseLogTime("goroutine 1 [running]:")
+	assert.False(t, ok)
+}
+
+func TestLogFilter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	f := &logFilter{w: buf, since: time.Date(2019, 1, 2, 15, 0, 0, 0, time.UTC)}
+	for _, line := range []string{
+		"panic: before\n",
+		`time="2019-01-02T14:59:59Z" msg="old"` + "\n",
+		"\tstack of old\n",
+		`time="2019-01-02T15:00:00Z" msg="new"` + "\n",
+		"\tstack of new\n",
+	} {
+		f.line(line)
+	}
+	assert.Equal(t, `time="2019-01-02T15:00:00Z" msg="new"`+"\n\tstack of new\n", buf.String())
+}
+
+func TestReadLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pmm-mysql-metrics-42002.log")
+	assert.Nil(t, ioutil.WriteFile(file, []byte("one\ntwo"), 0644))
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, readLog(context.Background(), file, &logFilter{w: buf, include: true}, false))
+	assert.Equal(t, "one\ntwo\n", buf.String())
+
+	// Follow the file through truncation by logrotate copytruncate.
+	logPollInterval = 10 * time.Millisecond
+	out := &syncBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- readLog(ctx, file, &logFilter{w: out, include: true}, true)
+	}()
+	waitForOutput(t, out, "one\n")
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	f.WriteString(" and a half\n")
+	waitForOutput(t, out, "one\ntwo and a half\n")
+	assert.Nil(t, f.Truncate(0))
+	f.WriteString("three\n")
+	f.Close()
+	waitForOutput(t, out, "one\ntwo and a half\nthree\n")
+	cancel()
+	assert.Nil(t, <-done)
+}
+
+type syncBuffer struct {
+	m sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.b.String()
+}
+
+func waitForOutput(t *testing.T, out *syncBuffer, expected string) {
+	for i := 0; i < 100 && out.String() != expected; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, expected, out.String())
+}
+
+func TestLogRotateConfig(t *testing.T) {
+	c := logRotateConfig(20, 3)
+	assert.Contains(t, c, LogsDir+"/pmm-*.log {\n")
+	assert.Contains(t, c, "    size 20M\n")
+	assert.Contains(t, c, "    rotate 3\n")
+	assert.Contains(t, c, "    copytruncate\n")
+}
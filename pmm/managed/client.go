@@ -68,12 +68,9 @@ type Client struct {
 	basePath string
 }
 
-func NewClient(host string, scheme string, user *url.Userinfo, insecureSSL bool, verbose bool) *Client {
-	transport := &http.Transport{}
-	if insecureSSL {
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+func NewClient(host string, scheme string, user *url.Userinfo, tlsConfig *tls.Config, verbose bool) *Client {
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
 	}
 	client := &http.Client{
 		Transport: transport,
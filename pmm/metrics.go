@@ -20,26 +20,36 @@ package pmm
 import (
 	"context"
 	"fmt"
+	"log"
 	"path/filepath"
 
 	consul "github.com/hashicorp/consul/api"
 	service "github.com/percona/kardianos-service"
 	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/percona/pmm-client/pmm/utils"
 )
 
 // AddMetrics add metrics service to monitoring.
+// Steps already completed are undone if adding fails.
 func (a *Admin) AddMetrics(ctx context.Context, m plugin.Metrics, force bool, disableSSL bool) (*plugin.Info, error) {
-	info, err := m.Init(ctx, a.Config.MySQLPassword)
+	var undo rollback
+	info, err := a.addMetrics(ctx, m, force, disableSSL, &undo)
+	if err != nil {
+		// Errors of plugins and APIs may include DSN or server URL.
+		return nil, utils.RedactError(undo.run(err))
+	}
+	return info, nil
+}
+
+// addMetrics adds metrics service recording undo function for every completed step.
+func (a *Admin) addMetrics(ctx context.Context, m plugin.Metrics, force bool, disableSSL bool, undo *rollback) (*plugin.Info, error) {
+	info, err := m.Init(ctx, a.pmmUserPassword(m.Name()))
 	if err != nil {
 		return nil, err
 	}
 
-	if info.PMMUserPassword != "" {
-		a.Config.MySQLPassword = info.PMMUserPassword
-		err := a.writeConfig()
-		if err != nil {
-			return nil, err
-		}
+	if err := a.storePMMUserPassword(m.Name(), info.PMMUserPassword); err != nil {
+		return nil, err
 	}
 
 	serviceType := fmt.Sprintf("%s:metrics", m.Name())
@@ -62,31 +72,33 @@ func (a *Admin) AddMetrics(ctx context.Context, m plugin.Metrics, force bool, di
 		return nil, err
 	}
 
-	// Choose port.
-	defaultPort := m.DefaultPort()
-	port, err := a.choosePort(a.ServicePort, defaultPort)
+	webFlags, err := metricsWebFlags(m, disableSSL)
 	if err != nil {
 		return nil, err
 	}
 
-	scheme := "scheme_https"
-	if disableSSL {
-		scheme = "scheme_http"
+	labels, err := a.serviceLabels()
+	if err != nil {
+		return nil, err
 	}
-	tags := []string{
-		fmt.Sprintf("alias_%s", a.ServiceName),
-		scheme,
+	if err := a.Resources.Validate(); err != nil {
+		return nil, err
 	}
-	if m.Cluster() != "" {
-		tags = append(tags, fmt.Sprintf("cluster_%s", m.Cluster()))
+
+	// Choose port.
+	defaultPort := m.DefaultPort()
+	port, err := a.choosePort(a.ServicePort, defaultPort)
+	if err != nil {
+		return nil, err
 	}
+	defer releasePort(port)
 
 	// Add service to Consul.
 	serviceID := fmt.Sprintf("%s-%d", serviceType, port)
 	srv := consul.AgentService{
 		ID:      serviceID,
 		Service: serviceType,
-		Tags:    tags,
+		Tags:    append(a.metricsTags(m, disableSSL), labelTags(labels)...),
 		Port:    port,
 	}
 	reg := consul.CatalogRegistration{
@@ -97,9 +109,16 @@ func (a *Admin) AddMetrics(ctx context.Context, m plugin.Metrics, force bool, di
 	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
 		return nil, err
 	}
+	undo.add(func() error {
+		return a.deregisterService(serviceID)
+	})
 
 	// Add info to Consul KV.
-	for i, v := range m.KV() {
+	undo.add(func() error {
+		_, err := a.consulAPI.KV().DeleteTree(fmt.Sprintf("%s/%s/", a.Config.ClientName, serviceID), nil)
+		return err
+	})
+	for i, v := range metricsKV(m, a.Resources) {
 		d := &consul.KVPair{
 			Key:   fmt.Sprintf("%s/%s/%s", a.Config.ClientName, serviceID, i),
 			Value: v,
@@ -109,49 +128,73 @@ func (a *Admin) AddMetrics(ctx context.Context, m plugin.Metrics, force bool, di
 			return nil, err
 		}
 	}
-
-	args := []string{
-		fmt.Sprintf("-web.listen-address=%s:%d", a.Config.BindAddress, port),
-		fmt.Sprintf("-web.auth-file=%s", ConfigFile),
+	undo.add(func() error {
+		removeOptions(serviceType, a.ServiceName)
+		return nil
+	})
+	if err := a.storeOptions(serviceType, serviceID); err != nil {
+		return nil, err
 	}
 
-	if !disableSSL {
-		// Check and generate certificate if needed.
-		if err := a.checkSSLCertificate(); err != nil {
+	// Exporters read credentials from AuthFile.
+	if webFlags.AuthFile != "" {
+		if _, err := a.ensureExporterAuth(); err != nil {
 			return nil, err
 		}
-		args = append(args,
-			fmt.Sprintf("-web.ssl-key-file=%s", SSLKeyFile),
-			fmt.Sprintf("-web.ssl-cert-file=%s", SSLCertFile),
-		)
-	}
-
-	// Add additional args passed by plugin.
-	args = append(args, m.Args()...)
-	// Add additional args passed to pmm-admin.
-	args = append(args, a.Args...)
-
-	_, executable := filepath.Split(m.Executable())
-	if executable == "" {
-		return nil, fmt.Errorf("%s: invalid executable name: %s", m.Name(), m.Executable())
 	}
 
 	// Install and start service via platform service manager.
-	svcConfig := &service.Config{
-		Name:        fmt.Sprintf("pmm-%s-metrics-%d", m.Name(), port),
-		DisplayName: fmt.Sprintf("PMM Prometheus %s on port %d", m.Executable(), port),
-		Description: fmt.Sprintf("PMM Prometheus %s on port %d", m.Executable(), port),
-		Executable:  filepath.Join(PMMBaseDir, executable),
-		Arguments:   args,
+	svcConfig, err := a.metricsServiceConfig(m, webFlags, port, disableSSL)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := applyCredentials(svcConfig, m)
+	if err != nil {
+		return nil, err
+	}
+	undo.add(func() error {
+		removeCredentials(svcConfig.Name)
+		return nil
+	})
+	readFiles := append(a.metricsReadFiles(webFlags, disableSSL), credentials...)
+	if err := applyResources(svcConfig, a.Resources, readFiles...); err != nil {
+		return nil, err
 	}
-	svcConfig.Environment = append(svcConfig.Environment, m.Environment()...)
+	undo.add(func() error {
+		removeResources(svcConfig.Name)
+		return nil
+	})
 	if err := installService(svcConfig); err != nil {
 		return nil, err
 	}
 
+	undo.add(func() error {
+		return uninstallService(svcConfig.Name)
+	})
+
+	// Exporter may exit right away, e.g. because of bad DSN or port conflict.
+	if check := a.exporterHealthCheck(); check != nil {
+		if err := check(fmt.Sprintf("%s:metrics", m.Name()), pluginUpMetric(m), svcConfig.Name, port, disableSSL); err != nil {
+			if a.HealthRollback {
+				return nil, err
+			}
+			log.Printf("WARNING: %s", err)
+		}
+	}
+
 	return info, nil
 }
 
+// deregisterService removes service of this node from Consul.
+func (a *Admin) deregisterService(serviceID string) error {
+	dereg := consul.CatalogDeregistration{
+		Node:      a.Config.ClientName,
+		ServiceID: serviceID,
+	}
+	_, err := a.consulAPI.Catalog().Deregister(&dereg, nil)
+	return err
+}
+
 // RemoveMetrics remove metrics service from monitoring.
 func (a *Admin) RemoveMetrics(name string) error {
 	serviceType := fmt.Sprintf("%s:metrics", name)
@@ -179,6 +222,7 @@ func (a *Admin) RemoveMetrics(name string) error {
 	if err != nil {
 		return err
 	}
+	removeOptions(serviceType, a.ServiceName)
 
 	// Stop and uninstall service.
 	serviceName := fmt.Sprintf("pmm-%s-metrics-%d", name, consulSvc.Port)
@@ -188,3 +232,202 @@ func (a *Admin) RemoveMetrics(name string) error {
 
 	return nil
 }
+
+// UpdateMetrics replaces options of existing metrics service keeping its port,
+// and restarts the exporter if it is running.
+func (a *Admin) UpdateMetrics(ctx context.Context, m plugin.Metrics, disableSSL bool) (*plugin.Info, error) {
+	info, err := a.updateMetrics(ctx, m, disableSSL)
+	return info, utils.RedactError(err)
+}
+
+// updateMetrics reinstalls metrics service with new options.
+func (a *Admin) updateMetrics(ctx context.Context, m plugin.Metrics, disableSSL bool) (*plugin.Info, error) {
+	info, err := m.Init(ctx, a.pmmUserPassword(m.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.storePMMUserPassword(m.Name(), info.PMMUserPassword); err != nil {
+		return nil, err
+	}
+
+	serviceType := fmt.Sprintf("%s:metrics", m.Name())
+
+	// Check if we have this service on Consul.
+	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	if consulSvc == nil {
+		return nil, ErrNoService
+	}
+
+	webFlags, err := metricsWebFlags(m, disableSSL)
+	if err != nil {
+		return nil, err
+	}
+	svcConfig, err := a.metricsServiceConfig(m, webFlags, consulSvc.Port, disableSSL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update service on Consul, the ID stays the same, labels are kept.
+	labels, _ := splitLabelTags(consulSvc.Tags)
+	consulSvc.Tags = append(a.metricsTags(m, disableSSL), labelTags(labels)...)
+	reg := consul.CatalogRegistration{
+		Node:    a.Config.ClientName,
+		Address: a.Config.ClientAddress,
+		Service: consulSvc,
+	}
+	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+		return nil, err
+	}
+
+	// Replace info in Consul KV, resource limits are kept.
+	prefix := fmt.Sprintf("%s/%s/", a.Config.ClientName, consulSvc.ID)
+	pairs, _, err := a.consulAPI.KV().List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	resources := resourcesFromKV(prefix, pairs)
+	if _, err := a.consulAPI.KV().DeleteTree(prefix, nil); err != nil {
+		return nil, err
+	}
+	for i, v := range metricsKV(m, resources) {
+		d := &consul.KVPair{
+			Key:   prefix + i,
+			Value: v,
+		}
+		if _, err := a.consulAPI.KV().Put(d, nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.storeOptions(serviceType, consulSvc.ID); err != nil {
+		return nil, err
+	}
+
+	// Reinstall service with the new arguments, leave it stopped if it was stopped.
+	running := getServiceStatus(svcConfig.Name)
+	if err := uninstallService(svcConfig.Name); err != nil {
+		return nil, err
+	}
+	credentials, err := applyCredentials(svcConfig, m)
+	if err != nil {
+		return nil, err
+	}
+	readFiles := append(a.metricsReadFiles(webFlags, disableSSL), credentials...)
+	if err := applyResources(svcConfig, resources, readFiles...); err != nil {
+		return nil, err
+	}
+	if err := installService(svcConfig); err != nil {
+		return nil, err
+	}
+	if !running {
+		if err := stopService(svcConfig.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// metricsWebFlags returns names of exporter web flags and checks exporter supports the requested mode.
+func metricsWebFlags(m plugin.Metrics, disableSSL bool) (plugin.WebFlags, error) {
+	webFlags := plugin.DefaultWebFlags
+	if w, ok := m.(plugin.WebConfigurer); ok {
+		webFlags = w.WebFlags()
+	}
+	if webFlags.ListenAddress == "" {
+		return webFlags, fmt.Errorf("%s: exporter doesn't accept listen address", m.Name())
+	}
+	if !disableSSL && !webFlags.SSL() {
+		return webFlags, fmt.Errorf("%s: exporter doesn't support SSL, use --disable-ssl", m.Name())
+	}
+	return webFlags, nil
+}
+
+// metricsKV returns info about metrics service stored in Consul KV.
+func metricsKV(m plugin.Metrics, r Resources) map[string][]byte {
+	kv := r.kv()
+	for k, v := range m.KV() {
+		kv[k] = v
+	}
+	return kv
+}
+
+// metricsReadFiles returns files exporter reads, they should be readable by the user it runs as.
+func (a *Admin) metricsReadFiles(webFlags plugin.WebFlags, disableSSL bool) []string {
+	var files []string
+	if webFlags.AuthFile != "" {
+		files = append(files, AuthFile)
+	}
+	if !disableSSL {
+		files = append(files, SSLKeyFile, SSLCertFile)
+	}
+	return files
+}
+
+// metricsTags returns Consul tags of metrics service.
+func (a *Admin) metricsTags(m plugin.Metrics, disableSSL bool) []string {
+	scheme := "scheme_https"
+	if disableSSL {
+		scheme = "scheme_http"
+	}
+	tags := []string{
+		fmt.Sprintf("alias_%s", a.ServiceName),
+		scheme,
+	}
+	if m.Cluster() != "" {
+		tags = append(tags, fmt.Sprintf("cluster_%s", m.Cluster()))
+	}
+	if a.Config.Push {
+		tags = append(tags, PushTag)
+	}
+	return tags
+}
+
+// metricsServiceConfig returns platform service config of exporter listening on the given port.
+func (a *Admin) metricsServiceConfig(m plugin.Metrics, webFlags plugin.WebFlags, port int, disableSSL bool) (*service.Config, error) {
+	args := []string{
+		fmt.Sprintf("%s=%s:%d", webFlags.ListenAddress, a.Config.BindAddress, port),
+	}
+	if webFlags.AuthFile != "" {
+		args = append(args, fmt.Sprintf("%s=%s", webFlags.AuthFile, AuthFile))
+	}
+
+	if !disableSSL {
+		// Check and generate certificate if needed.
+		if err := a.checkSSLCertificate(); err != nil {
+			return nil, err
+		}
+		args = append(args,
+			fmt.Sprintf("%s=%s", webFlags.SSLKeyFile, SSLKeyFile),
+			fmt.Sprintf("%s=%s", webFlags.SSLCertFile, SSLCertFile),
+		)
+	}
+
+	// Add additional args passed by plugin.
+	args = append(args, m.Args()...)
+	// Add additional args passed to pmm-admin.
+	args = append(args, a.Args...)
+
+	// Exporters shipped with PMM Client are located under PMMBaseDir.
+	executable := m.Executable()
+	if !filepath.IsAbs(executable) {
+		_, executable = filepath.Split(executable)
+		if executable == "" {
+			return nil, fmt.Errorf("%s: invalid executable name: %s", m.Name(), m.Executable())
+		}
+		executable = filepath.Join(PMMBaseDir, executable)
+	}
+
+	svcConfig := &service.Config{
+		Name:        fmt.Sprintf("pmm-%s-metrics-%d", m.Name(), port),
+		DisplayName: fmt.Sprintf("PMM Prometheus %s on port %d", filepath.Base(executable), port),
+		Description: fmt.Sprintf("PMM Prometheus %s on port %d", filepath.Base(executable), port),
+		Executable:  executable,
+		Arguments:   args,
+	}
+	// Environment of exporter is passed via credentials file, see applyCredentials.
+	return svcConfig, nil
+}
@@ -0,0 +1,125 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/percona/pmm-client/pmm/plugin/exporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsServiceConfig(t *testing.T) {
+	m := exporter.New(&exporter.Manifest{
+		Name:        "redis",
+		Binary:      "/usr/local/bin/redis_exporter",
+		DefaultPort: 42100,
+		Args:        []string{"-redis.alias=pmm"},
+		Env:         []string{"REDIS_PASSWORD=secret"},
+	}, "", "/usr/local/percona/pmm-client")
+	a := &Admin{
+		ServiceName: "db01",
+		Args:        []string{"-log.level=debug"},
+		Config:      &Config{BindAddress: "10.0.0.1"},
+	}
+
+	webFlags, err := metricsWebFlags(m, true)
+	assert.Nil(t, err)
+	assert.Equal(t, plugin.DefaultWebFlags, webFlags)
+	assert.Equal(t, []string{"alias_db01", "scheme_http"}, a.metricsTags(m, true))
+	assert.Equal(t, []string{"alias_db01", "scheme_https"}, a.metricsTags(m, false))
+
+	svcConfig, err := a.metricsServiceConfig(m, webFlags, 42105, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "pmm-redis-metrics-42105", svcConfig.Name)
+	assert.Equal(t, "/usr/local/bin/redis_exporter", svcConfig.Executable)
+	expected := []string{
+		"-web.listen-address=10.0.0.1:42105",
+		"-web.auth-file=" + AuthFile,
+		"-redis.alias=pmm",
+		"-log.level=debug",
+	}
+	assert.Equal(t, expected, svcConfig.Arguments)
+	// Environment is passed via credentials file, not service definition.
+	assert.Empty(t, svcConfig.Environment)
+}
+
+func TestUpdateMetrics(t *testing.T) {
+	a, _, services, teardown := setupRollbackTest(t)
+	defer teardown()
+	executable, err := os.Executable()
+	assert.Nil(t, err)
+	manifest := &exporter.Manifest{
+		Name:        "redis",
+		Binary:      executable,
+		DefaultPort: 42100,
+		DSN:         &exporter.DSN{Env: "REDIS_ADDR"},
+	}
+	ctx := context.Background()
+
+	a.Args = []string{"-log.level=debug"}
+	a.Options = map[string]string{"uri": "redis://:secret@localhost:6379", "disable-ssl": "true"}
+	_, err = a.AddMetrics(ctx, exporter.New(manifest, "redis://:secret@localhost:6379", ""), false, true)
+	assert.Nil(t, err)
+
+	flags, args, err := a.StoredOptions("redis:metrics")
+	assert.Nil(t, err)
+	assert.Equal(t, a.Options, flags)
+	assert.Equal(t, []string{"-log.level=debug"}, args)
+
+	// Secret flags are kept in local file readable by root only, not in Consul.
+	pair, _, err := a.consulAPI.KV().Get("node1/redis:metrics-42100/options", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"disable-ssl":"true"}`, string(pair.Value))
+	fi, err := os.Stat(optionsFile("redis:metrics", "db01"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode())
+
+	// Service keeps its port, stored options are replaced.
+	a.Args = nil
+	a.Options = map[string]string{"uri": "redis://:changed@localhost:6379", "disable-ssl": "true"}
+	_, err = a.UpdateMetrics(ctx, exporter.New(manifest, "redis://:changed@localhost:6379", ""), true)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"pmm-redis-metrics-42100": true}, services.installed)
+	flags, args, err = a.StoredOptions("redis:metrics")
+	assert.Nil(t, err)
+	assert.Equal(t, a.Options, flags)
+	assert.Empty(t, args)
+	env, err := ioutil.ReadFile(envFile("pmm-redis-metrics-42100"))
+	assert.Nil(t, err)
+	assert.Equal(t, "REDIS_ADDR=redis://:changed@localhost:6379\n", string(env))
+
+	// Options of service added by a previous version are unknown.
+	_, err = a.consulAPI.KV().Delete("node1/redis:metrics-42100/options", nil)
+	assert.Nil(t, err)
+	_, _, err = a.StoredOptions("redis:metrics")
+	assert.Equal(t, ErrNoOptions, err)
+
+	assert.Nil(t, a.RemoveMetrics("redis"))
+	assert.False(t, FileExists(optionsFile("redis:metrics", "db01")))
+
+	a.ServiceName = "db02"
+	_, err = a.UpdateMetrics(ctx, exporter.New(manifest, "redis://localhost:6379", ""), true)
+	assert.Equal(t, ErrNoService, err)
+	_, _, err = a.StoredOptions("redis:metrics")
+	assert.Equal(t, ErrNoService, err)
+}
@@ -0,0 +1,133 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// optionsKV is Consul KV key of flags service was added with, secret flags are kept in local options file.
+const optionsKV = "options"
+
+// storedOptions are flags and exporter arguments kept in local options file, readable by root only.
+type storedOptions struct {
+	Flags map[string]string `json:"flags,omitempty"`
+	Args  []string          `json:"args,omitempty"`
+}
+
+// isSecretOption returns true if flag may contain password, such flags are not stored in Consul.
+func isSecretOption(name string) bool {
+	return strings.Contains(name, "password") || name == "uri" || name == "dsn"
+}
+
+// optionsFile returns local options file of service of the type and name.
+func optionsFile(svcType, name string) string {
+	name = strings.Replace(fmt.Sprintf("%s-%s", svcType, name), ":", "-", -1)
+	return filepath.Join(CredentialsDir, name+".options")
+}
+
+// optionsKVKey returns Consul KV key of service options.
+func (a *Admin) optionsKVKey(svcType, serviceID string) string {
+	if strings.HasSuffix(svcType, ":queries") {
+		// Queries service is shared by instances, each has own KV.
+		return fmt.Sprintf("%s/%s/%s/%s", a.Config.ClientName, serviceID, a.ServiceName, optionsKV)
+	}
+	return fmt.Sprintf("%s/%s/%s", a.Config.ClientName, serviceID, optionsKV)
+}
+
+// storeOptions stores a.Options and a.Args of service, so they are the base for update.
+func (a *Admin) storeOptions(svcType, serviceID string) error {
+	public := map[string]string{}
+	local := storedOptions{Flags: map[string]string{}, Args: a.Args}
+	for name, value := range a.Options {
+		if isSecretOption(name) {
+			local.Flags[name] = value
+		} else {
+			public[name] = value
+		}
+	}
+
+	data, _ := json.Marshal(public)
+	d := &consul.KVPair{
+		Key:   a.optionsKVKey(svcType, serviceID),
+		Value: data,
+	}
+	if _, err := a.consulAPI.KV().Put(d, nil); err != nil {
+		return err
+	}
+
+	file := optionsFile(svcType, a.ServiceName)
+	if len(local.Flags) == 0 && len(local.Args) == 0 {
+		os.Remove(file)
+		return nil
+	}
+	data, _ = json.Marshal(local)
+	return writeCredentialsFile(file, data)
+}
+
+// StoredOptions returns flags and exporter arguments existing service was added or updated with.
+// It returns ErrNoOptions for services added by previous versions.
+func (a *Admin) StoredOptions(svcType string) (map[string]string, []string, error) {
+	consulSvc, err := a.getConsulService(svcType, a.ServiceName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if consulSvc == nil {
+		return nil, nil, ErrNoService
+	}
+
+	flags := map[string]string{}
+	data, _, err := a.consulAPI.KV().Get(a.optionsKVKey(svcType, consulSvc.ID), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, nil, ErrNoOptions
+	}
+	if err := json.Unmarshal(data.Value, &flags); err != nil {
+		return nil, nil, fmt.Errorf("invalid options of %s service: %s", svcType, err)
+	}
+
+	var local storedOptions
+	file := optionsFile(svcType, a.ServiceName)
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &local); err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", file, err)
+		}
+	}
+	for name, value := range local.Flags {
+		flags[name] = value
+	}
+	return flags, local.Args, nil
+}
+
+// removeOptions removes local options file of service.
+func removeOptions(svcType, name string) {
+	os.Remove(optionsFile(svcType, name))
+}
@@ -0,0 +1,286 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package exporter implements plugin.Metrics for exporters described by manifest files,
+// so pmm-admin can install and supervise exporters it doesn't know about.
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/percona/pmm-client/pmm/utils"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+var _ plugin.Metrics = (*Metrics)(nil)
+var _ plugin.WebConfigurer = (*Metrics)(nil)
+var _ plugin.UpReporter = (*Metrics)(nil)
+
+var nameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{1,30}$`)
+
+// Manifest describes exporter.
+//
+// Example of /usr/local/percona/pmm-client/exporters.d/redis.yml:
+//
+//	name: redis
+//	binary: /usr/local/bin/redis_exporter
+//	default_port: 42100
+//	multiple: true
+//	args: ["-redis.alias=pmm"]
+//	dsn:
+//	  env: REDIS_ADDR
+//	  default: redis://localhost:6379
+//	web:
+//	  listen_address: -web.listen-address
+//	up_metric: redis_up
+type Manifest struct {
+	// Name of the exporter, service type is <name>:metrics.
+	Name string `yaml:"name"`
+	// Binary is a path to exporter executable, absolute or relative to PMMBaseDir.
+	Binary string `yaml:"binary"`
+	// DefaultPort of the exporter.
+	DefaultPort int `yaml:"default_port"`
+	// Multiple is true if exporter can be added multiple times.
+	Multiple bool `yaml:"multiple"`
+	// Args is a list of arguments passed to exporter.
+	Args []string `yaml:"args,omitempty"`
+	// Env is a list of environment variables passed to exporter in the form KEY=value.
+	Env []string `yaml:"env,omitempty"`
+	// DSN describes how the connection string is passed to exporter, if at all.
+	DSN *DSN `yaml:"dsn,omitempty"`
+	// Web overrides names of exporter flags used by pmm-admin.
+	Web *plugin.WebFlags `yaml:"web,omitempty"`
+	// UpMetric is a name of the metric which is 1 while the database is reachable, if exporter has one.
+	UpMetric string `yaml:"up_metric,omitempty"`
+}
+
+// DSN describes how the connection string is passed to exporter.
+type DSN struct {
+	// Env is a name of environment variable to pass DSN in.
+	Env string `yaml:"env,omitempty"`
+	// Flag is a name of exporter flag to pass DSN in, e.g. -redis.addr. It is used only without Env.
+	// DSN in flag is visible in process list and service definition, so it is passed with --insecure-dsn-arg only.
+	Flag string `yaml:"flag,omitempty"`
+	// Default DSN used when --dsn is not given.
+	Default string `yaml:"default,omitempty"`
+}
+
+// ReadManifest reads and validates exporter manifest file.
+func ReadManifest(file string) (*Manifest, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := yaml.UnmarshalStrict(bytes, m); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	switch {
+	case !nameRegex.MatchString(m.Name):
+		err = fmt.Errorf("name must be 2 to 31 characters long, contain only lowercase letters, numbers and symbol _")
+	case m.Binary == "":
+		err = fmt.Errorf("binary is required")
+	case m.DefaultPort <= 0 || m.DefaultPort > 65535:
+		err = fmt.Errorf("default_port should be between 1 and 65535")
+	case m.DSN != nil && m.DSN.Env == "" && m.DSN.Flag == "":
+		err = fmt.Errorf("dsn requires either env or flag")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	for _, env := range m.Env {
+		if !strings.Contains(env, "=") {
+			return nil, fmt.Errorf("%s: env %q should be in the form KEY=value", file, env)
+		}
+	}
+	return m, nil
+}
+
+// LoadDir registers exporters described by *.yml files in the given directory.
+// Missing directory is not an error. Invalid manifests are skipped and reported in the returned error.
+func LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	var errs []string
+	for _, file := range files {
+		m, err := ReadManifest(file)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err := Register(m); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", file, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Register registers exporter described by manifest.
+func Register(m *Manifest) error {
+	svcType := fmt.Sprintf("%s:%s", m.Name, plugin.KindMetrics)
+	if _, ok := plugin.Lookup(svcType); ok {
+		return fmt.Errorf("service type %s is already registered", svcType)
+	}
+	plugin.Register(plugin.Registration{
+		Name:        m.Name,
+		Kind:        plugin.KindMetrics,
+		DefaultPort: m.DefaultPort,
+		Executable:  m.Binary,
+		Flags: func(fs *pflag.FlagSet) {
+			if m.DSN != nil {
+				fs.String("dsn", m.DSN.Default, fmt.Sprintf("%s connection DSN", m.Name))
+			}
+			if m.dsnInArgs() {
+				fs.Bool("insecure-dsn-arg", false, "pass DSN in exporter flag, it is visible in process list and service definition")
+			}
+			// Exporter without SSL support can't be added with SSL enabled.
+			fs.Bool("disable-ssl", !m.webFlags().SSL(), "disable ssl mode on exporter")
+		},
+		NewMetrics: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Metrics, error) {
+			dsn, _ := fs.GetString("dsn")
+			insecure, _ := fs.GetBool("insecure-dsn-arg")
+			if m.dsnInArgs() && dsn != "" && !insecure {
+				return nil, fmt.Errorf("%s exporter takes DSN in flag %s visible in process list and service definition, "+
+					"add it with --insecure-dsn-arg to allow that", m.Name, m.DSN.Flag)
+			}
+			return New(m, dsn, opts.PMMBaseDir), nil
+		},
+	})
+	return nil
+}
+
+// New returns *Metrics.
+func New(manifest *Manifest, dsn string, pmmBaseDir string) *Metrics {
+	return &Metrics{
+		manifest:   manifest,
+		dsn:        dsn,
+		pmmBaseDir: pmmBaseDir,
+	}
+}
+
+// Metrics implements plugin.Metrics.
+type Metrics struct {
+	manifest   *Manifest
+	dsn        string
+	pmmBaseDir string
+}
+
+// Init initializes plugin.
+func (m *Metrics) Init(ctx context.Context, pmmUserPassword string) (*plugin.Info, error) {
+	if _, err := os.Stat(m.Executable()); err != nil {
+		return nil, fmt.Errorf("cannot find %s exporter: %s", m.manifest.Name, err)
+	}
+	return &plugin.Info{
+		DSN: m.dsn,
+	}, nil
+}
+
+// Name of the exporter.
+func (m Metrics) Name() string {
+	return m.manifest.Name
+}
+
+// Args is a list of additional arguments passed to exporter executable.
+func (m Metrics) Args() []string {
+	args := append([]string{}, m.manifest.Args...)
+	if m.manifest.dsnInArgs() && m.dsn != "" {
+		args = append(args, fmt.Sprintf("%s=%s", m.manifest.DSN.Flag, m.dsn))
+	}
+	return args
+}
+
+// Environment is a list of additional environment variables passed to exporter executable.
+func (m Metrics) Environment() []string {
+	env := append([]string{}, m.manifest.Env...)
+	if m.manifest.DSN != nil && m.manifest.DSN.Env != "" && m.dsn != "" {
+		env = append(env, fmt.Sprintf("%s=%s", m.manifest.DSN.Env, m.dsn))
+	}
+	return env
+}
+
+// Executable is a path to exporter executable.
+func (m Metrics) Executable() string {
+	if filepath.IsAbs(m.manifest.Binary) {
+		return m.manifest.Binary
+	}
+	return filepath.Join(m.pmmBaseDir, m.manifest.Binary)
+}
+
+// KV is a list of additional Key-Value data stored in consul.
+func (m Metrics) KV() map[string][]byte {
+	if m.dsn == "" {
+		return nil
+	}
+	return map[string][]byte{
+		"dsn": []byte(utils.SanitizeDSN(m.dsn)),
+	}
+}
+
+// Cluster defines cluster name for the target.
+func (Metrics) Cluster() string {
+	return ""
+}
+
+// Multiple returns true if exporter can be added multiple times.
+func (m Metrics) Multiple() bool {
+	return m.manifest.Multiple
+}
+
+// DefaultPort returns default port.
+func (m Metrics) DefaultPort() int {
+	return m.manifest.DefaultPort
+}
+
+// WebFlags returns names of exporter flags used by pmm-admin.
+func (m Metrics) WebFlags() plugin.WebFlags {
+	return m.manifest.webFlags()
+}
+
+// UpMetric returns name of the metric which is 1 while the database is reachable, empty if not set.
+func (m Metrics) UpMetric() string {
+	return m.manifest.UpMetric
+}
+
+// dsnInArgs returns true if DSN is passed in exporter flag, i.e. manifest has no env for it.
+func (m *Manifest) dsnInArgs() bool {
+	return m.DSN != nil && m.DSN.Env == "" && m.DSN.Flag != ""
+}
+
+// webFlags returns web flags from manifest, plugin.DefaultWebFlags if not set.
+func (m *Manifest) webFlags() plugin.WebFlags {
+	if m.Web == nil {
+		return plugin.DefaultWebFlags
+	}
+	return *m.Web
+}
@@ -4,10 +4,30 @@ import (
 	"context"
 
 	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/spf13/pflag"
 )
 
 var _ plugin.Metrics = (*Metrics)(nil)
 
+func init() {
+	plugin.Register(plugin.Registration{
+		Name:        Metrics{}.Name(),
+		Kind:        plugin.KindMetrics,
+		DefaultPort: Metrics{}.DefaultPort(),
+		Executable:  Metrics{}.Executable(),
+		Flags:       AddFlags,
+		NewMetrics: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Metrics, error) {
+			return New(), nil
+		},
+	})
+}
+
+// AddFlags adds linux metrics specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	fs.Bool("force", false, "force to add another linux:metrics instance with different name for testing purposes")
+	fs.Bool("disable-ssl", true, "disable ssl mode on exporter")
+}
+
 // New returns *Metrics.
 func New() *Metrics {
 	return &Metrics{}
@@ -26,3 +26,44 @@ type Metrics interface {
 	// DefaultPort returns default port.
 	DefaultPort() int
 }
+
+// WebFlags are names of exporter flags used by pmm-admin to configure exporter web endpoint.
+// Empty name means exporter doesn't support the feature.
+type WebFlags struct {
+	ListenAddress string `yaml:"listen_address"`
+	AuthFile      string `yaml:"auth_file"`
+	SSLCertFile   string `yaml:"ssl_cert_file"`
+	SSLKeyFile    string `yaml:"ssl_key_file"`
+}
+
+// SSL returns true if exporter supports SSL.
+func (w WebFlags) SSL() bool {
+	return w.SSLCertFile != "" && w.SSLKeyFile != ""
+}
+
+// DefaultWebFlags are supported by all exporters shipped with PMM Client.
+var DefaultWebFlags = WebFlags{
+	ListenAddress: "-web.listen-address",
+	AuthFile:      "-web.auth-file",
+	SSLCertFile:   "-web.ssl-cert-file",
+	SSLKeyFile:    "-web.ssl-key-file",
+}
+
+// WebConfigurer can be implemented by Metrics to use other than DefaultWebFlags.
+type WebConfigurer interface {
+	WebFlags() WebFlags
+}
+
+// UpReporter can be implemented by Metrics whose exporter exposes a metric which is 1 while
+// the database is reachable, e.g. mysql_up. pmm-admin checks it after the exporter is installed.
+type UpReporter interface {
+	UpMetric() string
+}
+
+// CredentialsFiler can be implemented by Metrics whose exporter reads database credentials from a file.
+// Otherwise pmm-admin passes Environment() to exporter via a file readable by root only.
+type CredentialsFiler interface {
+	// CredentialsFile returns content of the credentials file at path, and args and environment
+	// passed to exporter instead of Environment(). It returns false if credentials can't be put to a file.
+	CredentialsFile(path string) (content []byte, args []string, env []string, ok bool)
+}
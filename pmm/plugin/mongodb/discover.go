@@ -0,0 +1,199 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Member is a MongoDB server found by Discover.
+type Member struct {
+	// Host is host:port of the member.
+	Host string
+	// Role is replica set member state (PRIMARY, SECONDARY, ...), "config" or "mongos".
+	Role string
+	// ReplSet is a name of the replica set the member belongs to, if any.
+	ReplSet string
+}
+
+// Topology is MongoDB deployment found by Discover.
+type Topology struct {
+	// Cluster is a replica set name, or config servers replica set name for sharded cluster.
+	Cluster string
+	Members []Member
+}
+
+type replSetStatus struct {
+	Set     string          `bson:"set"`
+	Members []replSetMember `bson:"members"`
+}
+
+type replSetMember struct {
+	Name     string `bson:"name"`
+	StateStr string `bson:"stateStr"`
+}
+
+type shard struct {
+	ID   string `bson:"_id"`
+	Host string `bson:"host"`
+}
+
+// Discover connects to MongoDB given by URI and returns all members of its deployment:
+// replica set members found by replSetGetStatus, or shards, config servers and mongos found by config.shards
+// when connected to mongos. A standalone server is returned as a single member without cluster.
+func Discover(ctx context.Context, uri string) (*Topology, error) {
+	info, err := mgo.ParseURL(uri)
+	if err != nil {
+		return nil, err
+	}
+	info.Direct = true
+	info.FailFast = true
+	info.Timeout = 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		info.Timeout = time.Until(deadline)
+	}
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to MongoDB: %s", err)
+	}
+	defer session.Close()
+	// Secondaries are queried directly.
+	session.SetMode(mgo.Monotonic, true)
+
+	isMaster := struct {
+		Msg     string `bson:"msg"`
+		SetName string `bson:"setName"`
+	}{}
+	if err := session.Run("isMaster", &isMaster); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case isMaster.Msg == "isdbgrid":
+		var shards []shard
+		if err := session.DB("config").C("shards").Find(nil).All(&shards); err != nil {
+			return nil, fmt.Errorf("cannot read config.shards: %s", err)
+		}
+		serverStatus := struct {
+			Sharding struct {
+				ConfigsvrConnectionString string `bson:"configsvrConnectionString"`
+			} `bson:"sharding"`
+		}{}
+		if err := session.Run(bson.D{{Name: "serverStatus", Value: 1}}, &serverStatus); err != nil {
+			return nil, err
+		}
+		return shardedTopology(shards, serverStatus.Sharding.ConfigsvrConnectionString, info.Addrs), nil
+
+	case isMaster.SetName != "":
+		status := replSetStatus{}
+		if err := session.Run("replSetGetStatus", &status); err != nil {
+			return nil, fmt.Errorf("cannot run replSetGetStatus: %s", err)
+		}
+		return &Topology{
+			Cluster: status.Set,
+			Members: replSetMembers(status),
+		}, nil
+
+	default:
+		return &Topology{
+			Members: []Member{{Host: info.Addrs[0], Role: "standalone"}},
+		}, nil
+	}
+}
+
+// replSetMembers returns members of the replica set.
+func replSetMembers(status replSetStatus) []Member {
+	members := make([]Member, 0, len(status.Members))
+	for _, m := range status.Members {
+		members = append(members, Member{Host: m.Name, Role: m.StateStr, ReplSet: status.Set})
+	}
+	return members
+}
+
+// shardedTopology returns members of sharded cluster: shards, config servers and the given mongos.
+func shardedTopology(shards []shard, configsvr string, mongos []string) *Topology {
+	sort.Slice(shards, func(i, j int) bool { return shards[i].ID < shards[j].ID })
+
+	t := &Topology{}
+	for _, s := range shards {
+		replSet, hosts := parseConnectionString(s.Host)
+		for _, host := range hosts {
+			t.Members = append(t.Members, Member{Host: host, Role: "shard " + s.ID, ReplSet: replSet})
+		}
+	}
+	replSet, hosts := parseConnectionString(configsvr)
+	for _, host := range hosts {
+		t.Members = append(t.Members, Member{Host: host, Role: "config", ReplSet: replSet})
+	}
+	for _, host := range mongos {
+		t.Members = append(t.Members, Member{Host: host, Role: "mongos"})
+	}
+	t.Cluster = replSet
+	return t
+}
+
+// parseConnectionString splits connection string like rs0/host1:27017,host2:27017
+// to replica set name and hosts.
+func parseConnectionString(s string) (string, []string) {
+	if s == "" {
+		return "", nil
+	}
+	var replSet string
+	if i := strings.Index(s, "/"); i >= 0 {
+		replSet, s = s[:i], s[i+1:]
+	}
+	return replSet, strings.Split(s, ",")
+}
+
+// MemberURI returns URI to connect directly to the given host with credentials and options of the given URI.
+func MemberURI(uri, host string) string {
+	uri = strings.TrimPrefix(uri, "mongodb://")
+
+	var userInfo string
+	if i := strings.LastIndex(uri, "@"); i >= 0 {
+		userInfo, uri = uri[:i+1], uri[i+1:]
+	}
+
+	var path, query string
+	if i := strings.IndexAny(uri, "/?"); i >= 0 {
+		path = uri[i:]
+	}
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+
+	var options []string
+	for _, option := range strings.Split(query, "&") {
+		// Replica set options make the driver connect to other members.
+		if option != "" && !strings.HasPrefix(option, "connect=") && !strings.HasPrefix(option, "replicaSet=") {
+			options = append(options, option)
+		}
+	}
+	options = append(options, "connect=direct")
+	if path == "" {
+		path = "/"
+	}
+	return "mongodb://" + userInfo + host + path + "?" + strings.Join(options, "&")
+}
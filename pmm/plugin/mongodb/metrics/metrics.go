@@ -7,9 +7,33 @@ import (
 	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/pmm/plugin/mongodb"
 	"github.com/percona/pmm-client/pmm/utils"
+	"github.com/spf13/pflag"
 )
 
 var _ plugin.Metrics = (*Metrics)(nil)
+var _ plugin.UpReporter = (*Metrics)(nil)
+
+func init() {
+	plugin.Register(plugin.Registration{
+		Name:        Metrics{}.Name(),
+		Kind:        plugin.KindMetrics,
+		DefaultPort: Metrics{}.DefaultPort(),
+		Executable:  Metrics{}.Executable(),
+		Flags: func(fs *pflag.FlagSet) {
+			mongodb.AddFlags(fs)
+			AddFlags(fs)
+		},
+		NewMetrics: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Metrics, error) {
+			cluster, _ := fs.GetString("cluster")
+			return New(mongodb.GetURI(fs), opts.Args, cluster, opts.PMMBaseDir), nil
+		},
+	})
+}
+
+// AddFlags adds MongoDB Metrics specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	fs.String("cluster", "", "cluster name")
+}
 
 // New returns *Metrics.
 func New(dsn string, args []string, cluster string, pmmBaseDir string) *Metrics {
@@ -49,6 +73,11 @@ func (Metrics) DefaultPort() int {
 	return 42003
 }
 
+// UpMetric returns name of the metric which is 1 while MongoDB is reachable.
+func (Metrics) UpMetric() string {
+	return "mongodb_up"
+}
+
 // Args is a list of additional arguments passed to exporter executable.
 func (Metrics) Args() []string {
 	return nil
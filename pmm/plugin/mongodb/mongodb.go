@@ -26,9 +26,22 @@ import (
 	"strings"
 
 	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/spf13/pflag"
 	"gopkg.in/mgo.v2"
 )
 
+// AddFlags adds MongoDB specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	fs.String("uri", "127.0.0.1:27017", "MongoDB URI, format: [mongodb://][user:pass@]host[:port][/database][?options]")
+	fs.Bool("disable-ssl", false, "disable ssl mode on exporter")
+}
+
+// GetURI returns MongoDB URI from the flag set populated by AddFlags.
+func GetURI(fs *pflag.FlagSet) string {
+	uri, _ := fs.GetString("uri")
+	return uri
+}
+
 // Init verifies MongoDB connection.
 func Init(ctx context.Context, uri string, args []string, pmmBaseDir string) (*plugin.Info, error) {
 	path := fmt.Sprintf("%s/mongodb_exporter", pmmBaseDir)
@@ -6,10 +6,25 @@ import (
 	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/pmm/plugin/mongodb"
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/spf13/pflag"
 )
 
 var _ plugin.Queries = (*Queries)(nil)
 
+func init() {
+	plugin.Register(plugin.Registration{
+		Name: Queries{}.Name(),
+		Kind: plugin.KindQueries,
+		Flags: func(fs *pflag.FlagSet) {
+			mongodb.AddFlags(fs)
+			plugin.AddQueriesFlags(fs)
+		},
+		NewQueries: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Queries, error) {
+			return New(plugin.GetQueriesFlags(fs), mongodb.GetURI(fs), opts.Args, opts.PMMBaseDir), nil
+		},
+	})
+}
+
 // New returns *Queries.
 func New(queriesFlags plugin.QueriesFlags, dsn string, args []string, pmmBaseDir string) *Queries {
 	return &Queries{
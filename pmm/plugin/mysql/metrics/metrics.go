@@ -4,14 +4,35 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net"
 	"strings"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/pmm/plugin/mysql"
 	"github.com/percona/pmm-client/pmm/utils"
+	"github.com/spf13/pflag"
 )
 
 var _ plugin.Metrics = (*Metrics)(nil)
+var _ plugin.UpReporter = (*Metrics)(nil)
+var _ plugin.CredentialsFiler = (*Metrics)(nil)
+
+func init() {
+	plugin.Register(plugin.Registration{
+		Name:        Metrics{}.Name(),
+		Kind:        plugin.KindMetrics,
+		DefaultPort: Metrics{}.DefaultPort(),
+		Executable:  Metrics{}.Executable(),
+		Flags: func(fs *pflag.FlagSet) {
+			mysql.AddFlags(fs)
+			AddFlags(fs)
+		},
+		NewMetrics: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Metrics, error) {
+			return New(GetFlags(fs), mysql.GetFlags(fs)), nil
+		},
+	})
+}
 
 // Flags are Metrics Metrics specific flags.
 type Flags struct {
@@ -20,6 +41,29 @@ type Flags struct {
 	DisableUserStats       bool
 	DisableBinlogStats     bool
 	DisableProcesslist     bool
+	Cluster                string
+}
+
+// AddFlags adds Metrics specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	fs.Bool("disable-tablestats", false, "disable table statistics")
+	fs.Uint16("disable-tablestats-limit", 1000, "number of tables after which table stats are disabled automatically")
+	fs.Bool("disable-userstats", false, "disable user statistics")
+	fs.Bool("disable-binlogstats", false, "disable binlog statistics")
+	fs.Bool("disable-processlist", false, "disable process state metrics")
+	fs.String("cluster", "", "cluster name, detected automatically for Galera nodes")
+}
+
+// GetFlags returns Metrics specific flags from the flag set populated by AddFlags.
+func GetFlags(fs *pflag.FlagSet) Flags {
+	flags := Flags{}
+	flags.DisableTableStats, _ = fs.GetBool("disable-tablestats")
+	flags.DisableTableStatsLimit, _ = fs.GetUint16("disable-tablestats-limit")
+	flags.DisableUserStats, _ = fs.GetBool("disable-userstats")
+	flags.DisableBinlogStats, _ = fs.GetBool("disable-binlogstats")
+	flags.DisableProcesslist, _ = fs.GetBool("disable-processlist")
+	flags.Cluster, _ = fs.GetString("cluster")
+	return flags
 }
 
 // New returns *Metrics.
@@ -37,6 +81,7 @@ type Metrics struct {
 
 	dsn           string
 	optsToDisable []string
+	galera        bool
 }
 
 // Init initializes plugin.
@@ -47,6 +92,12 @@ func (m *Metrics) Init(ctx context.Context, pmmUserPassword string) (*plugin.Inf
 	}
 	m.dsn = info.DSN
 
+	// Galera node is tagged with wsrep cluster name unless --cluster is given.
+	m.galera = info.Cluster != ""
+	if m.flags.Cluster == "" {
+		m.flags.Cluster = info.Cluster
+	}
+
 	m.optsToDisable, err = optsToDisable(ctx, m.dsn, m.flags)
 	if err != nil {
 		return nil, err
@@ -65,6 +116,11 @@ func (m Metrics) DefaultPort() int {
 	return 42002
 }
 
+// UpMetric returns name of the metric which is 1 while MySQL is reachable.
+func (Metrics) UpMetric() string {
+	return "mysql_up"
+}
+
 // Args is a list of additional arguments passed to exporter executable.
 func (m Metrics) Args() []string {
 	var defaultArgs = []string{
@@ -120,9 +176,33 @@ func (m Metrics) Args() []string {
 			}
 		}
 	}
+
+	// Galera metrics (wsrep status, provider options) are read from global status and variables,
+	// so these collectors are always enabled on Galera nodes.
+	if m.galera {
+		for _, f := range galeraArgs {
+			enabled := false
+			for i, a := range args {
+				if strings.HasPrefix(a, f) {
+					args[i] = fmt.Sprintf("%strue", f)
+					enabled = true
+					break
+				}
+			}
+			if !enabled {
+				args = append(args, fmt.Sprintf("%strue", f))
+			}
+		}
+	}
 	return args
 }
 
+// galeraArgs is a list of mysqld_exporter args of Galera collectors.
+var galeraArgs = []string{
+	"-collect.global_status=",
+	"-collect.global_variables=",
+}
+
 // Environment is a list of additional environment variables passed to exporter executable.
 func (m Metrics) Environment() []string {
 	return []string{
@@ -130,6 +210,39 @@ func (m Metrics) Environment() []string {
 	}
 }
 
+// CredentialsFile returns my.cnf file with credentials read by mysqld_exporter instead of DATA_SOURCE_NAME.
+// DSN with TLS or database can't be expressed in my.cnf, so it is passed in environment.
+// So are passwords with quotes or backslashes: option file parsers disagree on escaping them.
+func (m Metrics) CredentialsFile(path string) ([]byte, []string, []string, bool) {
+	cfg, err := mysqldriver.ParseDSN(m.dsn)
+	if err != nil || cfg.DBName != "" || cfg.TLSConfig != "" || strings.ContainsAny(cfg.Passwd, "\"\\\n") {
+		return nil, nil, nil, false
+	}
+	// Session parameters added by pmm-admin are not needed by exporter.
+	for k := range cfg.Params {
+		if k != "time_zone" {
+			return nil, nil, nil, false
+		}
+	}
+
+	lines := []string{
+		"[client]",
+		fmt.Sprintf("user = %s", cfg.User),
+		fmt.Sprintf("password = \"%s\"", cfg.Passwd),
+	}
+	if cfg.Net == "unix" {
+		lines = append(lines, fmt.Sprintf("socket = %s", cfg.Addr))
+	} else {
+		host, port, err := net.SplitHostPort(cfg.Addr)
+		if err != nil {
+			return nil, nil, nil, false
+		}
+		lines = append(lines, fmt.Sprintf("host = %s", host), fmt.Sprintf("port = %s", port))
+	}
+	content := []byte(strings.Join(lines, "\n") + "\n")
+	return content, []string{fmt.Sprintf("-config.my-cnf=%s", path)}, nil, true
+}
+
 // Executable is a name of exporter executable under PMMBaseDir.
 func (m Metrics) Executable() string {
 	return "mysqld_exporter"
@@ -147,7 +260,7 @@ func (m Metrics) KV() map[string][]byte {
 
 // Cluster defines cluster name for the target.
 func (m Metrics) Cluster() string {
-	return ""
+	return m.flags.Cluster
 }
 
 // Multiple returns true if exporter can be added multiple times.
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsFile(t *testing.T) {
+	m := Metrics{dsn: "pmm:pa;ss@tcp(db1:3307)/?parseTime=true&time_zone='%2b00%3a00'&loc=UTC"}
+	content, args, env, ok := m.CredentialsFile("/pmm/credentials/my.cnf")
+	assert.True(t, ok)
+	assert.Equal(t, "[client]\nuser = pmm\npassword = \"pa;ss\"\nhost = db1\nport = 3307\n", string(content))
+	assert.Equal(t, []string{"-config.my-cnf=/pmm/credentials/my.cnf"}, args)
+	assert.Empty(t, env)
+
+	m = Metrics{dsn: "pmm:pass@unix(/var/run/mysqld/mysqld.sock)/"}
+	content, _, _, ok = m.CredentialsFile("/pmm/credentials/my.cnf")
+	assert.True(t, ok)
+	assert.Contains(t, string(content), "socket = /var/run/mysqld/mysqld.sock\n")
+
+	for _, dsn := range []string{
+		"pmm:pass@tcp(db1:3306)/?tls=true",
+		"pmm:pass@tcp(db1:3306)/mysql",
+		`pmm:pa"ss@tcp(db1:3306)/`,
+		`pmm:a\nb@tcp(db1:3306)/`,
+		`pmm:x\\y@tcp(db1:3306)/`,
+	} {
+		m = Metrics{dsn: dsn}
+		_, _, _, ok = m.CredentialsFile("/pmm/credentials/my.cnf")
+		assert.False(t, ok, dsn)
+	}
+}
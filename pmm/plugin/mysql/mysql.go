@@ -12,6 +12,7 @@ import (
 	"github.com/percona/go-mysql/dsn"
 	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/pmm/utils"
+	"github.com/spf13/pflag"
 )
 
 // Flags are MySQL specific flags.
@@ -29,6 +30,42 @@ type Flags struct {
 	Force              bool
 }
 
+// AddFlags adds MySQL specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	AddConnectionFlags(fs)
+	fs.Bool("create-user", false, "create a new MySQL user")
+	fs.String("create-user-password", "", "optional password for a new MySQL user")
+	fs.Uint16("create-user-maxconn", 10, "max user connections for a new user")
+	fs.Bool("force", false, "force to create/update MySQL user")
+	fs.Bool("disable-ssl", false, "disable ssl mode on exporter")
+}
+
+// AddConnectionFlags adds flags of MySQL connection to the flag set.
+func AddConnectionFlags(fs *pflag.FlagSet) {
+	fs.String("defaults-file", "", "path to my.cnf")
+	fs.String("host", "", "MySQL host")
+	fs.String("port", "", "MySQL port")
+	fs.String("user", "", "MySQL username")
+	fs.String("password", "", "MySQL password")
+	fs.String("socket", "", "MySQL socket")
+}
+
+// GetFlags returns MySQL specific flags from the flag set populated by AddFlags.
+func GetFlags(fs *pflag.FlagSet) Flags {
+	flags := Flags{}
+	flags.DefaultsFile, _ = fs.GetString("defaults-file")
+	flags.Host, _ = fs.GetString("host")
+	flags.Port, _ = fs.GetString("port")
+	flags.User, _ = fs.GetString("user")
+	flags.Password, _ = fs.GetString("password")
+	flags.Socket, _ = fs.GetString("socket")
+	flags.CreateUser, _ = fs.GetBool("create-user")
+	flags.CreateUserPassword, _ = fs.GetString("create-user-password")
+	flags.MaxUserConn, _ = fs.GetUint16("create-user-maxconn")
+	flags.Force, _ = fs.GetBool("force")
+	return flags
+}
+
 // Init verifies MySQL connection and creates PMM user if requested.
 func Init(ctx context.Context, flags Flags, pmmUserPassword string) (*plugin.Info, error) {
 	// Check for invalid mix of flags.
@@ -97,7 +134,7 @@ func Init(ctx context.Context, flags Flags, pmmUserPassword string) (*plugin.Inf
 
 	// Create a new MySQL user.
 	if flags.CreateUser {
-		userDSN, err = createUser(ctx, db, userDSN, flags)
+		userDSN, err = createUser(ctx, db, userDSN, flags, info.Cluster != "")
 		if err != nil {
 			return nil, err
 		}
@@ -111,7 +148,96 @@ func Init(ctx context.Context, flags Flags, pmmUserPassword string) (*plugin.Inf
 	return info, nil
 }
 
-func createUser(ctx context.Context, db *sql.DB, userDSN dsn.DSN, flags Flags) (dsn.DSN, error) {
+// RotatePassword changes password of PMM user using connection described by flags, the connecting user
+// should be allowed to alter other users. New password is verified by connecting as PMM user.
+func RotatePassword(ctx context.Context, flags Flags, password string) error {
+	if flags.Socket != "" && flags.Host != "" {
+		return errors.New("flags --socket and --host are mutually exclusive")
+	}
+	userDSN := dsn.DSN{
+		DefaultsFile: flags.DefaultsFile,
+		Username:     flags.User,
+		Password:     flags.Password,
+		Hostname:     flags.Host,
+		Port:         flags.Port,
+		Socket:       flags.Socket,
+		Params:       []string{dsn.ParseTimeParam, dsn.TimezoneParam, dsn.LocationParam},
+	}
+	userDSN, err := userDSN.AutoDetect(ctx)
+	if err != nil && err != dsn.ErrNoSocket {
+		return fmt.Errorf("problem with MySQL auto-detection: %s", err)
+	}
+	if err := testConnection(ctx, userDSN.String()); err != nil {
+		return fmt.Errorf("Cannot connect to MySQL: %s\n\n%s", err,
+			"Use additional flags --user, --password, --host, --port, --socket if needed.")
+	}
+
+	db, err := sql.Open("mysql", userDSN.String())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	queries, err := passwordQueries(ctx, db, "pmm", password)
+	if err != nil {
+		return err
+	}
+	for _, query := range queries {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			// Query contains the new password, so it is not included.
+			return fmt.Errorf("Problem changing password of MySQL user pmm: %s\n\n%s",
+				err, "Verify that connecting MySQL user has CREATE USER privilege.")
+		}
+	}
+
+	// Verify PMM user can connect the same way as the connecting user.
+	userDSN.Username = "pmm"
+	userDSN.Password = password
+	if err := testConnection(ctx, userDSN.String()); err != nil {
+		return fmt.Errorf("Problem changing password of MySQL user pmm. Cannot connect with the new password: %s", err)
+	}
+	return nil
+}
+
+// passwordQueries returns queries changing password of the user for all its hosts.
+func passwordQueries(ctx context.Context, db *sql.DB, user, password string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT host FROM mysql.user WHERE user=?", user)
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("MySQL user %s doesn't exist, add services with --create-user flag to create it", user)
+	}
+
+	atLeastMySQL57, err := versionConstraint(ctx, db, ">= 5.7.0")
+	if err != nil {
+		return nil, err
+	}
+	var queries []string
+	for _, host := range hosts {
+		if atLeastMySQL57 {
+			queries = append(queries, fmt.Sprintf("ALTER USER '%s'@'%s' IDENTIFIED BY '%s'", user, host, password))
+		} else {
+			queries = append(queries, fmt.Sprintf("SET PASSWORD FOR '%s'@'%s' = PASSWORD('%s')", user, host, password))
+		}
+	}
+	return queries, nil
+}
+
+func createUser(ctx context.Context, db *sql.DB, userDSN dsn.DSN, flags Flags, galera bool) (dsn.DSN, error) {
 	// New DSN has same host:port or socket, but different user and pass.
 	userDSN.Username = "pmm"
 	if flags.CreateUserPassword != "" {
@@ -120,12 +246,7 @@ func createUser(ctx context.Context, db *sql.DB, userDSN dsn.DSN, flags Flags) (
 		userDSN.Password = utils.GeneratePassword(20)
 	}
 
-	hosts := []string{"%"}
-	if userDSN.Socket != "" || userDSN.Hostname == "localhost" {
-		hosts = []string{"localhost", "127.0.0.1"}
-	} else if userDSN.Hostname == "127.0.0.1" {
-		hosts = []string{"127.0.0.1"}
-	}
+	hosts := userHosts(userDSN, galera)
 
 	if !flags.Force {
 		if err := check(ctx, db, hosts); err != nil {
@@ -155,6 +276,21 @@ func createUser(ctx context.Context, db *sql.DB, userDSN dsn.DSN, flags Flags) (
 	return userDSN, nil
 }
 
+// userHosts returns hosts of a new MySQL user depending on how pmm-admin connects to MySQL.
+func userHosts(userDSN dsn.DSN, galera bool) []string {
+	switch {
+	case userDSN.Socket != "" || userDSN.Hostname == "localhost":
+		return []string{"localhost", "127.0.0.1"}
+	case userDSN.Hostname == "127.0.0.1" && galera:
+		// The user is replicated to all Galera nodes, their exporters may connect either way.
+		return []string{"localhost", "127.0.0.1"}
+	case userDSN.Hostname == "127.0.0.1":
+		return []string{"127.0.0.1"}
+	default:
+		return []string{"%"}
+	}
+}
+
 func check(ctx context.Context, db *sql.DB, hosts []string) error {
 	var (
 		errMsg []string
@@ -280,6 +416,19 @@ func getInfo(ctx context.Context, db *sql.DB) (*plugin.Info, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// Galera node (Percona XtraDB Cluster, MariaDB Galera Cluster) belongs to wsrep cluster.
+	rows, err := queryRows(ctx, db, "SHOW GLOBAL VARIABLES WHERE Variable_name IN ('wsrep_on', 'wsrep_cluster_name')")
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	for _, row := range rows {
+		vars[row["Variable_name"]] = row["Value"]
+	}
+	if strings.EqualFold(vars["wsrep_on"], "ON") {
+		info.Cluster = vars["wsrep_cluster_name"]
+	}
 	return info, nil
 }
 
@@ -220,6 +220,7 @@ func TestGetMysqlInfo(t *testing.T) {
 	columns := []string{"@@hostname", "@@port", "@@version_comment", "@@version"}
 	rows := sqlmock.NewRows(columns).AddRow("db01", "3306", "MySQL", "1.2.3")
 	mock.ExpectQuery("SELECT @@hostname, @@port, @@version_comment, @@version").WillReturnRows(rows)
+	mock.ExpectQuery("SHOW GLOBAL VARIABLES WHERE Variable_name IN").WillReturnRows(sqlmock.NewRows([]string{"Variable_name", "Value"}))
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -238,3 +239,85 @@ func TestGetMysqlInfo(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestGetMysqlInfoGalera(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening a stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	columns := []string{"@@hostname", "@@port", "@@version_comment", "@@version"}
+	rows := sqlmock.NewRows(columns).AddRow("pxc01", "3306", "Percona XtraDB Cluster (GPL), Release rel29, Revision 03540a3, WSREP version 31.37, wsrep_31.37", "5.7.25-28-57")
+	mock.ExpectQuery("SELECT @@hostname, @@port, @@version_comment, @@version").WillReturnRows(rows)
+	rows = sqlmock.NewRows([]string{"Variable_name", "Value"}).AddRow("wsrep_cluster_name", "pxc-prod").AddRow("wsrep_on", "ON")
+	mock.ExpectQuery("SHOW GLOBAL VARIABLES WHERE Variable_name IN").WillReturnRows(rows)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	info, err := getInfo(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, "pxc-prod", info.Cluster)
+
+	// Ensure all SQL queries were executed
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestUserHosts(t *testing.T) {
+	type sample struct {
+		dsn    dsn.DSN
+		galera bool
+		hosts  []string
+	}
+	samples := []sample{
+		{dsn: dsn.DSN{Socket: "/var/run/mysqld/mysqld.sock"}, hosts: []string{"localhost", "127.0.0.1"}},
+		{dsn: dsn.DSN{Hostname: "localhost"}, hosts: []string{"localhost", "127.0.0.1"}},
+		{dsn: dsn.DSN{Hostname: "127.0.0.1"}, hosts: []string{"127.0.0.1"}},
+		{dsn: dsn.DSN{Hostname: "127.0.0.1"}, galera: true, hosts: []string{"localhost", "127.0.0.1"}},
+		{dsn: dsn.DSN{Hostname: "10.0.0.1"}, hosts: []string{"%"}},
+		{dsn: dsn.DSN{Hostname: "10.0.0.1"}, galera: true, hosts: []string{"%"}},
+	}
+	for _, s := range samples {
+		assert.Equal(t, s.hosts, userHosts(s.dsn, s.galera), "%+v", s)
+	}
+}
+
+func TestPasswordQueries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error opening a stub database connection: %s", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	mock.ExpectQuery("SELECT host FROM mysql.user WHERE user=?").WithArgs("pmm").
+		WillReturnRows(sqlmock.NewRows([]string{"host"}).AddRow("localhost").AddRow("127.0.0.1"))
+	mock.ExpectQuery("SELECT @@GLOBAL.version").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("5.7.25-28"))
+	queries, err := passwordQueries(ctx, db, "pmm", "23;,_-asd")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"ALTER USER 'pmm'@'localhost' IDENTIFIED BY '23;,_-asd'",
+		"ALTER USER 'pmm'@'127.0.0.1' IDENTIFIED BY '23;,_-asd'",
+	}, queries)
+
+	mock.ExpectQuery("SELECT host FROM mysql.user WHERE user=?").WithArgs("pmm").
+		WillReturnRows(sqlmock.NewRows([]string{"host"}).AddRow("%"))
+	mock.ExpectQuery("SELECT @@GLOBAL.version").WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("5.6.40"))
+	queries, err = passwordQueries(ctx, db, "pmm", "abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SET PASSWORD FOR 'pmm'@'%' = PASSWORD('abc123')"}, queries)
+
+	mock.ExpectQuery("SELECT host FROM mysql.user WHERE user=?").WithArgs("pmm").
+		WillReturnRows(sqlmock.NewRows([]string{"host"}))
+	_, err = passwordQueries(ctx, db, "pmm", "abc123")
+	assert.Error(t, err)
+
+	// Ensure all SQL queries were executed
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -2,15 +2,35 @@ package queries
 
 import (
 	"context"
+	"errors"
 	"os"
 
 	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/pmm/plugin/mysql"
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/spf13/pflag"
 )
 
 var _ plugin.Queries = (*Queries)(nil)
 
+func init() {
+	plugin.Register(plugin.Registration{
+		Name: Queries{}.Name(),
+		Kind: plugin.KindQueries,
+		Flags: func(fs *pflag.FlagSet) {
+			mysql.AddFlags(fs)
+			AddFlags(fs)
+		},
+		NewQueries: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Queries, error) {
+			flags := GetFlags(fs)
+			if flags.QuerySource != "auto" && flags.QuerySource != "slowlog" && flags.QuerySource != "perfschema" {
+				return nil, errors.New("Flag --query-source can take the following values: auto, slowlog, perfschema.")
+			}
+			return New(plugin.GetQueriesFlags(fs), flags, mysql.GetFlags(fs)), nil
+		},
+	})
+}
+
 // Flags are MySQL Queries specific flags.
 type Flags struct {
 	QuerySource string
@@ -19,6 +39,23 @@ type Flags struct {
 	SlowLogRotation bool
 }
 
+// AddFlags adds MySQL Queries specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	plugin.AddQueriesFlags(fs)
+	fs.Bool("slow-log-rotation", true, "enable slow log rotation")
+	fs.Int("retain-slow-logs", 1, "number of slow logs to retain after rotation")
+	fs.String("query-source", "auto", "source of SQL queries: auto, slowlog, perfschema")
+}
+
+// GetFlags returns MySQL Queries specific flags from the flag set populated by AddFlags.
+func GetFlags(fs *pflag.FlagSet) Flags {
+	flags := Flags{}
+	flags.QuerySource, _ = fs.GetString("query-source")
+	flags.RetainSlowLogs, _ = fs.GetInt("retain-slow-logs")
+	flags.SlowLogRotation, _ = fs.GetBool("slow-log-rotation")
+	return flags
+}
+
 // New returns *Queries.
 func New(queriesFlags plugin.QueriesFlags, flags Flags, mysqlFlags mysql.Flags) *Queries {
 	return &Queries{
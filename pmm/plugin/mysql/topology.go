@@ -0,0 +1,230 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/percona/go-mysql/dsn"
+)
+
+// Member is a MySQL server found by DiscoverTopology.
+type Member struct {
+	Host string
+	Port string
+	// Source is an address of the replication source, empty if the member is not a replica.
+	Source string
+	// Group is true if the member is an online Group Replication member.
+	Group bool
+	// Err is set if the member is known from other members but can't be queried itself.
+	Err error
+}
+
+// Addr returns host:port of the member.
+func (m Member) Addr() string {
+	return net.JoinHostPort(m.Host, m.Port)
+}
+
+// Role returns human readable role of the member in the topology.
+func (m Member) Role() string {
+	switch {
+	case m.Group:
+		return "group member"
+	case m.Source != "":
+		return "replica of " + m.Source
+	default:
+		return "source"
+	}
+}
+
+// DiscoverTopology walks replication topology starting from the MySQL server given by flags.
+// Replication sources, replicas and Group Replication members are connected to
+// with the same credentials. Members are returned in the order they are found, starting with the given server.
+func DiscoverTopology(ctx context.Context, flags Flags) ([]Member, error) {
+	if flags.Socket != "" {
+		return nil, errors.New("flag --socket can't be used for topology discovery, members are connected to by address: use --host and --port")
+	}
+
+	seedDSN := dsn.DSN{
+		DefaultsFile: flags.DefaultsFile,
+		Username:     flags.User,
+		Password:     flags.Password,
+		Hostname:     flags.Host,
+		Port:         flags.Port,
+		Params:       []string{dsn.ParseTimeParam, dsn.TimezoneParam, dsn.LocationParam},
+	}
+	seedDSN, err := seedDSN.AutoDetect(ctx)
+	if err != nil && err != dsn.ErrNoSocket {
+		return nil, fmt.Errorf("problem with MySQL auto-detection: %s", err)
+	}
+	seedDSN.Socket = ""
+	seedDSN.Protocol = "tcp"
+	if seedDSN.Hostname == "" || seedDSN.Hostname == "localhost" {
+		seedDSN.Hostname = "127.0.0.1"
+	}
+	if seedDSN.Port == "" {
+		seedDSN.Port = "3306"
+	}
+
+	open := func(host, port string) (*sql.DB, error) {
+		memberDSN := seedDSN
+		memberDSN.Hostname = host
+		memberDSN.Port = port
+		return sql.Open("mysql", memberDSN.String())
+	}
+	return discoverTopology(ctx, seedDSN.Hostname, seedDSN.Port, open)
+}
+
+func discoverTopology(ctx context.Context, host, port string, open func(host, port string) (*sql.DB, error)) ([]Member, error) {
+	var members []Member
+	queue := []Member{{Host: host, Port: port}}
+	queued := map[string]bool{queue[0].Addr(): true}
+	seen := map[string]bool{}
+	enqueue := func(m Member) {
+		if m.Host == "" || m.Port == "" || m.Port == "0" || queued[m.Addr()] {
+			return
+		}
+		queued[m.Addr()] = true
+		queue = append(queue, m)
+	}
+
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+
+		uuid, neighbours, err := queryMember(ctx, &m, open)
+		if err != nil {
+			// The given server must be reachable, others are reported as is.
+			if len(members) == 0 {
+				return nil, fmt.Errorf("Cannot connect to MySQL: %s", err)
+			}
+			m.Err = err
+			members = append(members, m)
+			continue
+		}
+		// The same server may be reported under different addresses.
+		if seen[uuid] {
+			continue
+		}
+		seen[uuid] = true
+		members = append(members, m)
+		for _, n := range neighbours {
+			enqueue(n)
+		}
+	}
+	return members, nil
+}
+
+// queryMember fills replication details of the member and returns its server UUID and neighbour members.
+func queryMember(ctx context.Context, m *Member, open func(host, port string) (*sql.DB, error)) (string, []Member, error) {
+	db, err := open(m.Host, m.Port)
+	if err != nil {
+		return "", nil, err
+	}
+	defer db.Close()
+
+	var uuid string
+	if err := db.QueryRowContext(ctx, "SELECT @@server_uuid").Scan(&uuid); err != nil {
+		return "", nil, err
+	}
+
+	var neighbours []Member
+
+	// Replication sources, there are several of them for multi-source replication.
+	rows, err := queryRows(ctx, db, "SHOW SLAVE STATUS")
+	if err != nil {
+		return "", nil, err
+	}
+	var sources []string
+	for _, row := range rows {
+		source := Member{Host: row["Master_Host"], Port: row["Master_Port"]}
+		if source.Host != "" {
+			sources = append(sources, source.Addr())
+			neighbours = append(neighbours, source)
+		}
+	}
+	m.Source = strings.Join(sources, ", ")
+
+	// Replicas are listed only if they set report_host.
+	rows, err = queryRows(ctx, db, "SHOW SLAVE HOSTS")
+	if err != nil {
+		return "", nil, err
+	}
+	for _, row := range rows {
+		neighbours = append(neighbours, Member{Host: row["Host"], Port: row["Port"], Source: m.Addr()})
+	}
+
+	// Group Replication is not available before MySQL 5.7, so errors are ignored.
+	rows, _ = queryRows(ctx, db, "SELECT MEMBER_HOST, MEMBER_PORT FROM performance_schema.replication_group_members WHERE MEMBER_STATE = 'ONLINE'")
+	for _, row := range rows {
+		m.Group = true
+		neighbours = append(neighbours, Member{Host: row["MEMBER_HOST"], Port: row["MEMBER_PORT"], Group: true})
+	}
+
+	return uuid, neighbours, nil
+}
+
+// queryRows returns result of the query as a list of column name to value maps.
+func queryRows(ctx context.Context, db *sql.DB, query string) ([]map[string]string, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(columns))
+		for i, column := range columns {
+			row[column] = values[i].String
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// ClusterName returns default cluster name for the members: host of the topology root,
+// i.e. the first member which is not a replica.
+func ClusterName(members []Member) string {
+	for _, m := range members {
+		if m.Source == "" && m.Err == nil {
+			return m.Host
+		}
+	}
+	if len(members) > 0 {
+		return members[0].Host
+	}
+	return ""
+}
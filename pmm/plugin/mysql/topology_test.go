@@ -0,0 +1,144 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+)
+
+// fakeServer describes replication state of a fake MySQL server.
+type fakeServer struct {
+	uuid     string
+	sources  [][]string // Master_Host, Master_Port
+	replicas [][]string // Host, Port
+	group    [][]string // MEMBER_HOST, MEMBER_PORT
+}
+
+// fakeOpen returns open function for discoverTopology which connects to the mocked servers by address.
+// Unknown addresses can't be connected to.
+func fakeOpen(t *testing.T, servers map[string]fakeServer) func(host, port string) (*sql.DB, error) {
+	return func(host, port string) (*sql.DB, error) {
+		s, ok := servers[host+":"+port]
+		if !ok {
+			return nil, errors.New("dial tcp " + host + ":" + port + ": connection refused")
+		}
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening a stub database connection: %s", err)
+		}
+
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT @@server_uuid")).WillReturnRows(sqlmock.NewRows([]string{"@@server_uuid"}).AddRow(s.uuid))
+		rows := sqlmock.NewRows([]string{"Slave_IO_State", "Master_Host", "Master_Port"})
+		for _, r := range s.sources {
+			rows.AddRow("Waiting for master to send event", r[0], r[1])
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SHOW SLAVE STATUS")).WillReturnRows(rows)
+		rows = sqlmock.NewRows([]string{"Server_id", "Host", "Port", "Master_id"})
+		for i, r := range s.replicas {
+			rows.AddRow(i+2, r[0], r[1], 1)
+		}
+		mock.ExpectQuery(regexp.QuoteMeta("SHOW SLAVE HOSTS")).WillReturnRows(rows)
+		if s.group == nil {
+			mock.ExpectQuery("replication_group_members").WillReturnError(errors.New("Table 'performance_schema.replication_group_members' doesn't exist"))
+		} else {
+			rows = sqlmock.NewRows([]string{"MEMBER_HOST", "MEMBER_PORT"})
+			for _, r := range s.group {
+				rows.AddRow(r[0], r[1])
+			}
+			mock.ExpectQuery("replication_group_members").WillReturnRows(rows)
+		}
+		mock.ExpectClose()
+		return db, nil
+	}
+}
+
+func TestDiscoverTopology(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.Run("Replication", func(t *testing.T) {
+		servers := map[string]fakeServer{
+			"db1:3306": {
+				uuid:     "uuid-1",
+				replicas: [][]string{{"db2", "3306"}, {"db3", "3306"}, {"db4", "3306"}},
+			},
+			"db2:3306": {
+				uuid:    "uuid-2",
+				sources: [][]string{{"db1", "3306"}},
+			},
+			// db2 is a seed reached by IP, the same server is reported as db2 by the source.
+			"10.0.0.2:3306": {
+				uuid:    "uuid-2",
+				sources: [][]string{{"db1", "3306"}},
+			},
+			"db3:3306": {
+				uuid:     "uuid-3",
+				sources:  [][]string{{"db1", "3306"}},
+				replicas: [][]string{{"", "3306"}},
+			},
+		}
+
+		members, err := discoverTopology(ctx, "10.0.0.2", "3306", fakeOpen(t, servers))
+		assert.NoError(t, err)
+		if !assert.Len(t, members, 4) {
+			return
+		}
+		assert.Equal(t, Member{Host: "10.0.0.2", Port: "3306", Source: "db1:3306"}, members[0])
+		assert.Equal(t, Member{Host: "db1", Port: "3306"}, members[1])
+		assert.Equal(t, Member{Host: "db3", Port: "3306", Source: "db1:3306"}, members[2])
+		assert.Equal(t, "db4:3306", members[3].Addr())
+		assert.Error(t, members[3].Err)
+
+		assert.Equal(t, "replica of db1:3306", members[0].Role())
+		assert.Equal(t, "source", members[1].Role())
+		assert.Equal(t, "db1", ClusterName(members))
+	})
+
+	t.Run("GroupReplication", func(t *testing.T) {
+		group := [][]string{{"gr1", "3306"}, {"gr2", "3306"}, {"gr3", "3306"}}
+		servers := map[string]fakeServer{
+			"gr1:3306": {uuid: "uuid-1", group: group},
+			"gr2:3306": {uuid: "uuid-2", group: group},
+			"gr3:3306": {uuid: "uuid-3", group: group},
+		}
+
+		members, err := discoverTopology(ctx, "gr2", "3306", fakeOpen(t, servers))
+		assert.NoError(t, err)
+		expected := []Member{
+			{Host: "gr2", Port: "3306", Group: true},
+			{Host: "gr1", Port: "3306", Group: true},
+			{Host: "gr3", Port: "3306", Group: true},
+		}
+		assert.Equal(t, expected, members)
+		assert.Equal(t, "group member", members[0].Role())
+		assert.Equal(t, "gr2", ClusterName(members))
+	})
+
+	t.Run("SeedUnreachable", func(t *testing.T) {
+		_, err := discoverTopology(ctx, "db1", "3306", fakeOpen(t, nil))
+		assert.Error(t, err)
+	})
+}
@@ -7,9 +7,24 @@ import (
 	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/pmm/plugin/postgresql"
 	"github.com/percona/pmm-client/pmm/utils"
+	"github.com/spf13/pflag"
 )
 
 var _ plugin.Metrics = (*Metrics)(nil)
+var _ plugin.UpReporter = (*Metrics)(nil)
+
+func init() {
+	plugin.Register(plugin.Registration{
+		Name:        Metrics{}.Name(),
+		Kind:        plugin.KindMetrics,
+		DefaultPort: Metrics{}.DefaultPort(),
+		Executable:  Metrics{}.Executable(),
+		Flags:       postgresql.AddFlags,
+		NewMetrics: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Metrics, error) {
+			return New(postgresql.GetFlags(fs)), nil
+		},
+	})
+}
 
 // New returns *Metrics.
 func New(flags postgresql.Flags) *Metrics {
@@ -48,6 +63,11 @@ func (m Metrics) DefaultPort() int {
 	return 42005
 }
 
+// UpMetric returns name of the metric which is 1 while PostgreSQL is reachable.
+func (Metrics) UpMetric() string {
+	return "pg_up"
+}
+
 // Args is a list of additional arguments passed to exporter executable.
 func (Metrics) Args() []string {
 	return nil
@@ -13,6 +13,7 @@ import (
 
 	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/pmm/utils"
+	"github.com/spf13/pflag"
 )
 
 // Flags are PostgreSQL specific flags.
@@ -21,6 +22,43 @@ type Flags struct {
 	CreateUser         bool
 	CreateUserPassword string
 	Force              bool
+
+	// StatStatements is true if pg_stat_statements extension is required, it is checked over
+	// the connecting user before switching to PMM user. CreateExtension creates it if missing.
+	StatStatements  bool
+	CreateExtension bool
+}
+
+// AddFlags adds PostgreSQL specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	AddConnectionFlags(fs)
+	fs.Bool("create-user", false, "create a new PostgreSQL user")
+	fs.String("create-user-password", "", "optional password for a new PostgreSQL user")
+	fs.Bool("force", false, "force to create/update PostgreSQL user")
+	fs.Bool("disable-ssl", false, "disable ssl mode on exporter")
+}
+
+// AddConnectionFlags adds flags of PostgreSQL connection to the flag set.
+func AddConnectionFlags(fs *pflag.FlagSet) {
+	fs.String("host", "", "PostgreSQL host")
+	fs.String("port", "", "PostgreSQL port")
+	fs.String("user", "", "PostgreSQL username")
+	fs.String("password", "", "PostgreSQL password")
+	fs.String("sslmode", "disable", "PostgreSQL SSL Mode: disable, require, verify-full or verify-ca")
+}
+
+// GetFlags returns PostgreSQL specific flags from the flag set populated by AddFlags.
+func GetFlags(fs *pflag.FlagSet) Flags {
+	flags := Flags{}
+	flags.Host, _ = fs.GetString("host")
+	flags.Port, _ = fs.GetString("port")
+	flags.User, _ = fs.GetString("user")
+	flags.Password, _ = fs.GetString("password")
+	flags.SSLMode, _ = fs.GetString("sslmode")
+	flags.CreateUser, _ = fs.GetBool("create-user")
+	flags.CreateUserPassword, _ = fs.GetString("create-user-password")
+	flags.Force, _ = fs.GetBool("force")
+	return flags
 }
 
 // DSN represents PostgreSQL data source name.
@@ -110,6 +148,7 @@ func Init(ctx context.Context, flags Flags, pmmUserPassword string) (*plugin.Inf
 	}
 
 	// If the above fails, try to create `pmm` user with `sudo -u postgres psql`.
+	viaSudo := false
 	if !accessOK {
 		// If PostgreSQL server is local and --create-user flag is specified
 		// then try to create user using `sudo -u postgres psql` and use that connection.
@@ -124,6 +163,7 @@ func Init(ctx context.Context, flags Flags, pmmUserPassword string) (*plugin.Inf
 				} else {
 					userDSN = pmmDSN
 					accessOK = true
+					viaSudo = true
 				}
 			}
 		}
@@ -150,6 +190,19 @@ func Init(ctx context.Context, flags Flags, pmmUserPassword string) (*plugin.Inf
 		return nil, err
 	}
 
+	// Check pg_stat_statements before switching to PMM user: it can't create extensions,
+	// and the extension would be created in its schema.
+	if flags.StatStatements {
+		if viaSudo {
+			err = checkStatStatementsUsingSudoPSQL(ctx, flags.CreateExtension)
+		} else {
+			err = checkStatStatements(ctx, db, flags.CreateExtension)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Create a new PostgreSQL user.
 	if userDSN.User != "pmm" && flags.CreateUser {
 		userDSN, err = createUser(ctx, db, userDSN, flags)
@@ -166,6 +219,47 @@ func Init(ctx context.Context, flags Flags, pmmUserPassword string) (*plugin.Inf
 	return info, nil
 }
 
+// RotatePassword changes password of PMM user using connection described by flags, the connecting user
+// should be allowed to alter other users. For local server `sudo -u postgres psql` is used if connection fails.
+// New password is verified by connecting as PMM user.
+func RotatePassword(ctx context.Context, flags Flags, password string) error {
+	userDSN := flags.DSN
+	query := passwordQuery("pmm", password)
+	if err := testConnection(ctx, userDSN.String()); err == nil {
+		db, err := sql.Open("postgres", userDSN.String())
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			// Query contains the new password, so it is not included.
+			return fmt.Errorf("Problem changing password of PostgreSQL user pmm: %s", err)
+		}
+	} else if userDSN.Host == "" {
+		cmd := exec.CommandContext(ctx, "sudo", "-u", "postgres", "psql", "postgres", "-tAc", query)
+		if b, err := cmd.CombinedOutput(); err != nil {
+			output := strings.Replace(string(b), password, "***", -1)
+			return fmt.Errorf("Problem changing password of PostgreSQL user pmm: %s: %s", err, output)
+		}
+	} else {
+		return fmt.Errorf("Cannot connect to PostgreSQL: %s\n\n%s", err,
+			"Use additional flags --user, --password, --host, --port if needed.")
+	}
+
+	// Verify PMM user can connect the same way as the connecting user.
+	userDSN.User = "pmm"
+	userDSN.Password = password
+	if err := testConnection(ctx, userDSN.String()); err != nil {
+		return fmt.Errorf("Problem changing password of PostgreSQL user pmm. Cannot connect with the new password: %s", err)
+	}
+	return nil
+}
+
+// passwordQuery returns query changing password of the user.
+func passwordQuery(user, password string) string {
+	return fmt.Sprintf("ALTER USER %s WITH PASSWORD '%s'", pq.QuoteIdentifier(user), password)
+}
+
 func createUserUsingSudoPSQL(ctx context.Context, userDSN DSN, flags Flags) (DSN, error) {
 	// New DSN has same host:port or socket, but different user and pass.
 	userDSN.User = "pmm"
@@ -271,7 +365,7 @@ func makeGrants(dsn DSN, userExists bool, schemaExists bool) []string {
 
 	query := ""
 	if userExists {
-		query = fmt.Sprintf("ALTER USER %s WITH PASSWORD '%s'", quotedUser, dsn.Password)
+		query = passwordQuery(dsn.User, dsn.Password)
 	} else {
 		query = fmt.Sprintf("CREATE USER %s WITH PASSWORD '%s'", quotedUser, dsn.Password)
 	}
@@ -282,12 +376,16 @@ func makeGrants(dsn DSN, userExists bool, schemaExists bool) []string {
 		grants = append(grants, query)
 	}
 
+	// pg_catalog goes before public, so objects created in public can't shadow built-in functions and operators.
 	grants = append(grants,
-		fmt.Sprintf("ALTER USER %s SET SEARCH_PATH TO %s,pg_catalog", quotedUser, quotedUser),
+		fmt.Sprintf("ALTER USER %s SET SEARCH_PATH TO %s,pg_catalog,public", quotedUser, quotedUser),
 		fmt.Sprintf("CREATE OR REPLACE VIEW %s.pg_stat_activity AS SELECT * from pg_catalog.pg_stat_activity", quotedUser),
 		fmt.Sprintf("GRANT SELECT ON %s.pg_stat_activity TO %s", quotedUser, quotedUser),
 		fmt.Sprintf("CREATE OR REPLACE VIEW %s.pg_stat_replication AS SELECT * from pg_catalog.pg_stat_replication", quotedUser),
 		fmt.Sprintf("GRANT SELECT ON %s.pg_stat_replication TO %s", quotedUser, quotedUser),
+		// Query texts of other users in pg_stat_statements are visible with pg_read_all_stats role, PostgreSQL 10+.
+		fmt.Sprintf("DO $$BEGIN IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'pg_read_all_stats') "+
+			"THEN GRANT pg_read_all_stats TO %s; END IF; END$$", quotedUser),
 	)
 	return grants
 }
@@ -376,6 +474,65 @@ func testConnection(ctx context.Context, dsn string) error {
 	return nil
 }
 
+// Queries of pg_stat_statements checks. The extension is created in public schema which is in search path of PMM user.
+const (
+	statStatementsExtensionQuery = "SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements'"
+	createStatStatementsQuery    = "CREATE EXTENSION IF NOT EXISTS pg_stat_statements SCHEMA public"
+	statStatementsViewQuery      = "SELECT 1 FROM pg_stat_statements LIMIT 1"
+	statStatementsMissing        = "pg_stat_statements extension is not installed.\n\n" +
+		"Run 'CREATE EXTENSION pg_stat_statements' in postgres database or use --create-extension flag."
+	statStatementsUnusable = "pg_stat_statements is not usable: %s\n\n" +
+		"Add pg_stat_statements to shared_preload_libraries in postgresql.conf and restart PostgreSQL."
+)
+
+func checkStatStatements(ctx context.Context, db *sql.DB, create bool) error {
+	count := 0
+	err := db.QueryRowContext(ctx, statStatementsExtensionQuery).Scan(&count)
+	switch {
+	case err == sql.ErrNoRows && create:
+		if _, err := db.ExecContext(ctx, createStatStatementsQuery); err != nil {
+			return fmt.Errorf("cannot create pg_stat_statements extension: %s", err)
+		}
+	case err == sql.ErrNoRows:
+		return errors.New(statStatementsMissing)
+	case err != nil:
+		return err
+	}
+
+	// The view is not usable unless the library is preloaded.
+	if _, err := db.ExecContext(ctx, statStatementsViewQuery); err != nil {
+		return fmt.Errorf(statStatementsUnusable, err)
+	}
+	return nil
+}
+
+// checkStatStatementsUsingSudoPSQL is checkStatStatements for local server accessed with `sudo -u postgres psql`.
+func checkStatStatementsUsingSudoPSQL(ctx context.Context, create bool) error {
+	psql := func(query string) ([]byte, error) {
+		b, err := exec.CommandContext(ctx, "sudo", "-u", "postgres", "psql", "postgres", "-tAc", query).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(string(b)))
+		}
+		return b, nil
+	}
+	b, err := psql(statStatementsExtensionQuery)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(b, []byte("1")) {
+		if !create {
+			return errors.New(statStatementsMissing)
+		}
+		if _, err := psql(createStatStatementsQuery); err != nil {
+			return fmt.Errorf("cannot create pg_stat_statements extension: %s", err)
+		}
+	}
+	if _, err := psql(statStatementsViewQuery); err != nil {
+		return fmt.Errorf(statStatementsUnusable, err)
+	}
+	return nil
+}
+
 func getInfo(ctx context.Context, db *sql.DB) (*plugin.Info, error) {
 	info := &plugin.Info{}
 	err := db.QueryRowContext(ctx, "SELECT inet_server_addr(), inet_server_port(), version()").Scan(&info.Hostname, &info.Port, &info.Version)
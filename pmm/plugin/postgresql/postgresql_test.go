@@ -19,6 +19,8 @@ package postgresql
 
 import (
 	"context"
+	"errors"
+	"regexp"
 	"testing"
 	"time"
 
@@ -38,11 +40,12 @@ func TestMakeGrants(t *testing.T) {
 			grants: []string{
 				"CREATE USER \"pmm\" WITH PASSWORD 'abc123'",
 				"CREATE SCHEMA \"pmm\" AUTHORIZATION \"pmm\"",
-				"ALTER USER \"pmm\" SET SEARCH_PATH TO \"pmm\",pg_catalog",
+				"ALTER USER \"pmm\" SET SEARCH_PATH TO \"pmm\",pg_catalog,public",
 				"CREATE OR REPLACE VIEW \"pmm\".pg_stat_activity AS SELECT * from pg_catalog.pg_stat_activity",
 				"GRANT SELECT ON \"pmm\".pg_stat_activity TO \"pmm\"",
 				"CREATE OR REPLACE VIEW \"pmm\".pg_stat_replication AS SELECT * from pg_catalog.pg_stat_replication",
 				"GRANT SELECT ON \"pmm\".pg_stat_replication TO \"pmm\"",
+				"DO $$BEGIN IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'pg_read_all_stats') THEN GRANT pg_read_all_stats TO \"pmm\"; END IF; END$$",
 			},
 		},
 		{
@@ -50,11 +53,12 @@ func TestMakeGrants(t *testing.T) {
 			grants: []string{
 				"CREATE USER \"admin\" WITH PASSWORD '23;,_-asd'",
 				"CREATE SCHEMA \"admin\" AUTHORIZATION \"admin\"",
-				"ALTER USER \"admin\" SET SEARCH_PATH TO \"admin\",pg_catalog",
+				"ALTER USER \"admin\" SET SEARCH_PATH TO \"admin\",pg_catalog,public",
 				"CREATE OR REPLACE VIEW \"admin\".pg_stat_activity AS SELECT * from pg_catalog.pg_stat_activity",
 				"GRANT SELECT ON \"admin\".pg_stat_activity TO \"admin\"",
 				"CREATE OR REPLACE VIEW \"admin\".pg_stat_replication AS SELECT * from pg_catalog.pg_stat_replication",
 				"GRANT SELECT ON \"admin\".pg_stat_replication TO \"admin\"",
+				"DO $$BEGIN IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'pg_read_all_stats') THEN GRANT pg_read_all_stats TO \"admin\"; END IF; END$$",
 			},
 		},
 	}
@@ -64,6 +68,12 @@ func TestMakeGrants(t *testing.T) {
 	}
 }
 
+func TestPasswordQuery(t *testing.T) {
+	assert.Equal(t, "ALTER USER \"pmm\" WITH PASSWORD '23;,_-asd'", passwordQuery("pmm", "23;,_-asd"))
+	grants := makeGrants(DSN{User: "pmm", Password: "abc123"}, true, true)
+	assert.Equal(t, "ALTER USER \"pmm\" WITH PASSWORD 'abc123'", grants[0])
+}
+
 func TestGetInfo(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -92,3 +102,74 @@ func TestGetInfo(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestCheckStatStatements(t *testing.T) {
+	const (
+		extensionQuery = `SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements'`
+		createQuery    = `CREATE EXTENSION IF NOT EXISTS pg_stat_statements SCHEMA public`
+		viewQuery      = `SELECT 1 FROM pg_stat_statements LIMIT 1`
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	t.Run("Installed", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening a stub database connection: %s", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(extensionQuery)).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(viewQuery)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, checkStatStatements(ctx, db, false))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening a stub database connection: %s", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(extensionQuery)).WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+
+		err = checkStatStatements(ctx, db, false)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "--create-extension")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening a stub database connection: %s", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(extensionQuery)).WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+		mock.ExpectExec(regexp.QuoteMeta(createQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(viewQuery)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, checkStatStatements(ctx, db, true))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("NotPreloaded", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("error opening a stub database connection: %s", err)
+		}
+		defer db.Close()
+
+		mock.ExpectQuery(regexp.QuoteMeta(extensionQuery)).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+		mock.ExpectExec(regexp.QuoteMeta(viewQuery)).WillReturnError(errors.New(`pg_stat_statements must be loaded via shared_preload_libraries`))
+
+		err = checkStatStatements(ctx, db, false)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "shared_preload_libraries")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,95 @@
+package queries
+
+import (
+	"context"
+
+	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/percona/pmm-client/pmm/plugin/postgresql"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/spf13/pflag"
+)
+
+var _ plugin.Queries = (*Queries)(nil)
+
+func init() {
+	plugin.Register(plugin.Registration{
+		Name: Queries{}.Name(),
+		Kind: plugin.KindQueries,
+		Flags: func(fs *pflag.FlagSet) {
+			postgresql.AddFlags(fs)
+			AddFlags(fs)
+		},
+		NewQueries: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Queries, error) {
+			return New(plugin.GetQueriesFlags(fs), GetFlags(fs), postgresql.GetFlags(fs)), nil
+		},
+	})
+}
+
+// Flags are PostgreSQL Queries specific flags.
+type Flags struct {
+	CreateExtension bool
+}
+
+// AddFlags adds PostgreSQL Queries specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	plugin.AddQueriesFlags(fs)
+	fs.Bool("create-extension", false, "create pg_stat_statements extension if it is missing")
+}
+
+// GetFlags returns PostgreSQL Queries specific flags from the flag set populated by AddFlags.
+func GetFlags(fs *pflag.FlagSet) Flags {
+	flags := Flags{}
+	flags.CreateExtension, _ = fs.GetBool("create-extension")
+	return flags
+}
+
+// New returns *Queries.
+func New(queriesFlags plugin.QueriesFlags, flags Flags, postgresqlFlags postgresql.Flags) *Queries {
+	return &Queries{
+		queriesFlags:    queriesFlags,
+		flags:           flags,
+		postgresqlFlags: postgresqlFlags,
+	}
+}
+
+// Queries implements plugin.Queries.
+type Queries struct {
+	queriesFlags    plugin.QueriesFlags
+	flags           Flags
+	postgresqlFlags postgresql.Flags
+}
+
+// Init initializes plugin.
+func (m *Queries) Init(ctx context.Context, pmmUserPassword string) (*plugin.Info, error) {
+	// Queries are collected from pg_stat_statements, so it has to be available.
+	flags := m.postgresqlFlags
+	flags.StatStatements = true
+	flags.CreateExtension = m.flags.CreateExtension
+	info, err := postgresql.Init(ctx, flags, pmmUserPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	info.QuerySource = "pg_stat_statements"
+	return info, nil
+}
+
+// Name of the service.
+func (m Queries) Name() string {
+	return "postgresql"
+}
+
+// InstanceTypeName of the service.
+func (m Queries) InstanceTypeName() string {
+	return m.Name()
+}
+
+// Config returns pc.QAN.
+func (m Queries) Config() pc.QAN {
+	exampleQueries := !m.queriesFlags.DisableQueryExamples
+	return pc.QAN{
+		CollectFrom:    "pg_stat_statements",
+		Interval:       60,
+		ExampleQueries: &exampleQueries,
+	}
+}
@@ -8,9 +8,31 @@ import (
 	"github.com/go-sql-driver/mysql"
 	"github.com/percona/pmm-client/pmm/plugin"
 	"github.com/percona/pmm-client/pmm/utils"
+	"github.com/spf13/pflag"
 )
 
 var _ plugin.Metrics = (*Metrics)(nil)
+var _ plugin.UpReporter = (*Metrics)(nil)
+
+func init() {
+	plugin.Register(plugin.Registration{
+		Name:        Metrics{}.Name(),
+		Kind:        plugin.KindMetrics,
+		DefaultPort: Metrics{}.DefaultPort(),
+		Executable:  Metrics{}.Executable(),
+		Flags:       AddFlags,
+		NewMetrics: func(fs *pflag.FlagSet, opts plugin.Options) (plugin.Metrics, error) {
+			dsn, _ := fs.GetString("dsn")
+			return New(dsn), nil
+		},
+	})
+}
+
+// AddFlags adds ProxySQL specific flags to the flag set.
+func AddFlags(fs *pflag.FlagSet) {
+	fs.String("dsn", "stats:stats@tcp(localhost:6032)/", "ProxySQL connection DSN")
+	fs.Bool("disable-ssl", false, "disable ssl mode on exporter")
+}
 
 // New returns *Metrics.
 func New(dsn string) *Metrics {
@@ -28,11 +50,11 @@ type Metrics struct {
 func (m *Metrics) Init(ctx context.Context, pmmUserPassword string) (*plugin.Info, error) {
 	dsn, err := mysql.ParseDSN(m.dsn)
 	if err != nil {
-		return nil, fmt.Errorf("Bad dsn %s: %s", m.dsn, err)
+		return nil, fmt.Errorf("Bad dsn %s: %s", utils.SanitizeDSN(m.dsn), err)
 	}
 
 	if err := testConnection(ctx, dsn.FormatDSN()); err != nil {
-		return nil, fmt.Errorf("Cannot connect to ProxySQL using DSN %s: %s", m.dsn, err)
+		return nil, fmt.Errorf("Cannot connect to ProxySQL using DSN %s: %s", utils.SanitizeDSN(m.dsn), err)
 	}
 
 	info := &plugin.Info{
@@ -51,6 +73,11 @@ func (Metrics) DefaultPort() int {
 	return 42004
 }
 
+// UpMetric returns name of the metric which is 1 while ProxySQL is reachable.
+func (Metrics) UpMetric() string {
+	return "proxysql_up"
+}
+
 // Args is a list of additional arguments passed to exporter executable.
 func (Metrics) Args() []string {
 	return nil
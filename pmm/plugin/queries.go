@@ -4,6 +4,7 @@ import (
 	"context"
 
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/spf13/pflag"
 )
 
 // QueriesFlags Queries specific flags.
@@ -11,6 +12,18 @@ type QueriesFlags struct {
 	DisableQueryExamples bool
 }
 
+// AddQueriesFlags adds Queries specific flags to the flag set.
+func AddQueriesFlags(fs *pflag.FlagSet) {
+	fs.Bool("disable-queryexamples", false, "disable collection of query examples")
+}
+
+// GetQueriesFlags returns Queries specific flags from the flag set populated by AddQueriesFlags.
+func GetQueriesFlags(fs *pflag.FlagSet) QueriesFlags {
+	flags := QueriesFlags{}
+	flags.DisableQueryExamples, _ = fs.GetBool("disable-queryexamples")
+	return flags
+}
+
 // Queries is a common interface for all Query Analytics plugins.
 type Queries interface {
 	// Init initializes plugin and returns Info about database.
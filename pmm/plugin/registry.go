@@ -0,0 +1,120 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/spf13/pflag"
+)
+
+// Kind of the plugin, either metrics or queries.
+type Kind string
+
+// Plugin kinds.
+const (
+	KindMetrics Kind = "metrics"
+	KindQueries Kind = "queries"
+)
+
+// Options are passed to plugin factories.
+type Options struct {
+	// Args is a list of additional arguments passed to exporter executable.
+	Args []string
+	// PMMBaseDir is a directory with exporter executables.
+	PMMBaseDir string
+}
+
+// Registration describes plugin registered with Register.
+type Registration struct {
+	// Name of the plugin, the same as returned by Metrics.Name() or Queries.Name().
+	Name string
+	// Kind of the plugin.
+	Kind Kind
+	// DefaultPort of the exporter, metrics only.
+	DefaultPort int
+	// Executable is a name of exporter executable under PMMBaseDir or an absolute path, metrics only.
+	Executable string
+	// Flags adds plugin specific flags to the flag set of `pmm-admin add` command.
+	Flags func(fs *pflag.FlagSet)
+	// NewMetrics returns metrics plugin configured by the flags added with Flags.
+	NewMetrics func(fs *pflag.FlagSet, opts Options) (Metrics, error)
+	// NewQueries returns queries plugin configured by the flags added with Flags.
+	NewQueries func(fs *pflag.FlagSet, opts Options) (Queries, error)
+}
+
+// Type returns service type, e.g. mysql:metrics.
+func (r Registration) Type() string {
+	return fmt.Sprintf("%s:%s", r.Name, r.Kind)
+}
+
+var (
+	registryM sync.Mutex
+	registry  = map[string]Registration{}
+)
+
+// Register makes plugin available to pmm-admin. It is supposed to be called from init() of the plugin package.
+// It panics if plugin of the same type is registered twice or registration is incomplete.
+func Register(r Registration) {
+	registryM.Lock()
+	defer registryM.Unlock()
+
+	if r.Name == "" {
+		panic("plugin: Register plugin without name")
+	}
+	switch r.Kind {
+	case KindMetrics:
+		if r.NewMetrics == nil {
+			panic("plugin: Register metrics plugin " + r.Name + " without NewMetrics")
+		}
+	case KindQueries:
+		if r.NewQueries == nil {
+			panic("plugin: Register queries plugin " + r.Name + " without NewQueries")
+		}
+	default:
+		panic("plugin: Register plugin " + r.Name + " of unknown kind " + string(r.Kind))
+	}
+	if _, dup := registry[r.Type()]; dup {
+		panic("plugin: Register called twice for " + r.Type())
+	}
+	registry[r.Type()] = r
+}
+
+// Registrations returns all registered plugins sorted by type.
+func Registrations() []Registration {
+	registryM.Lock()
+	defer registryM.Unlock()
+
+	list := make([]Registration, 0, len(registry))
+	for _, r := range registry {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Type() < list[j].Type() })
+	return list
+}
+
+// Lookup returns registered plugin by service type, e.g. mysql:metrics.
+func Lookup(svcType string) (Registration, bool) {
+	registryM.Lock()
+	defer registryM.Unlock()
+
+	r, ok := registry[svcType]
+	return r, ok
+}
@@ -0,0 +1,58 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package plugin
+
+import (
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	newMetrics := func(fs *pflag.FlagSet, opts Options) (Metrics, error) { return nil, nil }
+	newQueries := func(fs *pflag.FlagSet, opts Options) (Queries, error) { return nil, nil }
+	Register(Registration{Name: "test", Kind: KindQueries, NewQueries: newQueries})
+	Register(Registration{Name: "test", Kind: KindMetrics, DefaultPort: 42999, Executable: "test_exporter", NewMetrics: newMetrics})
+
+	r, ok := Lookup("test:metrics")
+	assert.True(t, ok)
+	assert.Equal(t, "test", r.Name)
+	assert.Equal(t, 42999, r.DefaultPort)
+	assert.Equal(t, "test_exporter", r.Executable)
+
+	_, ok = Lookup("unknown:metrics")
+	assert.False(t, ok)
+
+	var types []string
+	for _, r := range Registrations() {
+		types = append(types, r.Type())
+	}
+	assert.Equal(t, []string{"test:metrics", "test:queries"}, types)
+
+	// Duplicates and incomplete registrations are programming errors.
+	assert.Panics(t, func() {
+		Register(Registration{Name: "test", Kind: KindMetrics, NewMetrics: newMetrics})
+	})
+	assert.Panics(t, func() {
+		Register(Registration{Name: "other", Kind: KindMetrics})
+	})
+	assert.Panics(t, func() {
+		Register(Registration{Name: "other", Kind: "unknown", NewMetrics: newMetrics})
+	})
+}
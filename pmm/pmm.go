@@ -19,16 +19,8 @@ package pmm
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
-	"errors"
 	"fmt"
-	"math/big"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -36,33 +28,42 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/cli/templates"
 	"github.com/fatih/color"
 	consul "github.com/hashicorp/consul/api"
-	"github.com/percona/kardianos-service"
 	"github.com/percona/pmm/version"
 	"github.com/prometheus/client_golang/api/prometheus"
 
 	"github.com/percona/pmm-client/pmm/managed"
+	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/percona/pmm-client/pmm/utils"
 )
 
 // Admin main class.
 type Admin struct {
-	ServiceName  string
-	ServicePort  int
-	Args         []string // Args defines additional arguments to pass through to *_exporter or qan-agent
-	Config       *Config
-	Verbose      bool
-	SkipAdmin    bool
-	Format       string
-	serverURL    string
-	apiTimeout   time.Duration
-	qanAPI       *API
-	consulAPI    *consul.Client
-	promQueryAPI prometheus.QueryAPI
-	managedAPI   *managed.Client
+	ServiceName    string
+	ServicePort    int
+	Args           []string          // Args defines additional arguments to pass through to *_exporter or qan-agent
+	Labels         map[string]string // Labels of metrics service in addition to the node labels from config
+	Resources      Resources         // Resources limits metrics service
+	HealthTimeout  time.Duration     // HealthTimeout is how long to wait for added exporter to become healthy, 0 disables the check
+	HealthRollback bool              // HealthRollback removes added exporter if it doesn't become healthy
+	Options        map[string]string // Options are flags service is added with, they are stored as the base for update
+	Config         *Config
+	Verbose        bool
+	SkipAdmin      bool
+	Format         string
+	serverURL      string
+	apiTimeout     time.Duration
+	qanAPI         *API
+	consulAPI      *consul.Client
+	promQueryAPI   prometheus.QueryAPI
+	managedAPI     *managed.Client
+	tlsConfig      *tls.Config
+	healthCheck    healthCheck // healthCheck replaces the check of installed exporters in tests
 	//promSeriesAPI prometheus.SeriesAPI
 }
 
@@ -75,19 +76,22 @@ func (a *Admin) SetAPI() error {
 
 	scheme := "http"
 	helpText := ""
-	insecureTransport := &http.Transport{}
 	if a.Config.ServerInsecureSSL {
 		scheme = "https"
-		insecureTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 		helpText = "--server-insecure-ssl"
 	}
 	if a.Config.ServerSSL {
 		scheme = "https"
 		helpText = "--server-ssl"
 	}
+	tlsConfig, err := a.Config.TLSConfig()
+	if err != nil {
+		return fmt.Errorf("Unable to configure SSL connection to PMM server: %s", err)
+	}
+	a.tlsConfig = tlsConfig
 
 	// QAN API.
-	a.qanAPI = NewAPI(a.Config.ServerInsecureSSL, a.apiTimeout, a.Verbose)
+	a.qanAPI = NewAPI(tlsConfig, a.apiTimeout, a.Verbose)
 	httpClient := a.qanAPI.NewClient()
 
 	// Consul API.
@@ -114,8 +118,8 @@ func (a *Admin) SetAPI() error {
 	// cfg.Transport = httpClient.Transport
 	// above should be used instead below but
 	// https://github.com/prometheus/client_golang/issues/292
-	if a.Config.ServerInsecureSSL {
-		cfg.Transport = insecureTransport
+	if tlsConfig != nil {
+		cfg.Transport = &http.Transport{TLSClientConfig: tlsConfig}
 	}
 	client, _ := prometheus.New(cfg)
 	a.promQueryAPI = prometheus.NewQueryAPI(client)
@@ -132,7 +136,9 @@ Looks like PMM server running with self-signed SSL certificate.
 Run 'pmm-admin config --server-insecure-ssl' to enable such configuration.`, a.Config.ServerAddress)
 		}
 		serverURL := fmt.Sprintf("%s://%s", scheme, a.Config.ServerAddress)
-		cleanedErr := strings.Replace(err.Error(), a.serverURL, serverURL, -1)
+		// Show server URL as configured, Go may already mask the password in it.
+		cleanedErr := utils.Redact(strings.Replace(err.Error(), a.serverURL, serverURL, -1))
+		cleanedErr = strings.Replace(cleanedErr, utils.Redact(a.serverURL), serverURL, -1)
 		return fmt.Errorf(`Unable to connect to PMM server by address: %s
 %s
 
@@ -161,7 +167,7 @@ Use 'pmm-admin config' to define server user and password.`, a.Config.ServerAddr
 		return fmt.Errorf(`Unable to connect to PMM server by address: %s
 
 Even though the server is reachable it does not look to be PMM server.
-Check if the configured address is correct. %s`, a.Config.ServerAddress, err)
+Check if the configured address is correct. %s`, a.Config.ServerAddress, utils.RedactError(err))
 	}
 
 	// Check if server is not password protected but client is configured so.
@@ -183,7 +189,7 @@ pmm-admin config --server %s %s`, a.Config.ServerAddress, helpText)
 	if a.Config.ServerUser != "" {
 		user = url.UserPassword(a.Config.ServerUser, a.Config.ServerPassword)
 	}
-	a.managedAPI = managed.NewClient(a.Config.ServerAddress, scheme, user, a.Config.ServerInsecureSSL, a.Verbose)
+	a.managedAPI = managed.NewClient(a.Config.ServerAddress, scheme, user, tlsConfig, a.Verbose)
 
 	return nil
 }
@@ -192,7 +198,7 @@ pmm-admin config --server %s %s`, a.Config.ServerAddress, helpText)
 func (a *Admin) PrintInfo() {
 	fmt.Printf("pmm-admin %s\n\n", Version)
 	a.ServerInfo()
-	fmt.Printf("%-15s | %s\n\n", "Service Manager", service.Platform())
+	fmt.Printf("%-15s | %s\n\n", "Service Manager", Platform())
 
 	fmt.Printf("%-15s | %s\n", "Go Version", strings.Replace(runtime.Version(), "go", "", 1))
 	fmt.Printf("%-15s | %s/%s\n\n", "Runtime Info", runtime.GOOS, runtime.GOARCH)
@@ -241,6 +247,9 @@ func (a *Admin) serverInfo() ServerInfo {
 	} else if a.Config.ServerSSL {
 		labels = append(labels, "SSL")
 	}
+	if a.Config.ServerCertFile != "" {
+		labels = append(labels, "mutual TLS")
+	}
 	if a.Config.ServerUser != "" {
 		labels = append(labels, "password-protected")
 	}
@@ -370,33 +379,15 @@ func (a *Admin) RemoveAllMonitoring(ignoreErrors bool) (uint16, error) {
 				continue
 			}
 			a.ServiceName = tag[6:]
-			switch svc.Service {
-			case "linux:metrics":
-				if err := a.RemoveMetrics("linux"); err != nil && !ignoreErrors {
-					return count, err
-				}
-			case "mysql:metrics":
-				if err := a.RemoveMetrics("mysql"); err != nil && !ignoreErrors {
-					return count, err
-				}
-			case "mysql:queries":
-				if err := a.RemoveQueries("mysql"); err != nil && !ignoreErrors {
-					return count, err
+			if r, ok := plugin.Lookup(svc.Service); ok {
+				var err error
+				switch r.Kind {
+				case plugin.KindMetrics:
+					err = a.RemoveMetrics(r.Name)
+				case plugin.KindQueries:
+					err = a.RemoveQueries(r.Name)
 				}
-			case "mongodb:metrics":
-				if err := a.RemoveMetrics("mongodb"); err != nil && !ignoreErrors {
-					return count, err
-				}
-			case "mongodb:queries":
-				if err := a.RemoveQueries("mongodb"); err != nil && !ignoreErrors {
-					return count, err
-				}
-			case "postgresql:metrics":
-				if err := a.RemoveMetrics("postgresql"); err != nil && !ignoreErrors {
-					return count, err
-				}
-			case "proxysql:metrics":
-				if err := a.RemoveMetrics("proxysql"); err != nil && !ignoreErrors {
+				if err != nil && !ignoreErrors {
 					return count, err
 				}
 			}
@@ -406,6 +397,7 @@ func (a *Admin) RemoveAllMonitoring(ignoreErrors bool) (uint16, error) {
 
 	// PMM-606: Remove generated password.
 	a.Config.MySQLPassword = ""
+	a.Config.PostgreSQLPassword = ""
 	a.writeConfig()
 
 	return count, nil
@@ -413,10 +405,10 @@ func (a *Admin) RemoveAllMonitoring(ignoreErrors bool) (uint16, error) {
 
 // PurgeMetrics purge metrics data on the server by its metric type and name.
 func (a *Admin) PurgeMetrics(svcType string) error {
-	if svcType != "linux:metrics" && svcType != "mysql:metrics" && svcType != "mongodb:metrics" && svcType != "proxysql:metrics" && svcType != "postgresql:metrics" {
-		return errors.New(`bad service type.
+	if r, ok := plugin.Lookup(svcType); !ok || r.Kind != plugin.KindMetrics {
+		return fmt.Errorf(`bad service type.
 
-Service type takes the following values: linux:metrics, mysql:metrics, mongodb:metrics, proxysql:metrics, postgresql:metrics.`)
+Service type takes the following values: %s.`, strings.Join(svcTypes(plugin.KindMetrics), ", "))
 	}
 
 	var promError error
@@ -500,8 +492,19 @@ Choose different name for this service.`,
 	return nil
 }
 
+var (
+	// portsM serializes port allocation, so concurrent adds don't choose the same port.
+	portsM sync.Mutex
+	// reservedPorts are chosen but maybe not yet registered on Consul.
+	reservedPorts = map[int]bool{}
+)
+
 // choosePort automatically choose the port for service.
+// The port is reserved until releasePort is called, it should be done after the service is registered on Consul.
 func (a *Admin) choosePort(port int, defaultPort int) (int, error) {
+	portsM.Lock()
+	defer portsM.Unlock()
+
 	// If port is already defined then just verify that port.
 	if port > 0 {
 		// Check if user defined port is not used.
@@ -509,27 +512,48 @@ func (a *Admin) choosePort(port int, defaultPort int) (int, error) {
 		if err != nil {
 			return port, err
 		}
-		if ok {
-			return port, nil
+		if !ok {
+			return port, fmt.Errorf("port %d is reserved by other service. Choose the different one.", port)
+		}
+		if portInUse(a.Config.BindAddress, port) {
+			return port, fmt.Errorf("port %d is already used by other process on %s. Choose the different one.", port, a.Config.BindAddress)
 		}
-		return port, fmt.Errorf("port %d is reserved by other service. Choose the different one.", port)
+		reservedPorts[port] = true
+		return port, nil
+	}
+	from, to, err := parsePortRange(a.Config.PortRange)
+	if err != nil {
+		return port, fmt.Errorf("%s: %s", ConfigFile, err)
 	}
 	// Find the first available port starting the default one.
-	for i := defaultPort; i < defaultPort+1000; i++ {
+	candidates := portCandidates(defaultPort, from, to)
+	for _, i := range candidates {
 		ok, err := a.availablePort(i)
 		if err != nil {
 			return i, err
 		}
-		if ok {
+		if ok && !portInUse(a.Config.BindAddress, i) {
+			reservedPorts[i] = true
 			return i, nil
 		}
 	}
-	return port, fmt.Errorf("ports %d-%d are reserved by other services. Try to specify the other port using --service-port",
-		port, port+1000)
+	return port, fmt.Errorf("ports %d-%d are reserved by other services or processes. Try to specify the other port using --service-port",
+		candidates[0], candidates[len(candidates)-1])
 }
 
-// availablePort check if port is occupied by any service on Consul.
+// releasePort releases the port reserved by choosePort.
+func releasePort(port int) {
+	portsM.Lock()
+	defer portsM.Unlock()
+
+	delete(reservedPorts, port)
+}
+
+// availablePort check if port is occupied by any service on Consul or reserved by choosePort.
 func (a *Admin) availablePort(port int) (bool, error) {
+	if reservedPorts[port] {
+		return false, nil
+	}
 	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
 	if err != nil {
 		return false, err
@@ -544,16 +568,6 @@ func (a *Admin) availablePort(port int) (bool, error) {
 	return true, nil
 }
 
-// checkSSLCertificate check if SSL cert and key files exist and generate them if not.
-func (a *Admin) checkSSLCertificate() error {
-	if FileExists(SSLCertFile) && FileExists(SSLKeyFile) {
-		return nil
-	}
-
-	// Generate SSL cert and key.
-	return generateSSLCertificate(a.Config.ClientAddress, SSLCertFile, SSLKeyFile)
-}
-
 // CheckVersion check server and client versions and returns boolean and error; boolean is true if error is fatal.
 func (a *Admin) CheckVersion(ctx context.Context) (fatal bool, err error) {
 	clientVersion, err := version.Parse(Version)
@@ -609,7 +623,13 @@ func (a *Admin) CheckVersion(ctx context.Context) (fatal bool, err error) {
 
 // CheckInstallation check for broken installation.
 func (a *Admin) CheckInstallation() (orphanedServices, missingServices []string) {
-	localServices := GetLocalServices()
+	var localServices []string
+	for _, s := range GetLocalServices() {
+		// Push agent is not registered on Consul.
+		if s != PushServiceName {
+			localServices = append(localServices, s)
+		}
+	}
 
 	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
 	if err != nil || node == nil || len(node.Services) == 0 {
@@ -669,8 +689,11 @@ func (a *Admin) RepairInstallation() error {
 		names, _, err := a.consulAPI.KV().Keys(prefix, "", nil)
 		if err == nil {
 			for _, name := range names {
-				for _, serviceName := range []string{"mysql", "mongodb"} {
-					if strings.HasSuffix(name, fmt.Sprintf("/qan_%s_uuid", serviceName)) {
+				for _, r := range plugin.Registrations() {
+					if r.Kind != plugin.KindQueries {
+						continue
+					}
+					if strings.HasSuffix(name, fmt.Sprintf("/qan_%s_uuid", r.Name)) {
 						data, _, err := a.consulAPI.KV().Get(name, nil)
 						if err == nil && data != nil {
 							a.deleteInstance(string(data.Value))
@@ -689,6 +712,14 @@ func (a *Admin) RepairInstallation() error {
 	} else {
 		fmt.Println("No orphaned services found.")
 	}
+
+	count, err := migrateCredentials()
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		fmt.Printf("OK, moved credentials of %d services to %s.\n", count, CredentialsDir)
+	}
 	return nil
 }
 
@@ -714,6 +745,7 @@ func (a *Admin) Uninstall() uint16 {
 			count++
 		}
 	}
+	os.Remove(LogRotateFile)
 
 	return count
 }
@@ -735,7 +767,9 @@ func GetLocalServices() (services []string) {
 
 // GetServiceDirAndExtension returns dir and extension used to create system service
 func GetServiceDirAndExtension() (dir, extension string) {
-	switch service.Platform() {
+	switch Platform() {
+	case ServiceManagerSupervisor:
+		return SupervisorDir, ".json"
 	case "linux-systemd":
 		dir = "/etc/systemd/system"
 		extension = ".service"
@@ -759,9 +793,28 @@ func (a *Admin) ShowPasswords() {
 	fmt.Printf("%-8s | %s\n", "User", a.Config.ServerUser)
 	fmt.Printf("%-8s | %s\n\n", "Password", a.Config.ServerPassword)
 
+	if a.Config.ExporterPassword != "" {
+		fmt.Println("Exporters HTTP basic authentication")
+		fmt.Printf("%-8s | %s\n", "User", a.Config.ExporterUser)
+		fmt.Printf("%-8s | %s\n\n", "Password", a.Config.ExporterPassword)
+	}
+
 	fmt.Println("MySQL new user creation")
 	fmt.Printf("%-8s | %s\n", "Password", a.Config.MySQLPassword)
 	fmt.Println()
+
+	if a.Config.PostgreSQLPassword != "" {
+		fmt.Println("PostgreSQL new user creation")
+		fmt.Printf("%-8s | %s\n", "Password", a.Config.PostgreSQLPassword)
+		fmt.Println()
+	}
+
+	if a.Config.SecretKeyFile != "" {
+		fmt.Printf("Passwords are stored encrypted with the key %s.\n", a.Config.SecretKeyFile)
+	}
+	if a.Config.PasswordCommand != "" {
+		fmt.Println("Passwords missing in config are fetched with password command.")
+	}
 }
 
 // FileExists check if file exists.
@@ -774,15 +827,19 @@ func FileExists(file string) bool {
 
 // CheckBinaries check if all PMM Client binaries are at their paths
 func CheckBinaries() string {
-	paths := []string{
-		fmt.Sprintf("%s/node_exporter", PMMBaseDir),
-		fmt.Sprintf("%s/mysqld_exporter", PMMBaseDir),
-		fmt.Sprintf("%s/mongodb_exporter", PMMBaseDir),
-		fmt.Sprintf("%s/proxysql_exporter", PMMBaseDir),
-		fmt.Sprintf("%s/postgres_exporter", PMMBaseDir),
+	var paths []string
+	for _, r := range plugin.Registrations() {
+		switch {
+		case filepath.IsAbs(r.Executable):
+			paths = append(paths, r.Executable)
+		case r.Executable != "":
+			paths = append(paths, fmt.Sprintf("%s/%s", PMMBaseDir, r.Executable))
+		}
+	}
+	paths = append(paths,
 		fmt.Sprintf("%s/bin/percona-qan-agent", AgentBaseDir),
 		fmt.Sprintf("%s/bin/percona-qan-agent-installer", AgentBaseDir),
-	}
+	)
 	for _, p := range paths {
 		if !FileExists(p) {
 			return p
@@ -802,75 +859,24 @@ func colorStatus(msgOK string, msgNotOK string, ok bool) string {
 	return c(msgNotOK)
 }
 
-// generateSSLCertificate generate SSL certificate and key and write them into the files.
-func generateSSLCertificate(host, certFile, keyFile string) error {
-	// Generate key.
-	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		return fmt.Errorf("failed to generate private key: %s", err)
-	}
-
-	// Generate cert.
-	notBefore, _ := time.Parse("Jan 2 15:04:05 2006", "Nov 25 15:00:00 2016")
-	notAfter, _ := time.Parse("Jan 2 15:04:05 2006", "Nov 25 15:00:00 2026")
-	serialNumber, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
-	cert := x509.Certificate{
-		Subject:               pkix.Name{Organization: []string{"PMM Client"}},
-		SerialNumber:          serialNumber,
-		NotBefore:             notBefore,
-		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-	}
-	if ip := net.ParseIP(host); ip != nil {
-		cert.IPAddresses = append(cert.IPAddresses, ip)
-	} else {
-		cert.DNSNames = append(cert.DNSNames, host)
-	}
-
-	certBytes, err := x509.CreateCertificate(rand.Reader, &cert, &cert, &privKey.PublicKey, privKey)
-	if err != nil {
-		return fmt.Errorf("failed to generate certificate: %s", err)
-	}
-
-	// Write files.
-	out, err := os.OpenFile(certFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write %s: %s", certFile, err)
-	}
-	pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: certBytes})
-	out.Close()
-
-	out, err = os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to write %s: %s", keyFile, err)
+// svcTypes returns types of the registered plugins, optionally limited to the given kind.
+func svcTypes(kind plugin.Kind) []string {
+	var types []string
+	for _, r := range plugin.Registrations() {
+		if kind == "" || r.Kind == kind {
+			types = append(types, r.Type())
+		}
 	}
-	pem.Encode(out, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privKey)})
-	out.Close()
-
-	return nil
-}
-
-var svcTypes = []string{
-	"linux:metrics",
-	"mysql:metrics",
-	"mysql:queries",
-	"mongodb:metrics",
-	"mongodb:queries",
-	"proxysql:metrics",
-	"postgresql:metrics",
+	return types
 }
 
 // isValidSvcType checks if given service type is allowed
 func isValidSvcType(svcType string) error {
-	for _, v := range svcTypes {
-		if v == svcType {
-			return nil
-		}
+	if _, ok := plugin.Lookup(svcType); ok {
+		return nil
 	}
 
 	return fmt.Errorf(`bad service type.
 
-Service type takes the following values: %s.`, strings.Join(svcTypes, ", "))
+Service type takes the following values: %s.`, strings.Join(svcTypes(""), ", "))
 }
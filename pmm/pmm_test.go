@@ -21,11 +21,31 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	_ "github.com/percona/pmm-client/pmm/plugin/linux/metrics"
+	_ "github.com/percona/pmm-client/pmm/plugin/mongodb/metrics"
+	_ "github.com/percona/pmm-client/pmm/plugin/mongodb/queries"
+	_ "github.com/percona/pmm-client/pmm/plugin/mysql/metrics"
+	_ "github.com/percona/pmm-client/pmm/plugin/mysql/queries"
+	_ "github.com/percona/pmm-client/pmm/plugin/postgresql/metrics"
+	_ "github.com/percona/pmm-client/pmm/plugin/postgresql/queries"
+	_ "github.com/percona/pmm-client/pmm/plugin/proxysql/metrics"
 )
 
 func TestIsValidSvcType(t *testing.T) {
 	// check valid types
-	for _, v := range svcTypes {
+	expected := []string{
+		"linux:metrics",
+		"mongodb:metrics",
+		"mongodb:queries",
+		"mysql:metrics",
+		"mysql:queries",
+		"postgresql:metrics",
+		"postgresql:queries",
+		"proxysql:metrics",
+	}
+	assert.Equal(t, expected, svcTypes(""))
+	for _, v := range svcTypes("") {
 		assert.Nil(t, isValidSvcType(v))
 	}
 
@@ -52,3 +72,19 @@ Get http://172.0.0.1:8080/qan-api/ping: net/http: request canceled while waiting
 * You may also check the firewall settings.`
 	assert.Equal(t, expected, err.Error())
 }
+
+func TestUseServiceManager(t *testing.T) {
+	defer useServiceManager("")
+
+	assert.Nil(t, useServiceManager(ServiceManagerSupervisor))
+	assert.Equal(t, ServiceManagerSupervisor, Platform())
+	dir, extension := GetServiceDirAndExtension()
+	assert.Equal(t, SupervisorDir, dir)
+	assert.Equal(t, ".json", extension)
+
+	assert.NotNil(t, useServiceManager("runit"))
+	assert.Equal(t, ServiceManagerSupervisor, Platform())
+
+	assert.Nil(t, useServiceManager(""))
+	assert.NotEqual(t, ServiceManagerSupervisor, Platform())
+}
@@ -0,0 +1,163 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/percona/pmm-client/pmm/plugin"
+)
+
+// procDir is a mount point of procfs, variable for tests.
+var procDir = "/proc"
+
+// parsePortRange parses port range in the form from-to, empty string means no range.
+func parsePortRange(s string) (from, to int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) == 2 {
+		from, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err == nil {
+			to, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+	}
+	if len(parts) != 2 || err != nil || from < 1 || to > 65535 || from > to {
+		return 0, 0, fmt.Errorf("invalid port range %q, should be in the form from-to, e.g. 42000-42999", s)
+	}
+	return from, to, nil
+}
+
+// portCandidates returns ports to try for service with the given default port, in order.
+// Without range the default port and the next 999 ones are tried. With range the default port is tried first
+// if it is in the range, then the rest of the range.
+func portCandidates(defaultPort, from, to int) []int {
+	var ports []int
+	if from == 0 {
+		for i := defaultPort; i < defaultPort+1000; i++ {
+			ports = append(ports, i)
+		}
+		return ports
+	}
+	start := from
+	if defaultPort >= from && defaultPort <= to {
+		start = defaultPort
+	}
+	for i := start; i <= to; i++ {
+		ports = append(ports, i)
+	}
+	for i := from; i < start; i++ {
+		ports = append(ports, i)
+	}
+	return ports
+}
+
+// portInUse returns true if some process on this system listens on the port of bind address.
+// Errors other than address in use, e.g. bind address is not local, are not reported.
+func portInUse(bindAddress string, port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(bindAddress, strconv.Itoa(port)))
+	if err != nil {
+		return errors.Is(err, syscall.EADDRINUSE)
+	}
+	l.Close()
+	return false
+}
+
+// ForeignListener is a process listening on the port of registered metrics service,
+// which is not the exporter of the service.
+type ForeignListener struct {
+	Type       string
+	Name       string
+	Port       int
+	PID        int
+	Executable string
+}
+
+// foreignListeners returns ports of registered metrics services listened by other processes than exporters.
+// Ports nobody listens are not reported, those services are down. It works on Linux only.
+func (a *Admin) foreignListeners(services []ServiceStatus) ([]ForeignListener, error) {
+	listeners, err := readListeners(procDir)
+	if err != nil {
+		return nil, err
+	}
+	// The same port may be listened on both IPv4 and IPv6.
+	inodes := map[int][]string{}
+	for inode, addr := range listeners {
+		port := addrPort(addr)
+		inodes[port] = append(inodes[port], inode)
+	}
+
+	var owners map[string]int
+	var foreign []ForeignListener
+	for _, svc := range services {
+		port, _ := strconv.Atoi(svc.Port)
+		if len(inodes[port]) == 0 {
+			continue
+		}
+		r, ok := plugin.Lookup(svc.Type)
+		if !ok || r.Executable == "" {
+			continue
+		}
+		if owners == nil {
+			if owners, err = socketOwners(procDir); err != nil {
+				return nil, err
+			}
+		}
+		for _, inode := range inodes[port] {
+			pid, ok := owners[inode]
+			if !ok {
+				continue
+			}
+			exe, _ := os.Readlink(filepath.Join(procDir, strconv.Itoa(pid), "exe"))
+			if filepath.Base(exe) == filepath.Base(r.Executable) || strings.HasPrefix(exe, PMMBaseDir+"/") {
+				continue
+			}
+			foreign = append(foreign, ForeignListener{Type: svc.Type, Name: svc.Name, Port: port, PID: pid, Executable: exe})
+			break
+		}
+	}
+	return foreign, nil
+}
+
+// socketOwners returns PIDs of processes by inodes of sockets they have open.
+func socketOwners(proc string) (map[string]int, error) {
+	dirs, err := ioutil.ReadDir(proc)
+	if err != nil {
+		return nil, err
+	}
+	owners := map[string]int{}
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		for _, inode := range socketInodes(proc, pid) {
+			owners[inode] = pid
+		}
+	}
+	return owners, nil
+}
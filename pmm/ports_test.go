@@ -0,0 +1,161 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortRange(t *testing.T) {
+	from, to, err := parsePortRange("")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, from)
+	assert.Equal(t, 0, to)
+
+	from, to, err = parsePortRange("42000-42999")
+	assert.Nil(t, err)
+	assert.Equal(t, 42000, from)
+	assert.Equal(t, 42999, to)
+
+	for _, s := range []string{"42000", "42999-42000", "0-100", "42000-70000", "a-b", "1-2-3"} {
+		_, _, err = parsePortRange(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestPortCandidates(t *testing.T) {
+	ports := portCandidates(42002, 0, 0)
+	assert.Len(t, ports, 1000)
+	assert.Equal(t, 42002, ports[0])
+	assert.Equal(t, 43001, ports[999])
+
+	assert.Equal(t, []int{43002, 43003, 43000, 43001}, portCandidates(43002, 43000, 43003))
+	assert.Equal(t, []int{43000, 43001}, portCandidates(42002, 43000, 43001))
+}
+
+func TestChoosePort(t *testing.T) {
+	a, _, _, teardown := setupRollbackTest(t)
+	defer teardown()
+	a.Config.PortRange = "43100-43109"
+
+	// Concurrent adds get distinct ports of the range.
+	ports := make([]int, 10)
+	errs := make([]error, 10)
+	var wg sync.WaitGroup
+	for i := range ports {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ports[i], errs[i] = a.choosePort(0, 43100)
+		}(i)
+	}
+	wg.Wait()
+	seen := map[int]bool{}
+	for i, port := range ports {
+		assert.Nil(t, errs[i])
+		assert.True(t, port >= 43100 && port <= 43109, "%d", port)
+		assert.False(t, seen[port], "%d", port)
+		seen[port] = true
+	}
+
+	// Reserved ports are not available until released.
+	_, err := a.choosePort(0, 43100)
+	assert.Error(t, err)
+	_, err = a.choosePort(43105, 43100)
+	assert.Error(t, err)
+	releasePort(43105)
+	port, err := a.choosePort(0, 43100)
+	assert.Nil(t, err)
+	assert.Equal(t, 43105, port)
+
+	for port := range seen {
+		releasePort(port)
+	}
+}
+
+func TestPortInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	assert.True(t, portInUse("127.0.0.1", port))
+	l.Close()
+	assert.False(t, portInUse("127.0.0.1", port))
+
+	// Not local address is not an error of the port.
+	assert.False(t, portInUse("192.0.2.42", port))
+}
+
+func TestForeignListeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	defer func(d string) { procDir = d }(procDir)
+	procDir = dir
+
+	// 42002 is listened by nginx, 42000 by node_exporter, 42003 is not listened, 42004 is not listening,
+	// 42005 is listened by nginx on IPv4 and by postgres_exporter on IPv6.
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:A412 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 00000000:A410 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:A414 0100007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 20 4 30 10 -1
+   3: 00000000:A415 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1004 1 0000000000000000 100 0 0 10 0
+`
+	tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:A415 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1005 1 0000000000000000 100 0 0 10 0
+`
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "net"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "net", "tcp"), []byte(tcp), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "net", "tcp6"), []byte(tcp6), 0644))
+	for pid, p := range map[string]struct {
+		exe     string
+		sockets []string
+	}{
+		"100": {"/usr/sbin/nginx", []string{"1001", "1004"}},
+		"200": {PMMBaseDir + "/node_exporter", []string{"1002"}},
+		"300": {"/usr/bin/ssh", []string{"1003"}},
+		"400": {PMMBaseDir + "/postgres_exporter", []string{"1005"}},
+	} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(dir, pid, "fd"), 0755))
+		assert.Nil(t, os.Symlink(p.exe, filepath.Join(dir, pid, "exe")))
+		for i, socket := range p.sockets {
+			assert.Nil(t, os.Symlink("socket:["+socket+"]", filepath.Join(dir, pid, "fd", strconv.Itoa(i+3))))
+		}
+	}
+
+	a := &Admin{Config: &Config{}}
+	foreign, err := a.foreignListeners([]ServiceStatus{
+		{Type: "mysql:metrics", Name: "db01", Port: "42002"},
+		{Type: "linux:metrics", Name: "db01", Port: "42000"},
+		{Type: "mongodb:metrics", Name: "db01", Port: "42003"},
+		{Type: "proxysql:metrics", Name: "db01", Port: "42004"},
+		{Type: "postgresql:metrics", Name: "db01", Port: "42005"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []ForeignListener{
+		{Type: "mysql:metrics", Name: "db01", Port: 42002, PID: 100, Executable: "/usr/sbin/nginx"},
+		{Type: "postgresql:metrics", Name: "db01", Port: 42005, PID: 100, Executable: "/usr/sbin/nginx"},
+	}, foreign)
+}
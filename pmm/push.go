@@ -0,0 +1,272 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	consul "github.com/hashicorp/consul/api"
+	service "github.com/percona/kardianos-service"
+	"github.com/percona/pmm-client/pmm/push"
+	"github.com/percona/pmm-client/pmm/utils"
+)
+
+const (
+	// PushServiceName is a name of system service running push agent.
+	PushServiceName = "pmm-push"
+	// PushTag is Consul tag of metrics services pushed by push agent, PMM Server should not scrape them.
+	PushTag = "push"
+)
+
+// pushPath is metrics endpoint of exporter scraped every factor intervals of push agent,
+// jobSuffix is added to job label the same way PMM Server names its scrape jobs.
+type pushPath struct {
+	path      string
+	jobSuffix string
+	factor    int
+}
+
+// pushPaths are metrics endpoints of exporters serving metrics of different resolutions.
+// Each endpoint has own job, so up metric of every endpoint is a separate series.
+var pushPaths = map[string][]pushPath{
+	"mysql:metrics": {{"metrics-hr", "-hr", 1}, {"metrics-mr", "-mr", 1}, {"metrics-lr", "-lr", 6}},
+}
+
+// EnablePush installs push agent sending metrics of this system to PMM Server with remote write protocol.
+// Buffer size is in MB.
+func (a *Admin) EnablePush(interval time.Duration, bufferSize int) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	svcConfig := &service.Config{
+		Name:        PushServiceName,
+		DisplayName: "PMM push agent",
+		Description: "PMM push agent sending metrics to PMM Server",
+		Executable:  executable,
+		Arguments: []string{
+			"push", "run",
+			fmt.Sprintf("--config-file=%s", ConfigFile),
+			fmt.Sprintf("--interval=%s", interval),
+			fmt.Sprintf("--buffer-size=%d", bufferSize),
+		},
+	}
+	// Reinstall agent, so new options take effect.
+	uninstallService(PushServiceName)
+	if err := installService(svcConfig); err != nil {
+		return err
+	}
+	if err := a.tagPushedServices(true); err != nil {
+		return err
+	}
+
+	a.Config.Push = true
+	return a.writeConfig()
+}
+
+// DisablePush uninstalls push agent.
+func (a *Admin) DisablePush() error {
+	if err := uninstallService(PushServiceName); err != nil && a.Config.Push {
+		return err
+	}
+	if err := a.tagPushedServices(false); err != nil {
+		return err
+	}
+	a.Config.Push = false
+	return a.writeConfig()
+}
+
+// tagPushedServices adds PushTag to metrics services of this system on Consul, or removes it.
+func (a *Admin) tagPushedServices(push bool) error {
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil || node == nil {
+		return err
+	}
+	for _, svc := range node.Services {
+		if !strings.HasSuffix(svc.Service, ":metrics") {
+			continue
+		}
+		tags := withPushTag(svc.Tags, push)
+		if strings.Join(tags, ",") == strings.Join(svc.Tags, ",") {
+			continue
+		}
+		svc.Tags = tags
+		reg := consul.CatalogRegistration{
+			Node:    a.Config.ClientName,
+			Address: a.Config.ClientAddress,
+			Service: svc,
+		}
+		if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withPushTag returns tags with PushTag added or removed.
+func withPushTag(tags []string, push bool) []string {
+	res := make([]string, 0, len(tags)+1)
+	for _, tag := range tags {
+		if tag != PushTag {
+			res = append(res, tag)
+		}
+	}
+	if push {
+		res = append(res, PushTag)
+	}
+	return res
+}
+
+// RunPush runs push agent until context is canceled.
+// Server doesn't have to be reachable on start, samples are buffered on disk until it is.
+func (a *Admin) RunPush(ctx context.Context, interval time.Duration, bufferSize int) error {
+	logger := log.New(utils.NewRedactWriter(os.Stderr), "", log.LstdFlags)
+	if err := a.SetAPI(); err != nil {
+		// Clients are not set up at all if config is invalid.
+		if a.consulAPI == nil {
+			return err
+		}
+		logger.Print(err)
+	}
+
+	username, password := a.Config.exporterCredentials()
+	agent := &push.Agent{
+		Targets: func() ([]push.Target, error) {
+			return a.pushTargets(interval)
+		},
+		Scraper: &http.Client{
+			Timeout: interval,
+			// Exporters use self-signed certificate generated by pmm-admin.
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		},
+		Username: username,
+		Password: password,
+		WriteURL: fmt.Sprintf("%s/prometheus/api/v1/write", a.serverURL),
+		Writer: &http.Client{
+			Timeout:   a.apiTimeout,
+			Transport: &http.Transport{TLSClientConfig: a.tlsConfig},
+		},
+		Interval: interval,
+		Queue: &push.Queue{
+			Dir:      filepath.Join(PushDir, "queue"),
+			MaxBytes: int64(bufferSize) << 20,
+		},
+		StatusFile: filepath.Join(PushDir, "status.json"),
+		Logger:     logger,
+	}
+	logger.Printf("Pushing metrics to %s every %s.", a.Config.ServerAddress, interval)
+	return agent.Run(ctx)
+}
+
+// pushTargets returns metrics endpoints of exporters registered for this system on Consul
+// with the labels PMM Server would add when scraping them.
+func (a *Admin) pushTargets(interval time.Duration) ([]push.Target, error) {
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, nil
+	}
+
+	var targets []push.Target
+	for _, svc := range node.Services {
+		if !strings.HasSuffix(svc.Service, ":metrics") {
+			continue
+		}
+		labels, tags := splitLabelTags(svc.Tags)
+		labels["job"] = strings.TrimSuffix(svc.Service, ":metrics")
+		for _, tag := range tags {
+			switch {
+			case strings.HasPrefix(tag, "alias_"):
+				labels["instance"] = tag[6:]
+			case strings.HasPrefix(tag, "cluster_"):
+				labels["cluster"] = tag[8:]
+			}
+		}
+
+		scheme := "http"
+		if isHTTPSService(svc) {
+			scheme = "https"
+		}
+		paths, ok := pushPaths[svc.Service]
+		if !ok {
+			paths = []pushPath{{"metrics", "", 1}}
+		}
+		for _, p := range paths {
+			pathLabels := make(map[string]string, len(labels))
+			for name, value := range labels {
+				pathLabels[name] = value
+			}
+			pathLabels["job"] += p.jobSuffix
+			targets = append(targets, push.Target{
+				URL:      fmt.Sprintf("%s://%s:%d/%s", scheme, a.Config.BindAddress, svc.Port, p.path),
+				Labels:   pathLabels,
+				Interval: time.Duration(p.factor) * interval,
+			})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].URL < targets[j].URL })
+	return targets, nil
+}
+
+// checkPush prints push agent health saved by the last agent cycle.
+func (a *Admin) checkPush() {
+	color.New(color.Bold).Println("* Connection: Client --> Server (push mode)")
+	status, err := push.ReadStatus(filepath.Join(PushDir, "status.json"))
+	if err != nil {
+		fmt.Printf("Push agent status is not available: %s\n", err)
+		fmt.Printf("Check that %s service is running and its logs.\n\n", PushServiceName)
+		return
+	}
+
+	age := time.Since(status.Time)
+	fmt.Printf("%-20s | %s (%s ago)\n", "Last cycle", status.Time.Format(time.RFC3339), age.Truncate(time.Second))
+	lastPush := "never"
+	if !status.LastPush.IsZero() {
+		lastPush = fmt.Sprintf("%s (%s ago)", status.LastPush.Format(time.RFC3339), time.Since(status.LastPush).Truncate(time.Second))
+	}
+	fmt.Printf("%-20s | %s\n", "Last push", lastPush)
+	fmt.Printf("%-20s | %s\n", "Remote write", colorStatus("OK", "DOWN", status.PushError == ""))
+	fmt.Printf("%-20s | %d\n", "Buffered requests", status.Buffered)
+	if status.PushError != "" {
+		fmt.Printf("%-20s | %s\n", "Error", status.PushError)
+	}
+	fmt.Println()
+
+	fmt.Printf("%-20s %-20s %-40s %-8s\n", "SERVICE", "NAME", "ENDPOINT", "STATUS")
+	for _, t := range status.Targets {
+		fmt.Printf("%-20s %-20s %-40s %-8s\n", t.Labels["job"], t.Labels["instance"], t.URL, colorStatus("OK", "DOWN", t.Error == ""))
+	}
+	for _, t := range status.Targets {
+		if t.Error != "" {
+			fmt.Printf("\n%s: %s", t.URL, t.Error)
+		}
+	}
+	fmt.Println()
+}
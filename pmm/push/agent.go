@@ -0,0 +1,282 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package push implements push mode: exporters of this system are scraped locally
+// and samples are sent to PMM Server with Prometheus remote write protocol.
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Target is exporter endpoint scraped by agent.
+type Target struct {
+	// URL of metrics endpoint.
+	URL string
+	// Labels added to every series, e.g. job and instance.
+	Labels map[string]string
+	// Interval between scrapes, Agent.Interval if zero.
+	Interval time.Duration
+}
+
+// Status is the result of the last agent cycle, it is saved to Agent.StatusFile.
+type Status struct {
+	Time      time.Time
+	Targets   []TargetStatus
+	LastPush  time.Time
+	PushError string `json:",omitempty"`
+	// Buffered is a number of requests waiting in the queue.
+	Buffered int
+}
+
+// TargetStatus is the result of the last scrape of target.
+type TargetStatus struct {
+	URL     string
+	Labels  map[string]string
+	Samples int
+	Error   string `json:",omitempty"`
+}
+
+// Agent scrapes targets and pushes samples to remote write endpoint.
+type Agent struct {
+	// Targets returns endpoints to scrape, it is called on every cycle.
+	Targets func() ([]Target, error)
+	// Scraper is a client for exporters, Username and Password are used for basic auth if set.
+	Scraper  *http.Client
+	Username string
+	Password string
+	// WriteURL is remote write endpoint, Writer is a client for it.
+	WriteURL string
+	Writer   *http.Client
+	Interval time.Duration
+	Queue    *Queue
+	// StatusFile keeps Status of the last cycle, see ReadStatus.
+	StatusFile string
+	Logger     *log.Logger
+
+	targets    []Target
+	lastScrape map[string]time.Time
+	lastPush   time.Time
+}
+
+// Run runs agent until context is canceled.
+func (a *Agent) Run(ctx context.Context) error {
+	ticker := time.NewTicker(a.Interval)
+	defer ticker.Stop()
+	for {
+		a.cycle(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// cycle scrapes due targets, pushes samples and saves status.
+func (a *Agent) cycle(ctx context.Context, now time.Time) Status {
+	if a.lastScrape == nil {
+		a.lastScrape = map[string]time.Time{}
+	}
+	status := Status{Time: now}
+
+	// Keep scraping known targets if the list can't be refreshed, e.g. during server outage.
+	if targets, err := a.Targets(); err == nil {
+		a.targets = targets
+	} else {
+		a.Logger.Printf("Cannot refresh targets: %s", err)
+	}
+
+	var series []Series
+	for _, t := range a.targets {
+		interval := t.Interval
+		if interval == 0 {
+			interval = a.Interval
+		}
+		// Allow some jitter of the ticker.
+		if now.Sub(a.lastScrape[t.URL]) < interval-a.Interval/2 {
+			continue
+		}
+		a.lastScrape[t.URL] = now
+
+		ts := TargetStatus{URL: t.URL, Labels: t.Labels}
+		list, err := a.scrape(ctx, t)
+		up := 1.0
+		if err != nil {
+			ts.Error = err.Error()
+			up = 0
+		}
+		ts.Samples = len(list)
+		status.Targets = append(status.Targets, ts)
+
+		list = append(list, Series{Labels: []Label{{Name: "__name__", Value: "up"}}, Value: up})
+		for _, s := range list {
+			series = append(series, withLabels(s, t.Labels, now))
+		}
+	}
+
+	if len(series) > 0 {
+		if err := a.push(ctx, EncodeWriteRequest(series)); err != nil {
+			status.PushError = err.Error()
+			a.Logger.Printf("Cannot push samples, buffering: %s", err)
+		}
+	}
+	status.LastPush = a.lastPush
+	status.Buffered = a.Queue.Len()
+
+	if a.StatusFile != "" {
+		if err := writeStatus(a.StatusFile, status); err != nil {
+			a.Logger.Printf("Cannot save status: %s", err)
+		}
+	}
+	return status
+}
+
+// scrape returns series of target.
+func (a *Agent) scrape(ctx context.Context, t Target) ([]Series, error) {
+	req, err := http.NewRequest("GET", t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	if a.Username != "" {
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+	resp, err := a.Scraper.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+	return Parse(resp.Body)
+}
+
+// push sends queued requests and then the new one, the new one is queued if sending fails
+// with an error worth retrying. Requests rejected by server are dropped.
+func (a *Agent) push(ctx context.Context, data []byte) error {
+	err := a.Queue.Flush(func(queued []byte) error {
+		err := a.send(ctx, queued)
+		if _, ok := err.(rejectedError); ok {
+			a.Logger.Printf("Dropping buffered samples: %s", err)
+			return nil
+		}
+		return err
+	})
+	if err == nil {
+		err = a.send(ctx, data)
+	}
+	if _, ok := err.(rejectedError); ok {
+		return err
+	}
+	if err != nil {
+		if qErr := a.Queue.Push(data); qErr != nil {
+			a.Logger.Printf("Cannot buffer samples: %s", qErr)
+		}
+		return err
+	}
+	a.lastPush = time.Now()
+	return nil
+}
+
+// rejectedError is returned for request server will not accept on retry, e.g. malformed one.
+type rejectedError struct {
+	error
+}
+
+// send sends single remote write request. Server errors, 429 Too Many Requests and network errors
+// are worth retrying, other HTTP errors are returned as rejectedError.
+func (a *Agent) send(ctx context.Context, data []byte) error {
+	req, err := http.NewRequest("POST", a.WriteURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := a.Writer.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+		if resp.StatusCode/100 != 5 && resp.StatusCode != http.StatusTooManyRequests {
+			return rejectedError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// withLabels returns series with target labels and timestamp set.
+// Target labels override the ones exposed by exporter, the same way Prometheus does without honor_labels.
+func withLabels(s Series, labels map[string]string, now time.Time) Series {
+	merged := make([]Label, 0, len(s.Labels)+len(labels))
+	for _, l := range s.Labels {
+		if _, ok := labels[l.Name]; !ok {
+			merged = append(merged, l)
+		}
+	}
+	for name, value := range labels {
+		merged = append(merged, Label{Name: name, Value: value})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+	s.Labels = merged
+	if s.Timestamp == 0 {
+		s.Timestamp = now.UnixNano() / int64(time.Millisecond)
+	}
+	return s
+}
+
+// writeStatus saves status to file.
+func writeStatus(file string, status Status) error {
+	b, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
+
+// ReadStatus reads status saved by agent.
+func ReadStatus(file string) (*Status, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{}
+	if err := json.Unmarshal(b, status); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return status, nil
+}
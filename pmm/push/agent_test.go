@@ -0,0 +1,193 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package push
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, _ := r.BasicAuth(); user != "pmm" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("mysql_up{instance=\"exporter\"} 1\n"))
+	}))
+	defer exporter.Close()
+
+	var m sync.Mutex
+	var received [][]byte
+	serverUp := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		if !serverUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		b, _ := ioutil.ReadAll(r.Body)
+		received = append(received, b)
+	}))
+	defer server.Close()
+
+	agent := &Agent{
+		Targets: func() ([]Target, error) {
+			return []Target{
+				{URL: exporter.URL + "/metrics", Labels: map[string]string{"job": "mysql", "instance": "db01"}},
+				{URL: exporter.URL + "/metrics-lr", Labels: map[string]string{"job": "mysql", "instance": "db01"}, Interval: time.Minute},
+			}, nil
+		},
+		Scraper:    http.DefaultClient,
+		Username:   "pmm",
+		Password:   "secret",
+		WriteURL:   server.URL,
+		Writer:     http.DefaultClient,
+		Interval:   10 * time.Second,
+		Queue:      &Queue{Dir: filepath.Join(dir, "queue")},
+		StatusFile: filepath.Join(dir, "status.json"),
+		Logger:     log.New(ioutil.Discard, "", 0),
+	}
+
+	// Server is down, samples are buffered.
+	now := time.Now()
+	status := agent.cycle(context.Background(), now)
+	assert.Len(t, status.Targets, 2)
+	assert.NotEmpty(t, status.PushError)
+	assert.Equal(t, 1, status.Buffered)
+	assert.True(t, status.LastPush.IsZero())
+	assert.Equal(t, map[string]string{"job": "mysql", "instance": "db01"}, status.Targets[0].Labels)
+	assert.Equal(t, 1, status.Targets[0].Samples)
+
+	// Server is up, buffered and new samples are sent. Low resolution target is not due yet.
+	m.Lock()
+	serverUp = true
+	m.Unlock()
+	status = agent.cycle(context.Background(), now.Add(10*time.Second))
+	assert.Len(t, status.Targets, 1)
+	assert.Empty(t, status.PushError)
+	assert.Equal(t, 0, status.Buffered)
+	assert.False(t, status.LastPush.IsZero())
+	assert.Len(t, received, 2)
+
+	saved, err := ReadStatus(agent.StatusFile)
+	assert.Nil(t, err)
+	assert.Equal(t, status.Targets, saved.Targets)
+}
+
+func TestAgentPushRejected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "push")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	var m sync.Mutex
+	var received [][]byte
+	code := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		b, _ := ioutil.ReadAll(r.Body)
+		if code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		received = append(received, b)
+	}))
+	defer server.Close()
+	setCode := func(c int) {
+		m.Lock()
+		code = c
+		m.Unlock()
+	}
+
+	agent := &Agent{
+		WriteURL: server.URL,
+		Writer:   http.DefaultClient,
+		Queue:    &Queue{Dir: filepath.Join(dir, "queue")},
+		Logger:   log.New(ioutil.Discard, "", 0),
+	}
+	ctx := context.Background()
+
+	// Server and network errors, and too many requests, are retried later.
+	assert.NotNil(t, agent.push(ctx, []byte("first")))
+	setCode(http.StatusTooManyRequests)
+	assert.NotNil(t, agent.push(ctx, []byte("second")))
+	assert.Equal(t, 2, agent.Queue.Len())
+
+	// Rejected request is not buffered, and rejected buffered ones are dropped.
+	setCode(http.StatusBadRequest)
+	err = agent.push(ctx, []byte("third"))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "400 Bad Request")
+	assert.Equal(t, 0, agent.Queue.Len())
+
+	setCode(http.StatusOK)
+	assert.Nil(t, agent.push(ctx, []byte("fourth")))
+	assert.Equal(t, [][]byte{[]byte("fourth")}, received)
+}
+
+func TestWithLabels(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	s := withLabels(Series{
+		Labels: []Label{{"__name__", "mysql_up"}, {"instance", "exporter"}, {"zone", "a"}},
+		Value:  1,
+	}, map[string]string{"job": "mysql", "instance": "db01"}, now)
+	assert.Equal(t, Series{
+		Labels:    []Label{{"__name__", "mysql_up"}, {"instance", "db01"}, {"job", "mysql"}, {"zone", "a"}},
+		Value:     1,
+		Timestamp: 1500000000000,
+	}, s)
+}
+
+func TestQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "queue")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	q := &Queue{Dir: dir, MaxBytes: 10}
+	assert.Nil(t, q.Push([]byte("12345")))
+	assert.Nil(t, q.Push([]byte("67890")))
+	assert.Nil(t, q.Push([]byte("abcde")))
+	// The oldest request is dropped.
+	assert.Equal(t, 2, q.Len())
+
+	var sent []string
+	err = q.Flush(func(data []byte) error {
+		sent = append(sent, string(data))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"67890", "abcde"}, sent)
+	assert.Equal(t, 0, q.Len())
+}
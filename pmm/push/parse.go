@@ -0,0 +1,162 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package push
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Label is a name-value pair of series.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Series is a single sample of time series.
+type Series struct {
+	// Labels sorted by name, including __name__.
+	Labels []Label
+	Value  float64
+	// Timestamp in milliseconds, 0 if not set by exporter.
+	Timestamp int64
+}
+
+// Parse reads metrics in Prometheus text exposition format.
+func Parse(r io.Reader) ([]Series, error) {
+	var list []Series
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		list = append(list, s)
+	}
+	return list, scanner.Err()
+}
+
+// parseLine parses line in the form name{label="value",...} value [timestamp].
+func parseLine(line string) (Series, error) {
+	s := Series{}
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+	s.Labels = append(s.Labels, Label{Name: "__name__", Value: line[:i]})
+	rest := line[i:]
+
+	if rest[0] == '{' {
+		labels, n, err := parseLabels(rest)
+		if err != nil {
+			return s, err
+		}
+		s.Labels = append(s.Labels, labels...)
+		rest = rest[n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) != 1 && len(fields) != 2 {
+		return s, fmt.Errorf("invalid value in %q", line)
+	}
+	v, err := parseValue(fields[0])
+	if err != nil {
+		return s, err
+	}
+	s.Value = v
+	if len(fields) == 2 {
+		if s.Timestamp, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return s, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+	}
+
+	sort.Slice(s.Labels, func(i, j int) bool { return s.Labels[i].Name < s.Labels[j].Name })
+	return s, nil
+}
+
+// parseLabels parses labels in curly braces at the beginning of s and returns them
+// with the number of bytes consumed.
+func parseLabels(s string) ([]Label, int, error) {
+	var labels []Label
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("unterminated labels in %q", s)
+		}
+		if s[i] == '}' {
+			return labels, i + 1, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 || i+eq+1 >= len(s) || s[i+eq+1] != '"' {
+			return nil, 0, fmt.Errorf("invalid label in %q", s)
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 2
+
+		var value []byte
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value = append(value, '\n')
+				default:
+					value = append(value, s[i])
+				}
+				continue
+			}
+			value = append(value, s[i])
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("unterminated label value in %q", s)
+		}
+		i++
+		labels = append(labels, Label{Name: name, Value: string(value)})
+	}
+}
+
+// parseValue parses sample value including NaN and infinities.
+func parseValue(s string) (float64, error) {
+	switch s {
+	case "NaN":
+		return math.NaN(), nil
+	case "+Inf":
+		return math.Inf(1), nil
+	case "-Inf":
+		return math.Inf(-1), nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,59 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package push
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	text := `# HELP mysql_up Whether the MySQL server is up.
+# TYPE mysql_up gauge
+mysql_up 1
+mysql_info{version="5.7.21",comment="quoted \"x\", a\\b"} 1 1500000000000
+node_load1 +Inf
+node_load5{ } NaN
+`
+	list, err := Parse(strings.NewReader(text))
+	assert.Nil(t, err)
+	if !assert.Len(t, list, 4) {
+		return
+	}
+	assert.Equal(t, Series{Labels: []Label{{"__name__", "mysql_up"}}, Value: 1}, list[0])
+	assert.Equal(t, Series{
+		Labels: []Label{
+			{"__name__", "mysql_info"},
+			{"comment", `quoted "x", a\b`},
+			{"version", "5.7.21"},
+		},
+		Value:     1,
+		Timestamp: 1500000000000,
+	}, list[1])
+	assert.True(t, math.IsInf(list[2].Value, 1))
+	assert.True(t, math.IsNaN(list[3].Value))
+	assert.Len(t, list[3].Labels, 1)
+
+	for _, line := range []string{"{a=\"b\"} 1", "mysql_up", "mysql_up abc", "mysql_up{a=b} 1", "mysql_up{a=\"b\" 1", "mysql_up 1 2 3"} {
+		_, err := Parse(strings.NewReader(line))
+		assert.Error(t, err, line)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package push
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Queue buffers remote write requests on disk while PMM Server is not reachable.
+type Queue struct {
+	// Dir keeps one file per request.
+	Dir string
+	// MaxBytes limits the total size of requests, the oldest ones are dropped first.
+	MaxBytes int64
+}
+
+// Push stores request in the queue.
+func (q *Queue) Push(data []byte) error {
+	if err := os.MkdirAll(q.Dir, 0700); err != nil {
+		return err
+	}
+	name := filepath.Join(q.Dir, fmt.Sprintf("%020d.req", time.Now().UnixNano()))
+	if err := ioutil.WriteFile(name+".tmp", data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	return q.trim()
+}
+
+// Len returns number of requests in the queue.
+func (q *Queue) Len() int {
+	files, _ := q.files()
+	return len(files)
+}
+
+// Flush sends queued requests from the oldest one and removes sent requests.
+// It stops on the first error.
+func (q *Queue) Flush(send func(data []byte) error) error {
+	files, err := q.files()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			return err
+		}
+		if err := send(data); err != nil {
+			return err
+		}
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type queueFile struct {
+	path string
+	size int64
+}
+
+// files returns queued requests from the oldest one.
+func (q *Queue) files() ([]queueFile, error) {
+	paths, err := filepath.Glob(filepath.Join(q.Dir, "*.req"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	files := make([]queueFile, 0, len(paths))
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		files = append(files, queueFile{path: p, size: fi.Size()})
+	}
+	return files, nil
+}
+
+// trim drops the oldest requests exceeding MaxBytes.
+func (q *Queue) trim() error {
+	if q.MaxBytes <= 0 {
+		return nil
+	}
+	files, err := q.files()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	for _, f := range files {
+		if total <= q.MaxBytes {
+			break
+		}
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		total -= f.size
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package push
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Field numbers and wire types of Prometheus remote write protobuf messages:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// EncodeWriteRequest returns snappy compressed protobuf WriteRequest with the given series,
+// ready to be sent to remote write endpoint.
+func EncodeWriteRequest(series []Series) []byte {
+	return snappyEncode(marshalWriteRequest(series))
+}
+
+// marshalWriteRequest returns protobuf encoded WriteRequest.
+func marshalWriteRequest(series []Series) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			var label []byte
+			label = appendBytesField(label, 1, []byte(l.Name))
+			label = appendBytesField(label, 2, []byte(l.Value))
+			ts = appendBytesField(ts, 1, label)
+		}
+
+		var sample []byte
+		sample = appendKey(sample, 1, wireFixed64)
+		sample = appendFixed64(sample, math.Float64bits(s.Value))
+		sample = appendKey(sample, 2, wireVarint)
+		sample = appendVarint(sample, uint64(s.Timestamp))
+		ts = appendBytesField(ts, 2, sample)
+
+		req = appendBytesField(req, 1, ts)
+	}
+	return req
+}
+
+func appendKey(b []byte, field int, wireType int) []byte {
+	return appendVarint(b, uint64(field<<3|wireType))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// snappyEncode returns data in snappy block format required by remote write.
+// Data is stored as literals without compression, it is a valid snappy block any decoder accepts.
+func snappyEncode(data []byte) []byte {
+	const maxLiteral = 1 << 16
+	b := appendVarint(make([]byte, 0, len(data)+len(data)/maxLiteral*3+13), uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxLiteral {
+			n = maxLiteral
+		}
+		switch {
+		case n <= 60:
+			b = append(b, byte(n-1)<<2)
+		case n <= 1<<8:
+			b = append(b, 60<<2, byte(n-1))
+		default:
+			b = append(b, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
@@ -0,0 +1,78 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package push
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarshalWriteRequest(t *testing.T) {
+	b := marshalWriteRequest([]Series{{
+		Labels:    []Label{{"__name__", "up"}},
+		Value:     1,
+		Timestamp: 1000,
+	}})
+	expected := []byte{
+		0x0a, 0x1e, // timeseries, 30 bytes
+		0x0a, 0x0e, // labels, 14 bytes
+		0x0a, 0x08, '_', '_', 'n', 'a', 'm', 'e', '_', '_', // name
+		0x12, 0x02, 'u', 'p', // value
+		0x12, 0x0c, // samples, 12 bytes
+		0x09, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f, // value 1.0
+		0x10, 0xe8, 0x07, // timestamp 1000
+	}
+	assert.Equal(t, expected, b)
+}
+
+func TestSnappyEncode(t *testing.T) {
+	for _, n := range []int{0, 1, 60, 61, 256, 257, 1 << 16, 1<<16 + 1, 200000} {
+		data := bytes.Repeat([]byte{'x'}, n)
+		assert.Equal(t, data, snappyDecodeLiterals(t, snappyEncode(data)), "%d bytes", n)
+	}
+}
+
+// snappyDecodeLiterals decodes snappy block consisting of literals only.
+func snappyDecodeLiterals(t *testing.T, b []byte) []byte {
+	length, n := binary.Uvarint(b)
+	b = b[n:]
+	out := []byte{}
+	for len(b) > 0 {
+		tag := b[0]
+		if !assert.Equal(t, byte(0), tag&3, "literal tag expected") {
+			return nil
+		}
+		l := int(tag>>2) + 1
+		b = b[1:]
+		switch tag >> 2 {
+		case 60:
+			l = int(b[0]) + 1
+			b = b[1:]
+		case 61:
+			l = int(b[0]) | int(b[1])<<8 + 1
+			b = b[2:]
+		}
+		out = append(out, b[:l]...)
+		b = b[l:]
+	}
+	assert.Equal(t, int(length), len(out))
+	return out
+}
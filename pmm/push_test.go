@@ -0,0 +1,93 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/percona/pmm-client/pmm/plugin/exporter"
+	"github.com/percona/pmm-client/pmm/push"
+	"github.com/percona/pmm-client/tests/fakeapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPushTargets(t *testing.T) {
+	api := fakeapi.New()
+	api.AppendConsulV1CatalogNode("node1", consul.CatalogNode{
+		Node: &consul.Node{Address: "127.0.0.1"},
+		Services: map[string]*consul.AgentService{
+			"mysql:metrics-db01": {Service: "mysql:metrics", Port: 42002, Tags: []string{"alias_db01", "scheme_https"}},
+			"linux:metrics-db01": {Service: "linux:metrics", Port: 42000, Tags: []string{"alias_db01"}},
+			"mysql:queries-db01": {Service: "mysql:queries", Tags: []string{"alias_db01"}},
+		},
+	})
+	_, host, port := api.Start()
+	defer api.Close()
+
+	a := &Admin{Config: &Config{ClientName: "node1", BindAddress: "127.0.0.1"}}
+	var err error
+	a.consulAPI, err = consul.NewClient(&consul.Config{Address: fmt.Sprintf("%s:%s", host, port)})
+	assert.Nil(t, err)
+
+	targets, err := a.pushTargets(time.Second)
+	assert.Nil(t, err)
+	// Every MySQL endpoint has own job, so their up metrics don't collide.
+	assert.Equal(t, []push.Target{
+		{URL: "http://127.0.0.1:42000/metrics", Labels: map[string]string{"job": "linux", "instance": "db01"}, Interval: time.Second},
+		{URL: "https://127.0.0.1:42002/metrics-hr", Labels: map[string]string{"job": "mysql-hr", "instance": "db01"}, Interval: time.Second},
+		{URL: "https://127.0.0.1:42002/metrics-lr", Labels: map[string]string{"job": "mysql-lr", "instance": "db01"}, Interval: 6 * time.Second},
+		{URL: "https://127.0.0.1:42002/metrics-mr", Labels: map[string]string{"job": "mysql-mr", "instance": "db01"}, Interval: time.Second},
+	}, targets)
+}
+
+func TestTagPushedServices(t *testing.T) {
+	assert.Equal(t, []string{"alias_db01", PushTag}, withPushTag([]string{"alias_db01"}, true))
+	assert.Equal(t, []string{"alias_db01", PushTag}, withPushTag([]string{PushTag, "alias_db01"}, true))
+	assert.Equal(t, []string{"alias_db01"}, withPushTag([]string{"alias_db01", PushTag}, false))
+
+	api := fakeapi.New()
+	api.AppendConsulV1CatalogNode("node1", consul.CatalogNode{
+		Node: &consul.Node{Address: "127.0.0.1"},
+		Services: map[string]*consul.AgentService{
+			"mysql:metrics-db01": {ID: "mysql:metrics-db01", Service: "mysql:metrics", Tags: []string{"alias_db01"}},
+			"linux:metrics-db01": {ID: "linux:metrics-db01", Service: "linux:metrics", Tags: []string{"alias_db01", PushTag}},
+			"mysql:queries-db01": {ID: "mysql:queries-db01", Service: "mysql:queries", Tags: []string{"alias_db01"}},
+		},
+	})
+	api.AppendConsulV1CatalogRegister()
+	_, host, port := api.Start()
+	defer api.Close()
+
+	a := &Admin{Config: &Config{ClientName: "node1", ClientAddress: "127.0.0.1"}}
+	var err error
+	a.consulAPI, err = consul.NewClient(&consul.Config{Address: fmt.Sprintf("%s:%s", host, port)})
+	assert.Nil(t, err)
+
+	// Only metrics service without the tag is registered again.
+	assert.Nil(t, a.tagPushedServices(true))
+	assert.Equal(t, []string{"PUT /v1/catalog/register"}, api.Requests()[1:])
+
+	// New metrics services are tagged in push mode.
+	a.ServiceName = "db02"
+	a.Config.Push = true
+	m := exporter.New(&exporter.Manifest{Name: "redis"}, "", "")
+	assert.Equal(t, []string{"alias_db02", "scheme_https", PushTag}, a.metricsTags(m, false))
+}
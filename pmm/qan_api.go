@@ -22,6 +22,7 @@ import (
 	"compress/gzip"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -34,25 +35,25 @@ import (
 )
 
 type API struct {
-	headers     map[string]string
-	hostname    string
-	insecureSSL bool
-	apiTimeout  time.Duration
-	debug       bool // turns on logging requests with std logger
+	headers    map[string]string
+	hostname   string
+	tlsConfig  *tls.Config
+	apiTimeout time.Duration
+	debug      bool // turns on logging requests with std logger
 }
 
 type apiError struct {
 	Error string
 }
 
-func NewAPI(insecureFlag bool, timeout time.Duration, debug bool) *API {
+func NewAPI(tlsConfig *tls.Config, timeout time.Duration, debug bool) *API {
 	hostname, _ := os.Hostname()
 	a := &API{
-		headers:     nil,
-		hostname:    hostname,
-		insecureSSL: insecureFlag,
-		apiTimeout:  timeout,
-		debug:       debug,
+		headers:    nil,
+		hostname:   hostname,
+		tlsConfig:  tlsConfig,
+		apiTimeout: timeout,
+		debug:      debug,
 	}
 	return a
 }
@@ -115,16 +116,16 @@ func (a *API) Get(url string) (*http.Response, []byte, error) {
 		buf := new(bytes.Buffer)
 		gz, err := gzip.NewReader(resp.Body)
 		if err != nil {
-			return nil, nil, fmt.Errorf("GET %s: gzip.NewReader: %s", url, err)
+			return nil, nil, fmt.Errorf("GET %s: gzip.NewReader: %s", utils.Redact(url), err)
 		}
 		if _, err := io.Copy(buf, gz); err != nil {
-			return resp, nil, fmt.Errorf("GET %s: io.Copy: %s", url, err)
+			return resp, nil, fmt.Errorf("GET %s: io.Copy: %s", utils.Redact(url), err)
 		}
 		data = buf.Bytes()
 	} else {
 		data, err = ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return resp, nil, fmt.Errorf("GET %s: ioutil.ReadAll: %s", url, err)
+			return resp, nil, fmt.Errorf("GET %s: ioutil.ReadAll: %s", utils.Redact(url), err)
 		}
 	}
 
@@ -154,16 +155,13 @@ func (a *API) Error(method, url string, gotStatusCode, expectedStatusCode int, c
 			errMsg += ": " + apiErr.Error
 		}
 	}
-	return fmt.Errorf(errMsg)
+	return errors.New(utils.Redact(errMsg))
 }
 
 // NewClient creates new *http.Client tailored for this API
 func (a *API) NewClient() *http.Client {
-	transport := &http.Transport{}
-	if a.insecureSSL {
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: true,
-		}
+	transport := &http.Transport{
+		TLSClientConfig: a.tlsConfig,
 	}
 	client := &http.Client{
 		Timeout:   a.apiTimeout,
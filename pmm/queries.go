@@ -26,7 +26,9 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	consul "github.com/hashicorp/consul/api"
@@ -37,73 +39,90 @@ import (
 	pc "github.com/percona/pmm/proto/config"
 )
 
+// queriesM serializes changes of queries services, they share qan-agent and its config.
+var queriesM sync.Mutex
+
 // AddQueries add instance to Query Analytics.
+// Steps already completed are undone if adding fails.
 func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries) (*plugin.Info, error) {
-	info, err := q.Init(ctx, a.Config.MySQLPassword)
+	info, err := q.Init(ctx, a.pmmUserPassword(q.Name()))
 	if err != nil {
+		return nil, utils.RedactError(err)
+	}
+
+	if err := a.storePMMUserPassword(q.Name(), info.PMMUserPassword); err != nil {
 		return nil, err
 	}
 
-	if info.PMMUserPassword != "" {
-		a.Config.MySQLPassword = info.PMMUserPassword
-		err := a.writeConfig()
-		if err != nil {
-			return nil, err
-		}
+	queriesM.Lock()
+	defer queriesM.Unlock()
+
+	var undo rollback
+	if err := a.addQueries(ctx, q, info, &undo); err != nil {
+		// Errors of plugins and APIs may include DSN or server URL.
+		return nil, utils.RedactError(undo.run(err))
 	}
+	return info, nil
+}
 
+// addQueries adds queries service of initialized plugin recording undo function for every completed step.
+func (a *Admin) addQueries(ctx context.Context, q plugin.Queries, info *plugin.Info, undo *rollback) error {
 	serviceType := fmt.Sprintf("%s:queries", q.Name())
 
+	if err := a.Config.checkQANTLS(); err != nil {
+		return err
+	}
+
 	// Check if we have already this service on Consul.
 	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if consulSvc != nil {
-		return nil, ErrDuplicate
+		return ErrDuplicate
 	}
 
 	if err := a.checkGlobalDuplicateService(serviceType, a.ServiceName); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Now check if there are any existing services of given service type.
 	consulSvc, err = a.getConsulService(serviceType, "")
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Register agent if config file does not exist.
 	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", AgentBaseDir)
 	if !FileExists(agentConfigFile) {
 		if err := a.registerAgent(); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
 	agentID, err := getAgentID(agentConfigFile)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// Get parent_uuid of agent instance.
 	parentUUID, err := a.getAgentInstance(agentID)
 	if err == errNoInstance {
 		// If agent is orphaned, let's re-register it.
 		if err := a.registerAgent(); err != nil {
-			return nil, err
+			return err
 		}
 		// Get new agent id.
 		agentID, err = getAgentID(agentConfigFile)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		// Get parent_uuid again.
 		parentUUID, err = a.getAgentInstance(agentID)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	} else if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Check if related instance exists or try to re-use the existing one.
@@ -112,18 +131,25 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries) (*plugin.Info,
 		// Create new instance on QAN.
 		instance, err = a.createInstance(q.InstanceTypeName(), *info, parentUUID)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	} else if err != nil {
-		return nil, err
+		return err
 	}
+	undo.add(func() error {
+		return a.deleteInstance(instance.UUID)
+	})
 
 	// Write instance config for qan-agent with real DSN.
 	instance.DSN = info.DSN
 	bytes, _ := json.MarshalIndent(instance, "", "    ")
-	if err := ioutil.WriteFile(fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instance.UUID), bytes, 0600); err != nil {
-		return nil, err
+	instanceFile := fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, instance.UUID)
+	if err := ioutil.WriteFile(instanceFile, bytes, 0600); err != nil {
+		return err
 	}
+	undo.add(func() error {
+		return os.Remove(instanceFile)
+	})
 
 	// Choose port.
 	port := 0
@@ -132,21 +158,21 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries) (*plugin.Info,
 	if consulSvc == nil {
 		// Install and start service via platform service manager.
 		// We have to run agent before adding it to QAN.
-		svcConfig := &service.Config{
-			Name:        fmt.Sprintf("pmm-%s-queries-%d", q.Name(), port),
-			DisplayName: "PMM Query Analytics agent",
-			Description: "PMM Query Analytics agent",
-			Executable:  fmt.Sprintf("%s/bin/percona-qan-agent", AgentBaseDir),
-			Arguments:   a.Args,
+		svcConfig, err := a.qanServiceConfig(fmt.Sprintf("pmm-%s-queries-%d", q.Name(), port))
+		if err != nil {
+			return err
 		}
 		if err := installService(svcConfig); err != nil {
-			return nil, err
+			return err
 		}
+		undo.add(func() error {
+			return uninstallService(svcConfig.Name)
+		})
 	} else {
 		port = consulSvc.Port
 		// Ensure qan-agent is started if service exists, otherwise it won't be enabled for QAN.
 		if err := startService(fmt.Sprintf("pmm-%s-queries-%d", q.Name(), port)); err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -155,15 +181,18 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries) (*plugin.Info,
 	qanConfig.UUID = instance.UUID
 	qanConfig.Interval = 60
 	if err := a.startQAN(agentID, qanConfig); err != nil {
-		return nil, err
+		return err
 	}
+	undo.add(func() error {
+		return a.stopQAN(agentID, instance.UUID)
+	})
 
 	tags := []string{
 		fmt.Sprintf("alias_%s", a.ServiceName),
 	}
 	// For existing service, we append a new alias_ tag.
 	if consulSvc != nil {
-		tags = append(consulSvc.Tags, tags...)
+		tags = append(append([]string{}, consulSvc.Tags...), tags...)
 	}
 
 	// Add service to Consul.
@@ -180,17 +209,37 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries) (*plugin.Info,
 		Service: &srv,
 	}
 	if _, err := a.consulAPI.Catalog().Register(&reg, nil); err != nil {
-		return nil, err
+		return err
+	}
+	if consulSvc == nil {
+		undo.add(func() error {
+			return a.deregisterService(serviceID)
+		})
+	} else {
+		// Restore the service without the new alias_ tag.
+		undo.add(func() error {
+			reg := consul.CatalogRegistration{
+				Node:    a.Config.ClientName,
+				Address: a.Config.ClientAddress,
+				Service: consulSvc,
+			}
+			_, err := a.consulAPI.Catalog().Register(&reg, nil)
+			return err
+		})
 	}
 
 	// Add info to Consul KV.
+	undo.add(func() error {
+		_, err := a.consulAPI.KV().DeleteTree(fmt.Sprintf("%s/%s/%s/", a.Config.ClientName, serviceID, a.ServiceName), nil)
+		return err
+	})
 	d := &consul.KVPair{
 		Key:   fmt.Sprintf("%s/%s/%s/dsn", a.Config.ClientName, serviceID, a.ServiceName),
 		Value: []byte(utils.SanitizeDSN(info.DSN)),
 	}
 	_, err = a.consulAPI.KV().Put(d, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	d = &consul.KVPair{
 		Key:   fmt.Sprintf("%s/%s/%s/qan_%s_uuid", a.Config.ClientName, serviceID, a.ServiceName, q.Name()),
@@ -198,10 +247,17 @@ func (a *Admin) AddQueries(ctx context.Context, q plugin.Queries) (*plugin.Info,
 	}
 	_, err = a.consulAPI.KV().Put(d, nil)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	undo.add(func() error {
+		removeOptions(serviceType, a.ServiceName)
+		return nil
+	})
+	if err := a.storeOptions(serviceType, serviceID); err != nil {
+		return err
 	}
 
-	return info, nil
+	return nil
 }
 
 // RemoveQueries remove instance from QAN.
@@ -253,6 +309,7 @@ func (a *Admin) RemoveQueries(name string) error {
 	if err != nil {
 		return err
 	}
+	removeOptions(serviceType, a.ServiceName)
 
 	// Remove queries service from Consul only if we have only 1 tag alias_ (the instance in question).
 	var tags []string
@@ -293,6 +350,91 @@ func (a *Admin) RemoveQueries(name string) error {
 	return nil
 }
 
+// UpdateQueries replaces QAN options of existing queries service without creating a new QAN instance.
+func (a *Admin) UpdateQueries(ctx context.Context, q plugin.Queries) (*plugin.Info, error) {
+	info, err := q.Init(ctx, a.pmmUserPassword(q.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.storePMMUserPassword(q.Name(), info.PMMUserPassword); err != nil {
+		return nil, err
+	}
+
+	serviceType := fmt.Sprintf("%s:queries", q.Name())
+
+	// Check if we have this service on Consul.
+	consulSvc, err := a.getConsulService(serviceType, a.ServiceName)
+	if err != nil {
+		return nil, err
+	}
+	if consulSvc == nil {
+		return nil, ErrNoService
+	}
+
+	// Ensure qan-agent is started, otherwise it will be an error to send commands to it.
+	if err := startService(fmt.Sprintf("pmm-%s-queries-%d", q.Name(), consulSvc.Port)); err != nil {
+		return nil, err
+	}
+
+	// Get UUID of the instance the agent is monitoring from KV.
+	prefix := fmt.Sprintf("%s/%s/%s/", a.Config.ClientName, consulSvc.ID, a.ServiceName)
+	key := fmt.Sprintf("%sqan_%s_uuid", prefix, q.Name())
+	data, _, err := a.consulAPI.KV().Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("can't get key %s", key)
+	}
+	uuid := string(data.Value)
+
+	// Rewrite instance config for qan-agent with the new DSN.
+	instanceFile := fmt.Sprintf("%s/instance/%s.json", AgentBaseDir, uuid)
+	jsonData, err := ioutil.ReadFile(instanceFile)
+	if err != nil {
+		return nil, err
+	}
+	var instance proto.Instance
+	if err := json.Unmarshal(jsonData, &instance); err != nil {
+		return nil, err
+	}
+	instance.DSN = info.DSN
+	bytes, _ := json.MarshalIndent(instance, "", "    ")
+	if err := ioutil.WriteFile(instanceFile, bytes, 0600); err != nil {
+		return nil, err
+	}
+
+	// Restart QAN for this instance with the new config.
+	agentConfigFile := fmt.Sprintf("%s/config/agent.conf", AgentBaseDir)
+	agentID, err := getAgentID(agentConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.stopQAN(agentID, uuid); err != nil {
+		return nil, err
+	}
+	qanConfig := q.Config()
+	qanConfig.UUID = uuid
+	qanConfig.Interval = 60
+	if err := a.startQAN(agentID, qanConfig); err != nil {
+		return nil, err
+	}
+
+	d := &consul.KVPair{
+		Key:   prefix + "dsn",
+		Value: []byte(utils.SanitizeDSN(info.DSN)),
+	}
+	if _, err := a.consulAPI.KV().Put(d, nil); err != nil {
+		return nil, err
+	}
+	if err := a.storeOptions(serviceType, consulSvc.ID); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
 // getInstance get or re-use instance from QAN API and return it.
 func (a *Admin) getInstance(subsystem, name, parentUUID string) (proto.Instance, error) {
 	var in proto.Instance
@@ -521,7 +663,13 @@ func (a *Admin) registerAgent() error {
 			fmt.Sprintf("-server-pass=%s", a.Config.ServerPassword))
 	}
 	args = append(args, fmt.Sprintf("%s/%s", a.serverURL, qanAPIBasePath))
-	if _, err := exec.Command(path, args...).Output(); err != nil {
+	env, err := a.qanEnvironment()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), env...)
+	if _, err := cmd.Output(); err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			return fmt.Errorf("problem with agent registration on QAN API: %s\n%s", err, exitErr.Stderr)
 		}
@@ -530,6 +678,80 @@ func (a *Admin) registerAgent() error {
 	return nil
 }
 
+// errQANTLS is returned if QAN agent can't connect to PMM Server with the configured TLS options.
+var errQANTLS = errors.New("Query Analytics agent doesn't support client certificate and TLS server name. " +
+	"Configure PMM server without --server-cert-file and --server-tls-name to use Query Analytics.")
+
+// checkQANTLS returns an error if QAN agent can't use TLS options of PMM Server connection.
+func (c *Config) checkQANTLS() error {
+	if c.ServerCertFile != "" || c.ServerTLSName != "" {
+		return errQANTLS
+	}
+	return nil
+}
+
+// qanEnvironment returns environment of QAN agent and its installer. They can't be configured with CA bundle
+// of PMM Server, so the bundle is copied to the directory their Go TLS reads via SSL_CERT_DIR, with the system ones.
+func (a *Admin) qanEnvironment() ([]string, error) {
+	dir := filepath.Join(AgentBaseDir, "certs")
+	if a.Config.ServerCAFile == "" {
+		os.RemoveAll(dir)
+		return nil, nil
+	}
+	pem, err := ioutil.ReadFile(a.Config.ServerCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA bundle: %s", err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pmm-server-ca.pem"), pem, 0644); err != nil {
+		return nil, err
+	}
+	return []string{"SSL_CERT_DIR=" + strings.Join([]string{"/etc/ssl/certs", "/etc/pki/tls/certs", dir}, ":")}, nil
+}
+
+// qanServiceConfig returns config of QAN agent service with the given name.
+func (a *Admin) qanServiceConfig(name string) (*service.Config, error) {
+	env, err := a.qanEnvironment()
+	if err != nil {
+		return nil, err
+	}
+	return &service.Config{
+		Name:        name,
+		DisplayName: "PMM Query Analytics agent",
+		Description: "PMM Query Analytics agent",
+		Executable:  fmt.Sprintf("%s/bin/percona-qan-agent", AgentBaseDir),
+		Arguments:   a.Args,
+		Environment: env,
+	}, nil
+}
+
+// reinstallQAN reinstalls QAN agent services, so they get the current TLS options of PMM Server connection.
+func (a *Admin) reinstallQAN() error {
+	for _, svcType := range svcTypes(plugin.KindQueries) {
+		consulSvc, err := a.getConsulService(svcType, "")
+		if err != nil {
+			return err
+		}
+		if consulSvc == nil {
+			continue
+		}
+		name := fmt.Sprintf("pmm-%s-%d", strings.Replace(svcType, ":", "-", 1), consulSvc.Port)
+		svcConfig, err := a.qanServiceConfig(name)
+		if err != nil {
+			return err
+		}
+		if err := uninstallService(name); err != nil {
+			return fmt.Errorf("Unable to reinstall %s service: %s", svcType, err)
+		}
+		if err := installService(svcConfig); err != nil {
+			return fmt.Errorf("Unable to reinstall %s service: %s", svcType, err)
+		}
+	}
+	return nil
+}
+
 // getProtoQAN reads instance from QAN config file.
 func getProtoQAN(configFile string) (*pc.QAN, error) {
 	jsonData, err := ioutil.ReadFile(configFile)
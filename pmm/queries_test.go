@@ -18,106 +18,90 @@
 package pmm
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
-	"os"
+	"path/filepath"
 	"testing"
-	"time"
 
-	"github.com/percona/pmm-client/tests/fakeapi"
-	pc "github.com/percona/pmm/proto/config"
-	protocfg "github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto"
 	"github.com/stretchr/testify/assert"
 )
 
-func TestGetAgentID(t *testing.T) {
-	// create tmpfile
-	f, err := ioutil.TempFile("", "")
-	assert.Nil(t, err)
+func TestUpdateQueries(t *testing.T) {
+	a, api, services, teardown := setupRollbackTest(t)
+	defer teardown()
+	ctx := context.Background()
 
-	// remove it after test finishes
-	defer os.Remove(f.Name())
+	a.Options = map[string]string{"user": "root", "password": "secret"}
+	_, err := a.AddQueries(ctx, fakeQueries{})
+	assert.Nil(t, err)
+	flags, _, err := a.StoredOptions("mysql:queries")
+	assert.Nil(t, err)
+	assert.Equal(t, a.Options, flags)
+	pair, _, err := a.consulAPI.KV().Get("node1/mysql:queries-0/db01/options", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"user":"root"}`, string(pair.Value))
 
-	t.Run("correct file", func(t *testing.T) {
-		config := &protocfg.Agent{
-			UUID: "qwe123",
-		}
+	// QAN instance keeps its UUID and gets the new DSN.
+	a.Options = map[string]string{"user": "pmm", "password": "changed"}
+	_, err = a.UpdateQueries(ctx, fakeQueries{dsn: "pmm:changed@tcp(localhost:3306)/"})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"pmm-mysql-queries-0": true}, services.installed)
+	data, err := ioutil.ReadFile(filepath.Join(AgentBaseDir, "instance", "mysql1.json"))
+	assert.Nil(t, err)
+	var instance proto.Instance
+	assert.Nil(t, json.Unmarshal(data, &instance))
+	assert.Equal(t, "mysql1", instance.UUID)
+	assert.Equal(t, "pmm:changed@tcp(localhost:3306)/", instance.DSN)
+	assert.NotContains(t, api.Requests(), "DELETE /qan-api/instances/mysql1")
 
-		bytes, err := json.Marshal(config)
-		assert.Nil(t, err)
-		err = ioutil.WriteFile(f.Name(), bytes, 0600)
-		assert.Nil(t, err)
+	flags, _, err = a.StoredOptions("mysql:queries")
+	assert.Nil(t, err)
+	assert.Equal(t, a.Options, flags)
+	pair, _, err = a.consulAPI.KV().Get("node1/mysql:queries-0/db01/dsn", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "pmm:***@tcp(localhost:3306)", string(pair.Value))
 
-		uuid, err := getAgentID(f.Name())
-		assert.Nil(t, err)
-		assert.Equal(t, config.UUID, uuid)
-	})
+	a.ServiceName = "db02"
+	_, err = a.UpdateQueries(ctx, fakeQueries{})
+	assert.Equal(t, ErrNoService, err)
+}
 
-	t.Run("incorrect file", func(t *testing.T) {
-		config := &protocfg.Agent{}
+func TestQANTLS(t *testing.T) {
+	a, _, services, teardown := setupRollbackTest(t)
+	defer teardown()
 
-		bytes, err := json.Marshal(config)
-		assert.Nil(t, err)
-		err = ioutil.WriteFile(f.Name(), bytes, 0600)
-		assert.Nil(t, err)
+	// Without CA bundle QAN agent trusts system CA certificates only.
+	env, err := a.qanEnvironment()
+	assert.Nil(t, err)
+	assert.Empty(t, env)
 
-		uuid, err := getAgentID(f.Name())
-		assert.Error(t, err)
-		assert.Equal(t, config.UUID, uuid)
-	})
-}
+	// CA bundle is copied to the directory read by Go TLS of QAN agent.
+	caFile := filepath.Join(AgentBaseDir, "ca.pem")
+	assert.Nil(t, ioutil.WriteFile(caFile, []byte("PEM"), 0600))
+	a.Config.ServerCAFile = caFile
+	env, err = a.qanEnvironment()
+	assert.Nil(t, err)
+	certDir := filepath.Join(AgentBaseDir, "certs")
+	assert.Equal(t, []string{"SSL_CERT_DIR=/etc/ssl/certs:/etc/pki/tls/certs:" + certDir}, env)
+	data, err := ioutil.ReadFile(filepath.Join(certDir, "pmm-server-ca.pem"))
+	assert.Nil(t, err)
+	assert.Equal(t, "PEM", string(data))
 
-func TestAdmin_StartStopQAN(t *testing.T) {
-	t.Parallel()
-
-	agentID := "123"
-
-	// create fakeapi
-	api := fakeapi.New()
-	api.AppendQanAPIAgents(agentID)
-	defer api.Close()
-	_, host, port := api.Start()
-
-	exampleQueries := true
-	// create qan config
-	qanConfig := pc.QAN{
-		UUID:           "xyz",
-		Interval:       60,
-		ExampleQueries: &exampleQueries,
-	}
-
-	// create pmm-admin instance
-	admin := &Admin{}
-	insecureFlag := true
-	timeout := 1 * time.Second
-	debug := false
-	admin.qanAPI = NewAPI(insecureFlag, timeout, debug)
-
-	// point pmm-admin to fake http api
-	admin.serverURL = fmt.Sprintf("%s:%s", host, port)
-	scheme := "http"
-	authStr := ""
-	admin.serverURL = fmt.Sprintf("%s://%s%s:%s", scheme, authStr, host, port)
-
-	t.Run("startQAN", func(t *testing.T) {
-		err := admin.startQAN(agentID, qanConfig)
-		assert.Nil(t, err)
-	})
-
-	t.Run("stopQAN", func(t *testing.T) {
-		err := admin.stopQAN(agentID, "qwe")
-		assert.Nil(t, err)
-	})
-}
+	_, err = a.AddQueries(context.Background(), fakeQueries{})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{"pmm-mysql-queries-0": true}, services.installed)
 
-func TestGetQueriesOptions(t *testing.T) {
-	config, err := getProtoQAN("testdata/qan-2b6c3eb3669943c160502874036968ba.conf")
-	assert.NoError(t, err)
-	opts := getQueriesOptions(config)
-	expected := []string{
-		"query_source=perfschema",
-		"query_examples=true",
-	}
-	assert.Equal(t, expected, opts)
+	a.Config.ServerCAFile = ""
+	env, err = a.qanEnvironment()
+	assert.Nil(t, err)
+	assert.Empty(t, env)
+	assert.False(t, FileExists(certDir))
+
+	// Client certificate and TLS name can't be used by QAN agent.
+	a.ServiceName = "db02"
+	a.Config.ServerTLSName = "pmm.example.com"
+	_, err = a.AddQueries(context.Background(), fakeQueries{})
+	assert.Equal(t, errQANTLS, err)
 }
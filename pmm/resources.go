@@ -0,0 +1,264 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	service "github.com/percona/kardianos-service"
+)
+
+// resourcesDropIn is a name of systemd drop-in file with resource limits of service.
+const resourcesDropIn = "pmm-resources.conf"
+
+var memoryLimitRegex = regexp.MustCompile(`^([1-9][0-9]*)([KMGT]?)$`)
+
+// Resources limits resources of exporter and sets the user it runs as.
+// Zero values mean no limit.
+type Resources struct {
+	// MemoryLimit is a memory limit with optional K, M, G or T suffix, e.g. 512M.
+	MemoryLimit string
+	// CPUQuota is a CPU time limit in percent of one CPU.
+	CPUQuota int
+	// Nice is a scheduling priority from -20 to 19.
+	Nice int
+	// RunAsUser is a user to run exporter as instead of root.
+	RunAsUser string
+}
+
+// IsZero returns true if no limits are set.
+func (r Resources) IsZero() bool {
+	return r == Resources{}
+}
+
+// Validate checks values of the limits and that the user exists.
+func (r Resources) Validate() error {
+	if r.MemoryLimit != "" && !memoryLimitRegex.MatchString(r.MemoryLimit) {
+		return fmt.Errorf("invalid memory limit %s, should be a number with optional K, M, G or T suffix", r.MemoryLimit)
+	}
+	if r.CPUQuota < 0 {
+		return fmt.Errorf("invalid CPU quota %d, should be a positive number of percent", r.CPUQuota)
+	}
+	if r.Nice < -20 || r.Nice > 19 {
+		return fmt.Errorf("invalid nice %d, should be between -20 and 19", r.Nice)
+	}
+	if r.RunAsUser != "" {
+		if _, err := user.Lookup(r.RunAsUser); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryBytes returns memory limit in bytes.
+func (r Resources) memoryBytes() uint64 {
+	m := memoryLimitRegex.FindStringSubmatch(r.MemoryLimit)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(m[1], 10, 64)
+	if m[2] != "" {
+		n <<= 10 * uint(strings.Index("KMGT", m[2])+1)
+	}
+	return n
+}
+
+// kv returns limits to be stored in Consul KV of the service, they are shown by `pmm-admin list`.
+func (r Resources) kv() map[string][]byte {
+	kv := map[string][]byte{}
+	if r.MemoryLimit != "" {
+		kv["memory_limit"] = []byte(r.MemoryLimit)
+	}
+	if r.CPUQuota != 0 {
+		kv["cpu_quota"] = []byte(fmt.Sprintf("%d%%", r.CPUQuota))
+	}
+	if r.Nice != 0 {
+		kv["nice"] = []byte(strconv.Itoa(r.Nice))
+	}
+	if r.RunAsUser != "" {
+		kv["run_as_user"] = []byte(r.RunAsUser)
+	}
+	return kv
+}
+
+// resourcesFromKV returns limits stored in Consul KV of the service by kv.
+func resourcesFromKV(prefix string, pairs consul.KVPairs) Resources {
+	var r Resources
+	for _, kvp := range pairs {
+		value := string(kvp.Value)
+		switch strings.TrimPrefix(kvp.Key, prefix) {
+		case "memory_limit":
+			r.MemoryLimit = value
+		case "cpu_quota":
+			r.CPUQuota, _ = strconv.Atoi(strings.TrimSuffix(value, "%"))
+		case "nice":
+			r.Nice, _ = strconv.Atoi(value)
+		case "run_as_user":
+			r.RunAsUser = value
+		}
+	}
+	return r
+}
+
+// systemdDropIn returns systemd drop-in with the limits, hardening is added as well.
+func (r Resources) systemdDropIn() string {
+	lines := []string{"[Service]"}
+	if r.RunAsUser != "" {
+		lines = append(lines, "User="+r.RunAsUser)
+	}
+	if r.MemoryLimit != "" {
+		lines = append(lines, "MemoryMax="+r.MemoryLimit)
+	}
+	if r.CPUQuota != 0 {
+		lines = append(lines, fmt.Sprintf("CPUQuota=%d%%", r.CPUQuota))
+	}
+	if r.Nice != 0 {
+		lines = append(lines, fmt.Sprintf("Nice=%d", r.Nice))
+	}
+	lines = append(lines,
+		"NoNewPrivileges=true",
+		"ProtectSystem=full",
+		"ProtectHome=read-only",
+		"ProtectKernelTunables=true",
+		"ProtectControlGroups=true",
+	)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// applyResources applies limits to the service being installed. Files referenced by the service arguments
+// are made readable for the user it runs as.
+// On systemd the limits are written to a drop-in, on other platforms commands are wrapped
+// with nice, prlimit and runuser where available.
+func applyResources(svcConfig *service.Config, r Resources, readFiles ...string) error {
+	if r.IsZero() {
+		return nil
+	}
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	if r.RunAsUser != "" {
+		if err := grantReadAccess(r.RunAsUser, readFiles...); err != nil {
+			return err
+		}
+		if Platform() != ServiceManagerSupervisor {
+			if err := createLogFile(svcConfig.Name, r.RunAsUser); err != nil {
+				return err
+			}
+		}
+	}
+
+	if Platform() == "linux-systemd" {
+		dir := dropInDir(svcConfig.Name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dir, resourcesDropIn), []byte(r.systemdDropIn()), 0644)
+	}
+
+	// Wrappers are applied from inner to outer: privileges are dropped last, so negative nice works.
+	if r.RunAsUser != "" {
+		if runtime.GOOS == "darwin" {
+			svcConfig.UserName = r.RunAsUser
+		} else if err := wrapCommand(svcConfig, "runuser", "-u", r.RunAsUser, "--"); err != nil {
+			return err
+		}
+	}
+	if r.MemoryLimit != "" {
+		if err := wrapCommand(svcConfig, "prlimit", fmt.Sprintf("--as=%d", r.memoryBytes())); err != nil {
+			log.Printf("WARNING: memory limit is not supported on %s: %s", Platform(), err)
+		}
+	}
+	if r.Nice != 0 {
+		if err := wrapCommand(svcConfig, "nice", "-n", strconv.Itoa(r.Nice)); err != nil {
+			return err
+		}
+	}
+	if r.CPUQuota != 0 {
+		log.Printf("WARNING: CPU quota is not supported on %s, ignoring it.", Platform())
+	}
+	return nil
+}
+
+// wrapCommand makes service run its executable via the given command.
+func wrapCommand(svcConfig *service.Config, command ...string) error {
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return err
+	}
+	args := append(append([]string{}, command[1:]...), svcConfig.Executable)
+	args = append(args, svcConfig.Arguments...)
+	svcConfig.Executable = path
+	svcConfig.Arguments = args
+	return nil
+}
+
+// dropInDir returns directory of systemd drop-ins of service.
+func dropInDir(name string) string {
+	dir, _ := GetServiceDirAndExtension()
+	return filepath.Join(dir, name+".service.d")
+}
+
+// removeResources removes systemd drop-in with limits of service, if any.
+func removeResources(name string) {
+	if Platform() == "linux-systemd" {
+		os.RemoveAll(dropInDir(name))
+	}
+}
+
+// grantReadAccess gives user read access to the existing files with POSIX ACL.
+func grantReadAccess(username string, files ...string) error {
+	for _, file := range files {
+		if !FileExists(file) {
+			continue
+		}
+		out, err := exec.Command("setfacl", "-m", fmt.Sprintf("u:%s:r", username), file).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("cannot give user %s read access to %s: %s %s", username, file, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// createLogFile creates log file of service owned by user, so service running as that user can append to it.
+func createLogFile(name, username string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return err
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gid, _ := strconv.Atoi(u.Gid)
+	file := filepath.Join(LogsDir, name+".log")
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Chown(file, uid, gid)
+}
@@ -0,0 +1,99 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"os/exec"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	service "github.com/percona/kardianos-service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourcesValidate(t *testing.T) {
+	assert.Nil(t, Resources{}.Validate())
+	assert.Nil(t, Resources{MemoryLimit: "512M", CPUQuota: 150, Nice: -5, RunAsUser: "root"}.Validate())
+	assert.NotNil(t, Resources{MemoryLimit: "512MB"}.Validate())
+	assert.NotNil(t, Resources{MemoryLimit: "0"}.Validate())
+	assert.NotNil(t, Resources{CPUQuota: -1}.Validate())
+	assert.NotNil(t, Resources{Nice: 20}.Validate())
+	assert.NotNil(t, Resources{RunAsUser: "no-such-user-pmm"}.Validate())
+}
+
+func TestResourcesMemoryBytes(t *testing.T) {
+	for limit, expected := range map[string]uint64{
+		"1024": 1024,
+		"4K":   4 << 10,
+		"512M": 512 << 20,
+		"2G":   2 << 30,
+		"1T":   1 << 40,
+	} {
+		assert.Equal(t, expected, Resources{MemoryLimit: limit}.memoryBytes(), limit)
+	}
+}
+
+func TestResourcesKV(t *testing.T) {
+	r := Resources{MemoryLimit: "512M", CPUQuota: 50, Nice: 5, RunAsUser: "pmm"}
+	prefix := "db01/mysql:metrics-42002/"
+	var pairs consul.KVPairs
+	for k, v := range r.kv() {
+		pairs = append(pairs, &consul.KVPair{Key: prefix + k, Value: v})
+	}
+	pairs = append(pairs, &consul.KVPair{Key: prefix + "dsn", Value: []byte("root:***@tcp(localhost:3306)/")})
+	assert.Equal(t, "50%", string(r.kv()["cpu_quota"]))
+	assert.Equal(t, r, resourcesFromKV(prefix, pairs))
+	assert.Empty(t, Resources{}.kv())
+}
+
+func TestResourcesSystemdDropIn(t *testing.T) {
+	r := Resources{MemoryLimit: "512M", CPUQuota: 50, Nice: 5, RunAsUser: "pmm"}
+	expected := `[Service]
+User=pmm
+MemoryMax=512M
+CPUQuota=50%
+Nice=5
+NoNewPrivileges=true
+ProtectSystem=full
+ProtectHome=read-only
+ProtectKernelTunables=true
+ProtectControlGroups=true
+`
+	assert.Equal(t, expected, r.systemdDropIn())
+}
+
+func TestApplyResources(t *testing.T) {
+	defer useServiceManager("")
+	assert.Nil(t, useServiceManager(ServiceManagerSupervisor))
+
+	svcConfig := &service.Config{
+		Name:       "pmm-mysql-metrics-42002",
+		Executable: "/usr/local/percona/pmm-client/mysqld_exporter",
+		Arguments:  []string{"-web.listen-address=127.0.0.1:42002"},
+	}
+	assert.Nil(t, applyResources(svcConfig, Resources{}))
+	assert.Equal(t, "/usr/local/percona/pmm-client/mysqld_exporter", svcConfig.Executable)
+
+	nice, err := exec.LookPath("nice")
+	assert.Nil(t, err)
+	assert.Nil(t, applyResources(svcConfig, Resources{Nice: 10}))
+	assert.Equal(t, nice, svcConfig.Executable)
+	assert.Equal(t, []string{"-n", "10", "/usr/local/percona/pmm-client/mysqld_exporter", "-web.listen-address=127.0.0.1:42002"}, svcConfig.Arguments)
+
+	assert.NotNil(t, applyResources(svcConfig, Resources{Nice: 42}))
+}
@@ -0,0 +1,46 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"fmt"
+)
+
+// rollback is a stack of functions undoing completed steps of multi-step operation,
+// so a failed operation doesn't leave the node half-configured.
+type rollback []func() error
+
+// add records function undoing the step just completed.
+func (r *rollback) add(undo func() error) {
+	*r = append(*r, undo)
+}
+
+// run undoes completed steps in reverse order and returns err caused the rollback.
+// If some steps can't be undone, their errors are appended to err.
+func (r rollback) run(err error) error {
+	var errs Errors
+	for i := len(r) - 1; i >= 0; i-- {
+		if e := r[i](); e != nil {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s (rollback failed: %s)", err, errs)
+	}
+	return err
+}
@@ -0,0 +1,336 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/percona/kardianos-service"
+	"github.com/percona/pmm-client/pmm/plugin"
+	"github.com/percona/pmm-client/pmm/plugin/exporter"
+	"github.com/percona/pmm-client/tests/fakeapi"
+	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeServices is a platform service manager with failure injection.
+type fakeServices struct {
+	installed   map[string]bool
+	failInstall bool
+	failStart   bool
+}
+
+func (s *fakeServices) New(i service.Interface, c *service.Config) (service.Service, error) {
+	return &fakeService{services: s, name: c.Name}, nil
+}
+
+type fakeService struct {
+	dummyService
+	services *fakeServices
+	name     string
+}
+
+func (s *fakeService) Install() error {
+	if s.services.failInstall {
+		return errors.New("install failed")
+	}
+	s.services.installed[s.name] = true
+	return nil
+}
+
+func (s *fakeService) Start() error {
+	if s.services.failStart {
+		return errors.New("start failed")
+	}
+	return nil
+}
+
+func (s *fakeService) Uninstall() error {
+	delete(s.services.installed, s.name)
+	return nil
+}
+
+// fakeQueries implements plugin.Queries.
+type fakeQueries struct {
+	dsn string
+}
+
+func (q fakeQueries) Init(ctx context.Context, pmmUserPassword string) (*plugin.Info, error) {
+	if q.dsn != "" {
+		return &plugin.Info{DSN: q.dsn}, nil
+	}
+	return &plugin.Info{DSN: "root:secret@tcp(localhost:3306)/"}, nil
+}
+func (fakeQueries) Name() string             { return "mysql" }
+func (fakeQueries) InstanceTypeName() string { return "mysql" }
+func (fakeQueries) Config() pc.QAN           { return pc.QAN{} }
+
+// setupRollbackTest returns Admin talking to fake API and installing services with fake service manager.
+func setupRollbackTest(t *testing.T) (*Admin, *fakeapi.FakeApi, *fakeServices, func()) {
+	api := fakeapi.New()
+	api.AppendConsulV1CatalogRegisteredNode("node1", &consul.Node{Address: "127.0.0.1"})
+	api.AppendConsulV1CatalogService()
+	api.AppendConsulV1CatalogRegister()
+	api.AppendConsulV1CatalogDeregister()
+	api.AppendConsulV1KVStore()
+	api.AppendQanAPIInstancesId("agent1", &proto.Instance{UUID: "agent1", ParentUUID: "os1"})
+	api.AppendQanAPIInstances([]*proto.Instance{{Subsystem: "mysql", UUID: "mysql1", ParentUUID: "os1"}})
+	api.AppendQanAPIAgents("agent1")
+	_, host, port := api.Start()
+
+	a := &Admin{
+		ServiceName: "db01",
+		Config: &Config{
+			ClientName:    "node1",
+			ClientAddress: "127.0.0.1",
+			BindAddress:   "127.0.0.1",
+		},
+		qanAPI:    NewAPI(nil, time.Second, false),
+		serverURL: fmt.Sprintf("http://%s:%s", host, port),
+	}
+	var err error
+	a.consulAPI, err = consul.NewClient(&consul.Config{Address: fmt.Sprintf("%s:%s", host, port)})
+	assert.Nil(t, err)
+
+	services := &fakeServices{installed: map[string]bool{}}
+	newService := NewService
+	NewService = services.New
+
+	// Agent is registered already.
+	agentBaseDir := AgentBaseDir
+	dir, err := ioutil.TempDir("", "qan-agent")
+	assert.Nil(t, err)
+	AgentBaseDir = dir
+	credentialsDir := CredentialsDir
+	CredentialsDir = filepath.Join(dir, "credentials")
+	os.MkdirAll(filepath.Join(dir, "config"), 0750)
+	os.MkdirAll(filepath.Join(dir, "instance"), 0750)
+	bytes, _ := json.Marshal(pc.Agent{UUID: "agent1"})
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "config", "agent.conf"), bytes, 0600))
+
+	return a, api, services, func() {
+		api.Close()
+		NewService = newService
+		AgentBaseDir = agentBaseDir
+		CredentialsDir = credentialsDir
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	var steps []string
+	var undo rollback
+	undo.add(func() error { steps = append(steps, "first"); return nil })
+	undo.add(func() error { steps = append(steps, "second"); return errors.New("second failed") })
+	undo.add(func() error { steps = append(steps, "third"); return nil })
+
+	err := undo.run(ErrDuplicate)
+	assert.Equal(t, []string{"third", "second", "first"}, steps)
+	assert.EqualError(t, err, ErrDuplicate.Error()+" (rollback failed: second failed)")
+
+	// Error is returned as is if there is nothing to undo, so callers can compare it.
+	assert.Equal(t, ErrDuplicate, rollback(nil).run(ErrDuplicate))
+}
+
+func TestAddMetricsRollback(t *testing.T) {
+	executable, err := os.Executable()
+	assert.Nil(t, err)
+	m := exporter.New(&exporter.Manifest{
+		Name:        "redis",
+		Binary:      executable,
+		DefaultPort: 42100,
+		DSN:         &exporter.DSN{Env: "REDIS_ADDR"},
+	}, "redis://localhost:6379", "")
+
+	for _, tc := range []struct {
+		name   string
+		inject func(api *fakeapi.FakeApi, services *fakeServices)
+	}{
+		{"register", func(api *fakeapi.FakeApi, services *fakeServices) { api.FailOn("PUT", "/v1/catalog/register") }},
+		{"kv", func(api *fakeapi.FakeApi, services *fakeServices) { api.FailOn("PUT", "/v1/kv/") }},
+		{"install", func(api *fakeapi.FakeApi, services *fakeServices) { services.failInstall = true }},
+		{"start", func(api *fakeapi.FakeApi, services *fakeServices) { services.failStart = true }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, api, services, teardown := setupRollbackTest(t)
+			defer teardown()
+			tc.inject(api, services)
+
+			_, err := a.AddMetrics(context.Background(), m, false, true)
+			assert.NotNil(t, err)
+			assert.NotContains(t, err.Error(), "rollback failed")
+			assert.Empty(t, services.installed)
+
+			registered := false
+			deregistered := false
+			for _, req := range api.Requests() {
+				switch req {
+				case "PUT /v1/catalog/register":
+					registered = true
+				case "PUT /v1/catalog/deregister":
+					deregistered = true
+				}
+			}
+			// Service is deregistered only if it was registered.
+			assert.Equal(t, tc.name != "register", deregistered)
+			assert.True(t, registered)
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		a, api, services, teardown := setupRollbackTest(t)
+		defer teardown()
+
+		_, err := a.AddMetrics(context.Background(), m, false, true)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]bool{"pmm-redis-metrics-42100": true}, services.installed)
+		assert.NotContains(t, api.Requests(), "PUT /v1/catalog/deregister")
+	})
+}
+
+func TestAddMetricsHealth(t *testing.T) {
+	executable, err := os.Executable()
+	assert.Nil(t, err)
+	m := exporter.New(&exporter.Manifest{
+		Name:        "redis",
+		Binary:      executable,
+		DefaultPort: 42100,
+		UpMetric:    "redis_up",
+		DSN:         &exporter.DSN{Env: "REDIS_ADDR"},
+	}, "redis://localhost:6379", "")
+
+	up := "1"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "# TYPE redis_up gauge\nredis_up %s\n", up)
+	}))
+	defer ts.Close()
+	_, p, err := net.SplitHostPort(ts.Listener.Addr().String())
+	assert.Nil(t, err)
+	tsPort, err := strconv.Atoi(p)
+	assert.Nil(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		up        string
+		rollback  bool
+		err       bool
+		installed bool
+	}{
+		{name: "healthy", up: "1", rollback: true, installed: true},
+		{name: "rollback", up: "0", rollback: true, err: true},
+		{name: "warning", up: "0", installed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, api, services, teardown := setupRollbackTest(t)
+			defer teardown()
+			up = tc.up
+			a.HealthTimeout = time.Second
+			a.HealthRollback = tc.rollback
+
+			// Installed service is checked on the port of fake exporter.
+			var checked []string
+			a.healthCheck = func(svcType, upMetric, svcName string, port int, disableSSL bool) error {
+				checked = append(checked, fmt.Sprintf("%s %s %s %d", svcType, upMetric, svcName, port))
+				return a.checkExporterHealth(svcType, upMetric, svcName, tsPort, true)
+			}
+
+			_, err := a.AddMetrics(context.Background(), m, false, true)
+			assert.Equal(t, tc.err, err != nil, "%v", err)
+			if err != nil {
+				assert.Contains(t, err.Error(), "redis:metrics exporter is not healthy after 1s: redis_up is 0")
+			}
+			assert.Equal(t, []string{"redis:metrics redis_up pmm-redis-metrics-42100 42100"}, checked)
+			assert.Equal(t, tc.installed, services.installed["pmm-redis-metrics-42100"])
+			if tc.installed {
+				assert.NotContains(t, api.Requests(), "PUT /v1/catalog/deregister")
+			} else {
+				assert.Contains(t, api.Requests(), "PUT /v1/catalog/deregister")
+			}
+		})
+	}
+}
+
+func TestAddQueriesRollback(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		inject func(api *fakeapi.FakeApi, services *fakeServices)
+		undone []string
+	}{
+		{
+			name:   "install",
+			inject: func(api *fakeapi.FakeApi, services *fakeServices) { services.failInstall = true },
+			undone: []string{"DELETE /qan-api/instances/mysql1"},
+		},
+		{
+			name:   "startQAN",
+			inject: func(api *fakeapi.FakeApi, services *fakeServices) { api.FailOn("PUT", "/qan-api/agents/") },
+			undone: []string{"DELETE /qan-api/instances/mysql1"},
+		},
+		{
+			name:   "register",
+			inject: func(api *fakeapi.FakeApi, services *fakeServices) { api.FailOn("PUT", "/v1/catalog/register") },
+			undone: []string{"PUT /qan-api/agents/agent1/cmd", "DELETE /qan-api/instances/mysql1"},
+		},
+		{
+			name:   "kv",
+			inject: func(api *fakeapi.FakeApi, services *fakeServices) { api.FailOn("PUT", "/v1/kv/") },
+			undone: []string{"DELETE /v1/kv/node1/mysql:queries-0/db01/", "PUT /v1/catalog/deregister", "PUT /qan-api/agents/agent1/cmd", "DELETE /qan-api/instances/mysql1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, api, services, teardown := setupRollbackTest(t)
+			defer teardown()
+			tc.inject(api, services)
+
+			_, err := a.AddQueries(context.Background(), fakeQueries{})
+			assert.NotNil(t, err)
+			assert.NotContains(t, err.Error(), "rollback failed")
+			assert.Empty(t, services.installed)
+			assert.False(t, FileExists(filepath.Join(AgentBaseDir, "instance", "mysql1.json")))
+
+			// Undo requests are sent after the failed one in reverse order.
+			requests := api.Requests()
+			assert.Equal(t, tc.undone, requests[len(requests)-len(tc.undone):])
+		})
+	}
+
+	t.Run("success", func(t *testing.T) {
+		a, api, services, teardown := setupRollbackTest(t)
+		defer teardown()
+
+		_, err := a.AddQueries(context.Background(), fakeQueries{})
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]bool{"pmm-mysql-queries-0": true}, services.installed)
+		assert.True(t, FileExists(filepath.Join(AgentBaseDir, "instance", "mysql1.json")))
+		assert.NotContains(t, api.Requests(), "DELETE /qan-api/instances/mysql1")
+	})
+}
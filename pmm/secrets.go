@@ -0,0 +1,280 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// encryptedPrefix marks secrets encrypted with the key file in config.
+const encryptedPrefix = "enc:"
+
+// secrets returns secret fields of config by their names in config file.
+func (c *Config) secrets() map[string]*string {
+	return map[string]*string{
+		"server_password":     &c.ServerPassword,
+		"mysql_password":      &c.MySQLPassword,
+		"postgresql_password": &c.PostgreSQLPassword,
+		"exporter_password":   &c.ExporterPassword,
+	}
+}
+
+// secretsProtected returns true if secrets are not stored in config in plain text.
+func (c *Config) secretsProtected() bool {
+	return c.SecretKeyFile != "" || c.PasswordCommand != ""
+}
+
+// decryptSecrets replaces encrypted secrets with plain ones, and fetches missing ones with password command.
+// Secret is not set if password command fails or prints nothing for it.
+func (c *Config) decryptSecrets() error {
+	var key []byte
+	c.commandSecrets = map[string]string{}
+	c.plainSecrets = map[string]string{}
+	for name, value := range c.secrets() {
+		switch {
+		case *value != "" && !strings.HasPrefix(*value, encryptedPrefix):
+			c.plainSecrets[name] = *value
+		case strings.HasPrefix(*value, encryptedPrefix):
+			if key == nil {
+				if c.SecretKeyFile == "" {
+					return fmt.Errorf("%s is encrypted but secret_key_file is not set", name)
+				}
+				var err error
+				if key, err = readSecretKey(c.SecretKeyFile); err != nil {
+					return err
+				}
+			}
+			plain, err := decryptSecret(key, *value)
+			if err != nil {
+				return fmt.Errorf("cannot decrypt %s: %s", name, err)
+			}
+			*value = plain
+		case *value == "" && c.PasswordCommand != "":
+			// Command may know only some secrets, e.g. server_password, others are generated later.
+			plain, err := runPasswordCommand(c.PasswordCommand, name)
+			if err != nil || plain == "" {
+				continue
+			}
+			*value = plain
+			c.commandSecrets[name] = plain
+		}
+	}
+	return nil
+}
+
+// encryptedCopy returns copy of config as it should be written to config file:
+// secrets provided by password command are omitted, others are encrypted if key file is set.
+// With password command but without key file, new or changed secrets can't be written.
+func (c *Config) encryptedCopy() (*Config, error) {
+	cp := *c
+	var key []byte
+	if c.SecretKeyFile != "" {
+		var err error
+		if key, err = readSecretKey(c.SecretKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range cp.secrets() {
+		if *value == "" {
+			continue
+		}
+		if v, ok := c.commandSecrets[name]; ok && v == *value {
+			*value = ""
+			continue
+		}
+		if key == nil && c.PasswordCommand != "" && c.plainSecrets[name] != *value {
+			return nil, fmt.Errorf("cannot write %s to config file in plain text: password_command is set without secret_key_file, run pmm-admin encrypt-config first", name)
+		}
+		if key != nil {
+			enc, err := encryptSecret(key, *value)
+			if err != nil {
+				return nil, err
+			}
+			*value = enc
+		}
+	}
+	return &cp, nil
+}
+
+// GenerateSecretKey writes new random key for encryption of secrets to file, existing file is not overwritten.
+func GenerateSecretKey(file string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readSecretKey reads AES-256 key written by GenerateSecretKey.
+func readSecretKey(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secret key: %s", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("%s: secret key should be 32 bytes encoded with base64", file)
+	}
+	return key, nil
+}
+
+// encryptSecret encrypts value with AES-GCM, the result is prefixed with encryptedPrefix.
+func encryptSecret(key []byte, value string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret decrypts value returned by encryptSecret.
+func decryptSecret(key []byte, value string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted value is too short")
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("wrong secret key or corrupted value")
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// runPasswordCommand runs command with the secret name as the last argument and returns its output.
+func runPasswordCommand(command, name string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", command+` "$1"`, "sh", name)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("password command failed for %s: %s %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// authFileArgRegex matches exporter flag pointing to the given auth file, e.g. -web.auth-file=/path/pmm.yml.
+func authFileArgRegex(file string) *regexp.Regexp {
+	return regexp.MustCompile(`([-\w.]*auth[-\w.]*=)` + regexp.QuoteMeta(file))
+}
+
+// EncryptConfig encrypts secrets in config with the key file, the key is generated if file doesn't exist.
+// Exporters still reading config are switched to AuthFile and restarted, the number of updated services is returned.
+func (a *Admin) EncryptConfig(keyFile string) (int, error) {
+	if a.Config.SecretKeyFile != "" {
+		return 0, fmt.Errorf("secrets are already encrypted with %s", a.Config.SecretKeyFile)
+	}
+	keyFile, err := filepath.Abs(keyFile)
+	if err != nil {
+		return 0, err
+	}
+	if !FileExists(keyFile) {
+		if err := GenerateSecretKey(keyFile); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := readSecretKey(keyFile); err != nil {
+		return 0, err
+	}
+
+	a.Config.SecretKeyFile = keyFile
+	if err := a.writeConfig(); err != nil {
+		return 0, err
+	}
+
+	// Exporters still reading credentials from config can't decrypt them.
+	legacy, err := legacyExporterFiles()
+	if err != nil || len(legacy) == 0 {
+		return 0, err
+	}
+	switched, err := a.ensureExporterAuth()
+	return len(switched), err
+}
+
+// replaceAuthFileArg points auth file flags of services in the given files from one file to another.
+// It returns the list of changed files.
+func replaceAuthFileArg(files []string, from, to string) ([]string, error) {
+	re := authFileArgRegex(from)
+	var updated []string
+	for _, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return updated, err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return updated, err
+		}
+		if !re.Match(data) {
+			continue
+		}
+		data = re.ReplaceAll(data, []byte("${1}"+to))
+		if err := ioutil.WriteFile(file, data, fi.Mode()); err != nil {
+			return updated, err
+		}
+		updated = append(updated, file)
+	}
+	return updated, nil
+}
+
+// copyACL copies access control list of file, so exporters running as other users can read AuthFile.
+// Errors are ignored as ACL tools may be missing and then there is nothing to copy.
+func copyACL(from, to string) {
+	exec.Command("/bin/sh", "-c", `getfacl -p "$1" | setfacl --set-file=- "$2"`, "sh", from, to).Run()
+}
@@ -0,0 +1,138 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-secrets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "pmm.key")
+	assert.Nil(t, GenerateSecretKey(keyFile))
+	assert.NotNil(t, GenerateSecretKey(keyFile), "existing key should not be overwritten")
+	key, err := readSecretKey(keyFile)
+	assert.Nil(t, err)
+
+	enc, err := encryptSecret(key, "secret")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(enc, encryptedPrefix))
+	assert.NotContains(t, enc, "secret")
+
+	plain, err := decryptSecret(key, enc)
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", plain)
+
+	other := make([]byte, 32)
+	_, err = decryptSecret(other, enc)
+	assert.NotNil(t, err)
+	_, err = decryptSecret(key, encryptedPrefix+"AAAA")
+	assert.NotNil(t, err)
+}
+
+func TestConfigSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-secrets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "pmm.key")
+	assert.Nil(t, GenerateSecretKey(keyFile))
+
+	c := &Config{ServerPassword: "server", MySQLPassword: "mysql", SecretKeyFile: keyFile}
+	enc, err := c.encryptedCopy()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(enc.ServerPassword, encryptedPrefix))
+	assert.True(t, strings.HasPrefix(enc.MySQLPassword, encryptedPrefix))
+	assert.Equal(t, "server", c.ServerPassword, "original config should not change")
+
+	assert.Nil(t, enc.decryptSecrets())
+	assert.Equal(t, "server", enc.ServerPassword)
+	assert.Equal(t, "mysql", enc.MySQLPassword)
+
+	// Encrypted secret can't be read without the key.
+	enc, _ = c.encryptedCopy()
+	enc.SecretKeyFile = ""
+	assert.NotNil(t, enc.decryptSecrets())
+
+	// Secrets provided by password command are not written back unless changed.
+	c = &Config{MySQLPassword: "mysql", PasswordCommand: "echo from-command"}
+	assert.Nil(t, c.decryptSecrets())
+	assert.Equal(t, "from-command server_password", c.ServerPassword)
+	assert.Equal(t, "mysql", c.MySQLPassword)
+	enc, err = c.encryptedCopy()
+	assert.Nil(t, err)
+	assert.Equal(t, "", enc.ServerPassword)
+	assert.Equal(t, "mysql", enc.MySQLPassword)
+
+	// Changed or generated secrets are not written in plain text next to password command.
+	c.ServerPassword = "changed"
+	_, err = c.encryptedCopy()
+	assert.NotNil(t, err)
+	c.ServerPassword = "from-command server_password"
+	c.ExporterPassword = "generated"
+	_, err = c.encryptedCopy()
+	assert.NotNil(t, err)
+
+	// They are encrypted if key file is set too.
+	c.SecretKeyFile = keyFile
+	c.ServerPassword = "changed"
+	enc, err = c.encryptedCopy()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(enc.ServerPassword, encryptedPrefix))
+	assert.True(t, strings.HasPrefix(enc.ExporterPassword, encryptedPrefix))
+
+	// Secrets unknown to password command are not set.
+	c = &Config{PasswordCommand: `test "$1" = server_password && echo server || exit 1`}
+	assert.Nil(t, c.decryptSecrets())
+	assert.Equal(t, "server", c.ServerPassword)
+	assert.Equal(t, "", c.MySQLPassword)
+	assert.Equal(t, "", c.ExporterPassword)
+
+	c = &Config{PasswordCommand: "true"}
+	assert.Nil(t, c.decryptSecrets())
+	assert.Equal(t, "", c.ServerPassword)
+}
+
+func TestReplaceAuthFileArg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pmm-secrets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	exporter := filepath.Join(dir, "pmm-mysql-metrics-42002.service")
+	push := filepath.Join(dir, "pmm-push.service")
+	assert.Nil(t, ioutil.WriteFile(exporter, []byte("ExecStart=/bin/mysqld_exporter -web.listen-address=:42002 -web.auth-file=/pmm/pmm.yml\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(push, []byte("ExecStart=/bin/pmm-admin push run --config-file=/pmm/pmm.yml\n"), 0644))
+
+	updated, err := replaceAuthFileArg([]string{exporter, push}, "/pmm/pmm.yml", "/pmm/auth.yml")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{exporter}, updated)
+
+	data, _ := ioutil.ReadFile(exporter)
+	assert.Equal(t, "ExecStart=/bin/mysqld_exporter -web.listen-address=:42002 -web.auth-file=/pmm/auth.yml\n", string(data))
+	data, _ = ioutil.ReadFile(push)
+	assert.Contains(t, string(data), "--config-file=/pmm/pmm.yml")
+}
@@ -18,11 +18,25 @@
 package pmm
 
 import (
+	"fmt"
+	"log"
+
 	service "github.com/percona/kardianos-service"
+	"github.com/percona/pmm-client/pmm/supervisor"
+)
+
+// Service managers which can be set in config in addition to the platform one.
+const (
+	ServiceManagerAuto       = "auto"
+	ServiceManagerSupervisor = "supervisor"
 )
 
 var (
 	NewService func(i service.Interface, c *service.Config) (service.Service, error) = service.New
+
+	// serviceManager is a service manager set in config, empty for the platform one.
+	serviceManager     string
+	platformNewService func(i service.Interface, c *service.Config) (service.Service, error)
 )
 
 // @todo don't use singleton init, use dependency injection
@@ -35,6 +49,34 @@ func init() {
 	}
 }
 
+// useServiceManager switches NewService to the given service manager, empty name selects the platform one.
+func useServiceManager(name string) error {
+	if name == serviceManager {
+		return nil
+	}
+	if serviceManager == "" {
+		platformNewService = NewService
+	}
+	switch name {
+	case "":
+		NewService = platformNewService
+	case ServiceManagerSupervisor:
+		NewService = supervisor.New(SupervisorDir)
+	default:
+		return fmt.Errorf("unknown service manager %s, should be either %s or %s", name, ServiceManagerSupervisor, ServiceManagerAuto)
+	}
+	serviceManager = name
+	return nil
+}
+
+// Platform returns name of the service manager in use.
+func Platform() string {
+	if serviceManager != "" {
+		return serviceManager
+	}
+	return service.Platform()
+}
+
 type dummyService struct {
 }
 
@@ -77,7 +119,12 @@ func installService(svcConfig *service.Config) error {
 	if err := svc.Install(); err != nil {
 		return err
 	}
+	if err := writeLogRotateConfig(); err != nil {
+		log.Printf("WARNING: unable to write log rotation config %s: %s", LogRotateFile, err)
+	}
 	if err := svc.Start(); err != nil {
+		// Don't leave installed but not running service behind.
+		svc.Uninstall()
 		return err
 	}
 	return nil
@@ -98,6 +145,8 @@ func uninstallService(name string) error {
 	if err := svc.Uninstall(); err != nil {
 		return err
 	}
+	removeResources(name)
+	removeCredentials(name)
 	return nil
 }
 
@@ -131,6 +180,14 @@ func stopService(name string) error {
 	return nil
 }
 
+// restartService stops and starts service, so it reads changed definition and files.
+func restartService(name string) error {
+	if err := stopService(name); err != nil {
+		return err
+	}
+	return startService(name)
+}
+
 func getServiceStatus(name string) bool {
 	prg := &program{}
 	svcConfig := &service.Config{Name: name}
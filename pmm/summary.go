@@ -30,7 +30,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/percona/kardianos-service"
+	"github.com/percona/pmm-client/pmm/plugin"
 )
 
 // Collector parameters and description.
@@ -73,11 +73,13 @@ func CheckMonitoredDBServices() []string {
 		fmt.Println("Error exec pmm-admin list", err)
 		return nil
 	}
-	if strings.Contains(string(cmdPmmList), "mysql:metrics") {
-		monitoredDBServices = append(monitoredDBServices, "mysql")
-	}
-	if strings.Contains(string(cmdPmmList), "mongodb:metrics") {
-		monitoredDBServices = append(monitoredDBServices, "mongodb")
+	for _, r := range plugin.Registrations() {
+		if r.Kind != plugin.KindMetrics || r.Name == "linux" {
+			continue
+		}
+		if strings.Contains(string(cmdPmmList), r.Type()) {
+			monitoredDBServices = append(monitoredDBServices, r.Name)
+		}
 	}
 
 	return monitoredDBServices
@@ -209,7 +211,7 @@ func (a *Admin) CollectSummary() error {
 			[]string{"netstat", "-punta"},
 			filepath.Join(dirname, strings.Join([]string{"netstat_", cmdHostname, ".txt"}, ""))}}
 
-	switch service.Platform() {
+	switch Platform() {
 	case "linux-upstart":
 		Collectors = append(Collectors, Collector{"Collect service output",
 			[]string{"service", "--status-all"},
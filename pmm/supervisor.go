@@ -0,0 +1,77 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package pmm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/percona/pmm-client/pmm/supervisor"
+	"github.com/percona/pmm-client/pmm/utils"
+)
+
+// RunSupervisor runs monitoring services of this node in foreground until ctx is canceled.
+// Services installed locally but not registered for this node on PMM Server are not started.
+// If PMM Server is not reachable, all installed services are started.
+func (a *Admin) RunSupervisor(ctx context.Context, signals <-chan os.Signal) error {
+	if serviceManager != ServiceManagerSupervisor {
+		return fmt.Errorf("Service manager is %s. Use 'pmm-admin config --service-manager=%s' to run services in foreground.",
+			Platform(), ServiceManagerSupervisor)
+	}
+
+	orphaned, err := a.orphanedServices()
+	if err != nil {
+		log.Printf("WARNING: cannot get services registered for this node, starting all installed services: %s", err)
+	}
+	skip := func(name string) bool {
+		return orphaned[name]
+	}
+	// Exporters may log their DSN.
+	return supervisor.Run(ctx, SupervisorDir, skip, utils.NewRedactWriter(os.Stdout), signals)
+}
+
+// orphanedServices returns local services not registered for this node on Consul.
+// Services added later are not in the list, so they are started by supervisor as soon as installed.
+func (a *Admin) orphanedServices() (map[string]bool, error) {
+	if err := a.SetAPI(); err != nil {
+		return nil, err
+	}
+	node, _, err := a.consulAPI.Catalog().Node(a.Config.ClientName, nil)
+	if err != nil {
+		return nil, err
+	}
+	registered := map[string]bool{
+		// Push agent is not registered on Consul.
+		PushServiceName: true,
+	}
+	if node != nil {
+		for _, svc := range node.Services {
+			registered[fmt.Sprintf("pmm-%s-%d", strings.Replace(svc.Service, ":", "-", 1), svc.Port)] = true
+		}
+	}
+	orphaned := map[string]bool{}
+	for _, s := range GetLocalServices() {
+		if !registered[s] {
+			orphaned[s] = true
+		}
+	}
+	return orphaned, nil
+}
@@ -0,0 +1,245 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Timings of the supervisor, variables to speed up tests.
+var (
+	ScanInterval = time.Second
+	MinBackoff   = time.Second
+	MaxBackoff   = time.Minute
+	StopTimeout  = 10 * time.Second
+)
+
+// child is a running service.
+type child struct {
+	unit   unit
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	m   sync.Mutex
+	cmd *exec.Cmd
+}
+
+// signal sends signal to the current process of the child, if any.
+func (c *child) signal(sig os.Signal) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.cmd != nil && c.cmd.Process != nil {
+		c.cmd.Process.Signal(sig)
+	}
+}
+
+// Run starts services installed to dir as child processes and keeps them running until ctx is canceled.
+// Unit files are rescanned periodically: removed and stopped services are stopped, changed ones are restarted.
+// Services for which skip returns true are not started. Output of children is written to out line by line,
+// prefixed with the service name. Signals received from signals are forwarded to all children.
+func Run(ctx context.Context, dir string, skip func(name string) bool, out io.Writer, signals <-chan os.Signal) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	w := &lineWriter{w: out}
+	children := map[string]*child{}
+	stop := func(name string) {
+		c := children[name]
+		c.cancel()
+		<-c.done
+		delete(children, name)
+	}
+	defer func() {
+		for name := range children {
+			stop(name)
+		}
+	}()
+
+	ticker := time.NewTicker(ScanInterval)
+	defer ticker.Stop()
+	for {
+		units, err := scan(dir)
+		if err != nil {
+			w.printf("supervisor", "%s", err)
+		}
+		for name, c := range children {
+			if u, ok := units[name]; !ok || !reflect.DeepEqual(*u, c.unit) {
+				w.printf("supervisor", "stopping %s", name)
+				stop(name)
+			}
+		}
+		for name, u := range units {
+			if _, ok := children[name]; ok || u.Stopped || (skip != nil && skip(name)) {
+				continue
+			}
+			w.printf("supervisor", "starting %s", name)
+			children[name] = start(ctx, dir, *u, w)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case sig := <-signals:
+			for _, c := range children {
+				c.signal(sig)
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan reads all unit files in dir.
+func scan(dir string) (map[string]*unit, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	units := map[string]*unit{}
+	var errs []string
+	for _, file := range files {
+		u, err := readUnit(file)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		units[u.Name] = u
+	}
+	if len(errs) > 0 {
+		return units, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return units, nil
+}
+
+// start runs the service in a goroutine, restarting it with backoff until stopped.
+func start(ctx context.Context, dir string, u unit, w *lineWriter) *child {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &child{
+		unit:   u,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(c.done)
+		defer os.Remove(pidFile(dir, u.Name))
+
+		backoff := MinBackoff
+		for {
+			started := time.Now()
+			err := c.run(ctx, dir, w)
+			if ctx.Err() != nil {
+				return
+			}
+			if time.Since(started) > MaxBackoff {
+				backoff = MinBackoff
+			}
+			w.printf(u.Name, "exited: %v, restarting in %s", err, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > MaxBackoff {
+				backoff = MaxBackoff
+			}
+		}
+	}()
+	return c
+}
+
+// run runs the service process once, it is terminated when ctx is canceled.
+func (c *child) run(ctx context.Context, dir string, w *lineWriter) error {
+	name := c.unit.Name
+	cmd := exec.Command(c.unit.Executable, c.unit.Arguments...)
+	cmd.Env = append(os.Environ(), c.unit.Environment...)
+	cmd.Dir = c.unit.WorkingDirectory
+	stdout := w.prefixed(name)
+	defer stdout.Close()
+	cmd.Stdout = stdout
+	cmd.Stderr = stdout
+
+	c.m.Lock()
+	err := cmd.Start()
+	if err == nil {
+		c.cmd = cmd
+	}
+	c.m.Unlock()
+	if err != nil {
+		return err
+	}
+	ioutil.WriteFile(pidFile(dir, name), []byte(strconv.Itoa(cmd.Process.Pid)), 0600)
+
+	waitErr := make(chan error, 1)
+	go func() {
+		waitErr <- cmd.Wait()
+	}()
+	select {
+	case err = <-waitErr:
+	case <-ctx.Done():
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case err = <-waitErr:
+		case <-time.After(StopTimeout):
+			cmd.Process.Kill()
+			err = <-waitErr
+		}
+	}
+
+	c.m.Lock()
+	c.cmd = nil
+	c.m.Unlock()
+	return err
+}
+
+// lineWriter serializes lines written by children to out.
+type lineWriter struct {
+	m sync.Mutex
+	w io.Writer
+}
+
+func (w *lineWriter) printf(prefix, format string, a ...interface{}) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	fmt.Fprintf(w.w, "%s | %s\n", prefix, fmt.Sprintf(format, a...))
+}
+
+// prefixed returns writer which prefixes every line with the given prefix, it should be closed after use.
+func (w *lineWriter) prefixed(prefix string) io.WriteCloser {
+	r, pw := io.Pipe()
+	go func() {
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		for s.Scan() {
+			w.printf(prefix, "%s", s.Text())
+		}
+		r.CloseWithError(s.Err())
+	}()
+	return pw
+}
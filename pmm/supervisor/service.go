@@ -0,0 +1,202 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package supervisor implements service manager for systems without init system, e.g. containers.
+// Services are installed as unit files and run as child processes of Run.
+package supervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	service "github.com/percona/kardianos-service"
+)
+
+// unit is a service installed to supervisor.
+type unit struct {
+	Name             string
+	Executable       string
+	Arguments        []string `json:",omitempty"`
+	Environment      []string `json:",omitempty"`
+	WorkingDirectory string   `json:",omitempty"`
+	// Stopped is true if service should not run.
+	Stopped bool `json:",omitempty"`
+	// Generation is incremented on restart.
+	Generation int `json:",omitempty"`
+}
+
+// Service implements service.Service, it only changes unit files, processes are managed by Run.
+type Service struct {
+	dir    string
+	config *service.Config
+}
+
+// New returns constructor of services keeping unit files in the given directory,
+// it is a drop-in replacement of service.New.
+func New(dir string) func(i service.Interface, c *service.Config) (service.Service, error) {
+	return func(i service.Interface, c *service.Config) (service.Service, error) {
+		if c.Name == "" {
+			return nil, service.ErrNameFieldRequired
+		}
+		return &Service{dir: dir, config: c}, nil
+	}
+}
+
+// UnitFile returns path to unit file of service.
+func UnitFile(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+// pidFile returns path to file with PID of running service.
+func pidFile(dir, name string) string {
+	return filepath.Join(dir, name+".pid")
+}
+
+// Run is not supported, services are run by supervisor.Run.
+func (s *Service) Run() error {
+	return errors.New("supervisor: services are run by 'pmm-admin run'")
+}
+
+// Start marks service to be started.
+func (s *Service) Start() error {
+	return s.update(func(u *unit) {
+		u.Stopped = false
+	})
+}
+
+// Stop marks service to be stopped.
+func (s *Service) Stop() error {
+	return s.update(func(u *unit) {
+		u.Stopped = true
+	})
+}
+
+// Restart marks service to be restarted.
+func (s *Service) Restart() error {
+	return s.update(func(u *unit) {
+		u.Stopped = false
+		u.Generation++
+	})
+}
+
+// Install writes unit file of service.
+func (s *Service) Install() error {
+	if _, err := os.Stat(UnitFile(s.dir, s.config.Name)); err == nil {
+		return fmt.Errorf("service %s is already installed", s.config.Name)
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	return writeUnit(s.dir, &unit{
+		Name:             s.config.Name,
+		Executable:       s.config.Executable,
+		Arguments:        s.config.Arguments,
+		Environment:      s.config.Environment,
+		WorkingDirectory: s.config.WorkingDirectory,
+		Stopped:          true,
+	})
+}
+
+// Uninstall removes unit file of service.
+func (s *Service) Uninstall() error {
+	err := os.Remove(UnitFile(s.dir, s.config.Name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("service %s is not installed", s.config.Name)
+	}
+	return err
+}
+
+// Status returns nil if service is running.
+func (s *Service) Status() error {
+	u, err := readUnit(UnitFile(s.dir, s.config.Name))
+	if err != nil {
+		return err
+	}
+	if u.Stopped {
+		return fmt.Errorf("service %s is stopped", s.config.Name)
+	}
+	b, err := ioutil.ReadFile(pidFile(s.dir, s.config.Name))
+	if err != nil {
+		return fmt.Errorf("service %s is not running, check that 'pmm-admin run' is running", s.config.Name)
+	}
+	pid, err := strconv.Atoi(string(b))
+	if err != nil {
+		return err
+	}
+	if p, err := os.FindProcess(pid); err != nil || p.Signal(syscall.Signal(0)) != nil {
+		return fmt.Errorf("service %s is not running", s.config.Name)
+	}
+	return nil
+}
+
+// Logger returns logger writing to stderr.
+func (s *Service) Logger(errs chan<- error) (service.Logger, error) {
+	return service.ConsoleLogger, nil
+}
+
+// SystemLogger returns logger writing to stderr.
+func (s *Service) SystemLogger(errs chan<- error) (service.Logger, error) {
+	return service.ConsoleLogger, nil
+}
+
+// String returns name of service.
+func (s *Service) String() string {
+	return s.config.Name
+}
+
+// update changes unit file of installed service.
+func (s *Service) update(f func(u *unit)) error {
+	u, err := readUnit(UnitFile(s.dir, s.config.Name))
+	if err != nil {
+		return err
+	}
+	f(u)
+	return writeUnit(s.dir, u)
+}
+
+func readUnit(file string) (*unit, error) {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("service %s is not installed", filepath.Base(file))
+	}
+	if err != nil {
+		return nil, err
+	}
+	u := &unit{}
+	if err := json.Unmarshal(b, u); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return u, nil
+}
+
+func writeUnit(dir string, u *unit) error {
+	b, err := json.MarshalIndent(u, "", "  ")
+	if err != nil {
+		return err
+	}
+	file := UnitFile(dir, u.Name)
+	if err := ioutil.WriteFile(file+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(file+".tmp", file)
+}
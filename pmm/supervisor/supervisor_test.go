@@ -0,0 +1,142 @@
+/*
+	Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published by
+	the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	service "github.com/percona/kardianos-service"
+	"github.com/stretchr/testify/assert"
+)
+
+type syncBuffer struct {
+	m sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.b.String()
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	for i := 0; i < 100; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func TestService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "supervisor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	svc, err := New(dir)(nil, &service.Config{
+		Name:        "pmm-test",
+		Executable:  "/bin/true",
+		Arguments:   []string{"-a"},
+		Environment: []string{"A=b"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, svc.Install())
+	assert.NotNil(t, svc.Install())
+
+	u, err := readUnit(UnitFile(dir, "pmm-test"))
+	assert.Nil(t, err)
+	assert.Equal(t, &unit{Name: "pmm-test", Executable: "/bin/true", Arguments: []string{"-a"}, Environment: []string{"A=b"}, Stopped: true}, u)
+
+	assert.Nil(t, svc.Start())
+	assert.Nil(t, svc.Restart())
+	u, err = readUnit(UnitFile(dir, "pmm-test"))
+	assert.Nil(t, err)
+	assert.False(t, u.Stopped)
+	assert.Equal(t, 1, u.Generation)
+
+	// Not running without supervisor.
+	assert.NotNil(t, svc.Status())
+
+	assert.Nil(t, svc.Uninstall())
+	assert.NotNil(t, svc.Uninstall())
+	assert.NotNil(t, svc.Start())
+}
+
+func TestRun(t *testing.T) {
+	ScanInterval = 50 * time.Millisecond
+	MinBackoff = 50 * time.Millisecond
+
+	dir, err := ioutil.TempDir("", "supervisor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	newService := New(dir)
+	install := func(name, script string) service.Service {
+		svc, err := newService(nil, &service.Config{
+			Name:        name,
+			Executable:  "/bin/sh",
+			Arguments:   []string{"-c", script},
+			Environment: []string{"GREETING=hello"},
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, svc.Install())
+		assert.Nil(t, svc.Start())
+		return svc
+	}
+	sleeper := install("pmm-sleeper", "echo $GREETING; exec sleep 60")
+	install("pmm-crasher", "echo crash; exit 1")
+	install("pmm-skipped", "echo skipped; exec sleep 60")
+
+	out := &syncBuffer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- Run(ctx, dir, func(name string) bool { return name == "pmm-skipped" }, out, nil)
+	}()
+
+	waitFor(t, func() bool { return sleeper.Status() == nil })
+	waitFor(t, func() bool { return strings.Count(out.String(), "pmm-crasher | crash\n") >= 2 })
+	assert.Contains(t, out.String(), "pmm-sleeper | hello\n")
+	assert.Contains(t, out.String(), "pmm-crasher | exited: exit status 1, restarting in")
+	assert.NotContains(t, out.String(), "skipped")
+
+	// Stopped service is terminated.
+	assert.Nil(t, sleeper.Stop())
+	waitFor(t, func() bool { return strings.Contains(out.String(), "supervisor | stopping pmm-sleeper\n") })
+	assert.NotNil(t, sleeper.Status())
+
+	cancel()
+	assert.Nil(t, <-done)
+	_, err = os.Stat(pidFile(dir, "pmm-crasher"))
+	assert.True(t, os.IsNotExist(err))
+}
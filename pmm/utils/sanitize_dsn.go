@@ -1,23 +1,38 @@
 package utils
 
 import (
-	"fmt"
+	"regexp"
 	"strings"
 )
 
+var (
+	schemeRegex = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+	// kvDSNRegex matches DSN in key=value form, e.g. PostgreSQL "host=localhost user=pmm password=secret".
+	kvDSNRegex      = regexp.MustCompile(`^\s*\w+\s*=`)
+	kvPasswordRegex = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+)
+
 // SanitizeDSN removes password from DSN.
+// It supports URLs with any scheme (MongoDB, PostgreSQL), MySQL DSN and key=value form.
 func SanitizeDSN(dsn string) string {
-	dsn = strings.TrimRight(strings.Split(dsn, "?")[0], "/")
-	dsn = strings.TrimPrefix(dsn, "mongodb://")
-	if strings.Index(dsn, "@") > 0 {
-		dsnParts := strings.Split(dsn, "@")
-		userPart := dsnParts[0]
-		hostPart := ""
-		if len(dsnParts) > 1 {
-			hostPart = dsnParts[len(dsnParts)-1]
-		}
-		userPasswordParts := strings.Split(userPart, ":")
-		dsn = fmt.Sprintf("%s:***@%s", userPasswordParts[0], hostPart)
+	if kvDSNRegex.MatchString(dsn) {
+		return kvPasswordRegex.ReplaceAllString(dsn, "${1}"+Mask)
+	}
+
+	dsn = schemeRegex.ReplaceAllString(dsn, "")
+	// Password may contain @, host part can't.
+	userPart, hostPart := "", dsn
+	if i := strings.LastIndex(dsn, "@"); i > 0 {
+		userPart, hostPart = dsn[:i], dsn[i+1:]
+	}
+	hostPart = strings.TrimRight(strings.Split(hostPart, "?")[0], "/")
+	if userPart == "" {
+		return hostPart
+	}
+	// Mask only the password which is set, so DSN without it doesn't look like it has one.
+	parts := strings.SplitN(userPart, ":", 2)
+	if len(parts) == 1 || parts[1] == "" {
+		return parts[0] + "@" + hostPart
 	}
-	return dsn
+	return parts[0] + ":" + Mask + "@" + hostPart
 }
@@ -40,10 +40,11 @@ type verboseRoundTripper struct {
 // a Response for the provided Request and logs response and request with std logger
 func (v *verboseRoundTripper) RoundTrip(req *http.Request) (resp *http.Response, err error) {
 	resp, err = v.parent.RoundTrip(req)
-	log.Printf("request:\n%s", dumpRequest(req))
+	// Dumps include Authorization header and credentials in URLs and bodies.
+	log.Printf("request:\n%s", Redact(dumpRequest(req)))
 	if err == nil {
 		// we can process response only if there was no error
-		log.Printf("response:\n%s", dumpResponse(resp))
+		log.Printf("response:\n%s", Redact(dumpResponse(resp)))
 	}
 	return resp, err
 }
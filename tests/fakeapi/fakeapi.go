@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
 )
 
@@ -31,11 +32,15 @@ type FakeApi struct {
 	serveMux            *http.ServeMux
 	ctx                 context.Context
 	baseURL, host, port string
+	failures            []string
+	requests            []string
 	sync.RWMutex
 }
 
 func New() *FakeApi {
-	fakeApi := &FakeApi{}
+	fakeApi := &FakeApi{
+		ctx: context.Background(),
+	}
 	fakeApi.serveMux = http.NewServeMux()
 	return fakeApi
 }
@@ -61,5 +66,32 @@ func (f *FakeApi) Close() {
 }
 
 func (f *FakeApi) Append(pattern string, handler func(http.ResponseWriter, *http.Request)) {
-	f.serveMux.HandleFunc(pattern, handler)
+	f.serveMux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		req := r.Method + " " + r.URL.Path
+		f.Lock()
+		f.requests = append(f.requests, req)
+		failures := f.failures
+		f.Unlock()
+		for _, failure := range failures {
+			if strings.HasPrefix(req, failure) {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		handler(w, r)
+	})
+}
+
+// FailOn makes requests with the given method to the paths starting with the given prefix fail with status code 500.
+func (f *FakeApi) FailOn(method, pathPrefix string) {
+	f.Lock()
+	defer f.Unlock()
+	f.failures = append(f.failures, method+" "+pathPrefix)
+}
+
+// Requests returns handled requests in the form "METHOD /path".
+func (f *FakeApi) Requests() []string {
+	f.RLock()
+	defer f.RUnlock()
+	return append([]string{}, f.requests...)
 }
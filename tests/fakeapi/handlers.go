@@ -24,6 +24,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"path"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"net"
@@ -111,6 +114,26 @@ func (f *FakeApi) AppendConsulV1CatalogNode(name string, node api.CatalogNode) {
 	})
 }
 
+// AppendConsulV1CatalogRegisteredNode adds "/v1/catalog/node/<name>" route returning services
+// registered with AppendConsulV1CatalogRegister route.
+func (f *FakeApi) AppendConsulV1CatalogRegisteredNode(name string, node *api.Node) {
+	f.Append("/v1/catalog/node/"+name, func(w http.ResponseWriter, r *http.Request) {
+		out := api.CatalogNode{Node: node, Services: map[string]*api.AgentService{}}
+		rrs, _ := f.ctx.Value("/v1/catalog/register").(map[string]structs.RegisterRequest)
+		for _, rr := range rrs {
+			out.Services[rr.Service.ID] = &api.AgentService{
+				ID:      rr.Service.ID,
+				Service: rr.Service.Service,
+				Tags:    rr.Service.Tags,
+				Port:    rr.Service.Port,
+			}
+		}
+		data, _ := json.Marshal(out)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	})
+}
+
 func (f *FakeApi) AppendConsulV1CatalogService() {
 	f.Append("/v1/catalog/service/", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -173,6 +196,30 @@ func (f *FakeApi) AppendConsulV1CatalogRegister() {
 	})
 }
 
+// AppendConsulV1CatalogDeregister adds "/v1/catalog/deregister" route to API.
+func (f *FakeApi) AppendConsulV1CatalogDeregister() {
+	f.Append("/v1/catalog/deregister", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PUT":
+			dr := structs.DeregisterRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&dr); err != nil {
+				panic(fmt.Sprintf("error unmarshaling body: %s", err))
+			}
+			if rrs, ok := f.ctx.Value("/v1/catalog/register").(map[string]structs.RegisterRequest); ok {
+				for name, rr := range rrs {
+					if rr.Service.ID == dr.ServiceID {
+						delete(rrs, name)
+					}
+				}
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(600)
+			panic(fmt.Sprintf("fakeapi: unknown method %s for path %s", r.Method, r.URL.Path))
+		}
+	})
+}
+
 func (f *FakeApi) AppendConsulV1KV() {
 	f.Append("/v1/kv/", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -181,7 +228,51 @@ func (f *FakeApi) AppendConsulV1KV() {
 			data, _ := json.Marshal(out)
 			w.WriteHeader(http.StatusOK)
 			w.Write(data)
+		case "PUT", "DELETE":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(600)
+		}
+	})
+}
+
+// AppendConsulV1KVStore is like AppendConsulV1KV but keeps put values, so they can be read back.
+func (f *FakeApi) AppendConsulV1KVStore() {
+	var m sync.Mutex
+	kv := map[string][]byte{}
+	f.Append("/v1/kv/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		_, recurse := r.URL.Query()["recurse"]
+		match := func(k string) bool {
+			return k == key || recurse && strings.HasPrefix(k, key)
+		}
+		m.Lock()
+		defer m.Unlock()
+		switch r.Method {
+		case "GET":
+			out := api.KVPairs{}
+			for k, v := range kv {
+				if match(k) {
+					out = append(out, &api.KVPair{Key: k, Value: v})
+				}
+			}
+			if len(out) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+			data, _ := json.Marshal(out)
+			w.WriteHeader(http.StatusOK)
+			w.Write(data)
 		case "PUT":
+			kv[key], _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		case "DELETE":
+			for k := range kv {
+				if match(k) {
+					delete(kv, k)
+				}
+			}
 			w.WriteHeader(http.StatusOK)
 		default:
 			w.WriteHeader(600)
@@ -196,7 +287,7 @@ func (f *FakeApi) AppendQanAPIInstances(protoInstances []*proto.Instance) {
 	}
 	f.Append("/qan-api/instances/", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
-		case "PUT":
+		case "PUT", "DELETE":
 			w.WriteHeader(http.StatusNoContent)
 		case "GET":
 			t := r.URL.Query().Get("type")